@@ -1,31 +1,47 @@
 package app
 
 import (
+	"encoding/json"
+	"errors"
 	"fmt"
-	"log"
+	"log/slog"
 	"net/http"
+	"os"
+	"time"
+	"wallet/app/auth"
 	"wallet/app/handler"
+	"wallet/app/logging"
 
 	"wallet/app/model"
+	"wallet/app/store"
 	"wallet/config"
 
 	"github.com/gorilla/mux"
 	"github.com/jinzhu/gorm"
-	"github.com/urfave/negroni"
 )
 
 type App struct {
 	Router *mux.Router
 	DB     *gorm.DB
+	Store  store.Store
+	Logger *slog.Logger
 }
 
+// Route is an API endpoint. Its timeout defaults to REQUEST_TIMEOUT.
 type Route struct {
 	route   string
 	handler func(w http.ResponseWriter, r *http.Request)
 	method  string
+	timeout time.Duration
 }
 
-func (a *App) InitializeAndRun(config *config.Config, port string) {
+// Initialize connects to and migrates the database. The handlers and the
+// database log with a.Logger, or with slog's default logger when it is nil.
+func (a *App) Initialize(config *config.Config) {
+	if a.Logger == nil {
+		a.Logger = slog.Default()
+	}
+	handler.Logger = a.Logger
 	dbURI := fmt.Sprintf("%s:%s@tcp(%s:%d)/%s?charset=%s&parseTime=True",
 		config.DB.Username,
 		config.DB.Password,
@@ -36,22 +52,64 @@ func (a *App) InitializeAndRun(config *config.Config, port string) {
 
 	db, err := gorm.Open(config.DB.Dialect, dbURI)
 	if err != nil {
-		log.Fatal(fmt.Sprintf("connection failed to dbwith err : %#v  ", err.Error()))
+		a.fatal("failed to connect to the database", err)
+	}
+	db.SetLogger(logging.GormLogger{Logger: a.Logger})
+	if config.Log.SQL {
+		db.LogMode(true)
+	}
+	a.DB, err = model.DBMigrate(db)
+	if err != nil {
+		var failed *model.MigrationError
+		if errors.As(err, &failed) {
+			a.fatal("migration failed", failed.Err, "migration", failed.ID)
+		}
+		a.fatal("failed to migrate the database", err)
 	}
-	a.DB = model.DBMigrate(db)
+	a.Store = store.NewGormStore(a.DB)
+}
+
+func (a *App) InitializeAndRun(config *config.Config, port string) {
+	a.Initialize(config)
+	if len(config.Auth.Keys) == 0 {
+		a.Logger.Warn("JWT_KEYS is not set, every request will be rejected as unauthenticated")
+	}
+	verifier := auth.NewVerifier(config.Auth.Keys)
+	handler.IdempotencyRetention = config.Idempotency.Retention
+	handler.HoldTTL = config.Hold.TTL
+	handler.ScheduleMaxAttempts = config.Schedule.MaxAttempts
+	handler.ScheduleRetryDelay = config.Schedule.RetryDelay
+	handler.WebhookMaxAttempts = config.Webhook.MaxAttempts
+	handler.WebhookRetryDelay = config.Webhook.RetryDelay
+	go a.purgeExpiredIdempotencyKeys(time.Hour)
+	go a.expireHolds(time.Minute)
+	go a.runSchedules(time.Minute)
+	go a.deliverWebhooks(5 * time.Second)
 	router := mux.NewRouter()
 	routes := getRouter(a)
 	for _, route := range routes {
-		router.HandleFunc(route.route, route.handler).Methods(route.method)
+		timeout := route.timeout
+		if timeout == 0 {
+			timeout = config.Server.RequestTimeout
+		}
+		router.HandleFunc(route.route, handler.Timeout(timeout, handler.Authenticate(verifier, route.handler))).Methods(route.method)
 	}
-	n := negroni.New()
-	n.Use(negroni.NewLogger())
-	n.UseHandler(router)
-	http.ListenAndServe(port, n)
+	server := handler.RequestID(handler.Log(handler.Recover(handler.LimitBody(config.Server.MaxBodyBytes, router.ServeHTTP))))
+	a.fatal("server stopped", http.ListenAndServe(port, server))
 }
 
 func getRouter(a *App) []Route {
 	return []Route{
+		{
+			route:   "/walletapi/owner",
+			handler: a.CreateOwner(),
+			method:  "POST",
+		},
+		{
+			route:   "/walletapi/owner/{owner_id}",
+			handler: a.GetOwner(),
+			method:  "GET",
+		},
 		{
 			route:   "/walletapi/wallet/{wallet_id}",
 			handler: a.GetWallet(),
@@ -62,6 +120,41 @@ func getRouter(a *App) []Route {
 			handler: a.CreateWallet(),
 			method:  "POST",
 		},
+		{
+			route:   "/walletapi/wallet/{wallet_id}/freeze",
+			handler: a.FreezeWallet(),
+			method:  "POST",
+		},
+		{
+			route:   "/walletapi/wallet/{wallet_id}/unfreeze",
+			handler: a.UnfreezeWallet(),
+			method:  "POST",
+		},
+		{
+			route:   "/walletapi/wallet/{wallet_id}/close",
+			handler: a.CloseWallet(),
+			method:  "POST",
+		},
+		{
+			route:   "/walletapi/wallet/{wallet_id}/limits",
+			handler: a.GetWalletLimits(),
+			method:  "GET",
+		},
+		{
+			route:   "/walletapi/wallet/{wallet_id}/limits",
+			handler: a.SetWalletLimits(),
+			method:  "PUT",
+		},
+		{
+			route:   "/walletapi/limits/{currency}",
+			handler: a.SetDefaultLimits(),
+			method:  "PUT",
+		},
+		{
+			route:   "/walletapi/fees/{type}/{currency}",
+			handler: a.SetFeeSchedule(),
+			method:  "PUT",
+		},
 		{
 			route:   "/walletapi/wallet/{wallet_id}/transactions",
 			handler: a.GetWalletTransactions(),
@@ -77,40 +170,406 @@ func getRouter(a *App) []Route {
 			handler: a.RevertTransaction(),
 			method:  "DELETE",
 		},
+		{
+			route:   "/walletapi/transaction/{tran_id}/refund",
+			handler: a.RefundTransaction(),
+			method:  "POST",
+		},
+		{
+			route:   "/walletapi/transfer",
+			handler: a.CreateTransfer(),
+			method:  "POST",
+		},
+		{
+			route:   "/walletapi/hold",
+			handler: a.AuthorizeHold(),
+			method:  "POST",
+		},
+		{
+			route:   "/walletapi/hold/{hold_id}",
+			handler: a.GetHold(),
+			method:  "GET",
+		},
+		{
+			route:   "/walletapi/hold/{hold_id}/capture",
+			handler: a.CaptureHold(),
+			method:  "POST",
+		},
+		{
+			route:   "/walletapi/hold/{hold_id}",
+			handler: a.VoidHold(),
+			method:  "DELETE",
+		},
+		{
+			route:   "/walletapi/schedule",
+			handler: a.CreateSchedule(),
+			method:  "POST",
+		},
+		{
+			route:   "/walletapi/schedule/{schedule_id}",
+			handler: a.GetSchedule(),
+			method:  "GET",
+		},
+		{
+			route:   "/walletapi/schedule/{schedule_id}",
+			handler: a.CancelSchedule(),
+			method:  "DELETE",
+		},
+		{
+			route:   "/walletapi/admin/reconcile",
+			handler: a.ReconcileWallets(),
+			method:  "GET",
+			timeout: 5 * time.Minute,
+		},
+		{
+			route:   "/walletapi/admin/chain/verify",
+			handler: a.VerifyWalletChain(),
+			method:  "GET",
+			timeout: time.Minute,
+		},
+		{
+			route:   "/walletapi/admin/chain/heads",
+			handler: a.ExportChainHeads(),
+			method:  "GET",
+			timeout: time.Minute,
+		},
+		{
+			route:   "/walletapi/admin/audit",
+			handler: a.ListAuditRecords(),
+			method:  "GET",
+		},
+		{
+			route:   "/walletapi/admin/webhooks",
+			handler: a.CreateWebhookSubscription(),
+			method:  "POST",
+		},
+		{
+			route:   "/walletapi/admin/webhooks",
+			handler: a.ListWebhookSubscriptions(),
+			method:  "GET",
+		},
+		{
+			route:   "/walletapi/admin/webhooks/deliveries",
+			handler: a.ListWebhookDeliveries(),
+			method:  "GET",
+		},
+		{
+			route:   "/walletapi/admin/webhooks/{subscription_id}",
+			handler: a.DeleteWebhookSubscription(),
+			method:  "DELETE",
+		},
+		{
+			route:   "/walletapi/admin/events/{event_id}/replay",
+			handler: a.ReplayEvent(),
+			method:  "POST",
+		},
+	}
+}
+
+func (a *App) purgeExpiredIdempotencyKeys(interval time.Duration) {
+	for range time.Tick(interval) {
+		if err := handler.PurgeExpiredIdempotencyKeys(a.Store); err != nil {
+			a.Logger.Error("failed to purge idempotency keys", "error", err)
+		}
+	}
+}
+
+func (a *App) expireHolds(interval time.Duration) {
+	for range time.Tick(interval) {
+		if err := handler.ExpireHolds(a.Store); err != nil {
+			a.Logger.Error("failed to expire holds", "error", err)
+		}
+	}
+}
+
+func (a *App) runSchedules(interval time.Duration) {
+	for range time.Tick(interval) {
+		if err := handler.RunDueSchedules(a.Store); err != nil {
+			a.Logger.Error("failed to run schedules", "error", err)
+		}
+	}
+}
+
+// deliverWebhooks moves events from the outbox to the subscriptions wanting
+// them and attempts the deliveries due.
+func (a *App) deliverWebhooks(interval time.Duration) {
+	for range time.Tick(interval) {
+		if err := handler.DispatchEvents(a.Store); err != nil {
+			a.Logger.Error("failed to dispatch events", "error", err)
+		}
+		if err := handler.DeliverWebhooks(a.Store); err != nil {
+			a.Logger.Error("failed to deliver webhooks", "error", err)
+		}
+	}
+}
+
+// Reconcile runs a reconciliation, as the reconcile subcommand, and prints
+// the report as JSON. It reports whether no discrepancies were found.
+func (a *App) Reconcile(repair bool) bool {
+	report, err := handler.Reconcile(a.Store, repair)
+	if err != nil {
+		a.fatal("reconciliation failed", err)
+	}
+	output, _ := json.MarshalIndent(report, "", "  ")
+	fmt.Println(string(output))
+	return len(report.Discrepancies) == 0
+}
+
+// RebuildBalances resets every wallet's balance to its ledger balance, as the
+// rebuild-balances subcommand, and prints the wallets reset as JSON.
+func (a *App) RebuildBalances() {
+	rebuilds, err := handler.RebuildWalletBalances(a.Store)
+	if err != nil {
+		a.fatal("failed to rebuild wallet balances", err)
 	}
+	output, _ := json.MarshalIndent(rebuilds, "", "  ")
+	fmt.Println(string(output))
+}
+
+// VerifyChains verifies the hash chains of the given wallets, or of every
+// wallet when none is given, as the verify-chain subcommand, and prints the
+// reports as JSON. It reports whether every chain holds.
+func (a *App) VerifyChains(walletIds []uint) bool {
+	if len(walletIds) == 0 {
+		wallets, err := a.Store.ListWallets()
+		if err != nil {
+			a.fatal("failed to list wallets", err)
+		}
+		for _, wallet := range wallets {
+			walletIds = append(walletIds, wallet.ID)
+		}
+	}
+	intact := true
+	reports := []handler.ChainReport{}
+	for _, walletId := range walletIds {
+		report, err := handler.VerifyChain(a.Store, walletId)
+		if err != nil {
+			a.fatal("chain verification failed", err, logging.WalletID, walletId)
+		}
+		intact = intact && report.Break == nil
+		reports = append(reports, report)
+	}
+	output, _ := json.MarshalIndent(reports, "", "  ")
+	fmt.Println(string(output))
+	return intact
+}
+
+// PrintChainHeads prints the chain heads as JSON, as the chain-heads
+// subcommand, for anchoring outside the system.
+func (a *App) PrintChainHeads() {
+	heads, err := handler.GetChainHeads(a.Store)
+	if err != nil {
+		a.fatal("failed to export chain heads", err)
+	}
+	output, _ := json.MarshalIndent(heads, "", "  ")
+	fmt.Println(string(output))
 }
 
 func (a *App) Run(host string) {
-	log.Fatal(http.ListenAndServe(host, a.Router))
+	a.fatal("server stopped", http.ListenAndServe(host, a.Router))
+}
+
+// fatal logs err, with args as further fields, and exits.
+func (a *App) fatal(msg string, err error, args ...interface{}) {
+	a.Logger.Error(msg, append([]interface{}{"error", err}, args...)...)
+	os.Exit(1)
+}
+
+// storeFor is the Store for serving r, bound to its context so that DB
+// transactions end with the request.
+func (a *App) storeFor(r *http.Request) store.Store {
+	return a.Store.WithContext(r.Context())
 }
 
 //toDo move this all wrapper to Handler itself
 
+func (a *App) CreateOwner() func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		handler.CreateOwner(a.storeFor(r), w, r)
+	}
+}
+
+func (a *App) GetOwner() func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		handler.GetOwner(a.storeFor(r), w, r)
+	}
+}
+
 func (a *App) GetWallet() func(w http.ResponseWriter, r *http.Request) {
 	return func(w http.ResponseWriter, r *http.Request) {
-		handler.GetWallet(a.DB, w, r)
+		handler.GetWallet(a.storeFor(r), w, r)
 	}
 }
 func (a *App) CreateWallet() func(w http.ResponseWriter, r *http.Request) {
 	return func(w http.ResponseWriter, r *http.Request) {
-		handler.CreateWallet(a.DB, w)
+		handler.CreateWallet(a.storeFor(r), w, r)
+	}
+}
+
+func (a *App) FreezeWallet() func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		handler.FreezeWallet(a.storeFor(r), w, r)
+	}
+}
+
+func (a *App) UnfreezeWallet() func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		handler.UnfreezeWallet(a.storeFor(r), w, r)
+	}
+}
+
+func (a *App) CloseWallet() func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		handler.CloseWallet(a.storeFor(r), w, r)
+	}
+}
+
+func (a *App) GetWalletLimits() func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		handler.GetWalletLimits(a.storeFor(r), w, r)
+	}
+}
+
+func (a *App) SetWalletLimits() func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		handler.SetWalletLimits(a.storeFor(r), w, r)
+	}
+}
+
+func (a *App) SetDefaultLimits() func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		handler.SetDefaultLimits(a.storeFor(r), w, r)
+	}
+}
+
+func (a *App) CreateSchedule() func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		handler.CreateSchedule(a.storeFor(r), w, r)
+	}
+}
+
+func (a *App) GetSchedule() func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		handler.GetSchedule(a.storeFor(r), w, r)
+	}
+}
+
+func (a *App) CancelSchedule() func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		handler.CancelSchedule(a.storeFor(r), w, r)
+	}
+}
+
+func (a *App) CreateWebhookSubscription() func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		handler.CreateWebhookSubscription(a.storeFor(r), w, r)
+	}
+}
+
+func (a *App) ListWebhookSubscriptions() func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		handler.ListWebhookSubscriptions(a.storeFor(r), w, r)
+	}
+}
+
+func (a *App) DeleteWebhookSubscription() func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		handler.DeleteWebhookSubscription(a.storeFor(r), w, r)
+	}
+}
+
+func (a *App) ListWebhookDeliveries() func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		handler.ListWebhookDeliveries(a.storeFor(r), w, r)
+	}
+}
+
+func (a *App) ReplayEvent() func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		handler.ReplayEvent(a.storeFor(r), w, r)
+	}
+}
+
+func (a *App) ListAuditRecords() func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		handler.ListAuditRecords(a.storeFor(r), w, r)
+	}
+}
+
+func (a *App) VerifyWalletChain() func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		handler.VerifyWalletChain(a.storeFor(r), w, r)
+	}
+}
+
+func (a *App) ExportChainHeads() func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		handler.ExportChainHeads(a.storeFor(r), w, r)
+	}
+}
+
+func (a *App) SetFeeSchedule() func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		handler.SetFeeSchedule(a.storeFor(r), w, r)
 	}
 }
 
 func (a *App) GetWalletTransactions() func(w http.ResponseWriter, r *http.Request) {
 	return func(w http.ResponseWriter, r *http.Request) {
-		handler.GetWalletTransactions(a.DB, w, r)
+		handler.GetWalletTransactions(a.storeFor(r), w, r)
 	}
 }
 
 func (a *App) CreateTransaction() func(w http.ResponseWriter, r *http.Request) {
 	return func(w http.ResponseWriter, r *http.Request) {
-		handler.CreateTransaction(a.DB, w, r)
+		handler.CreateTransaction(a.storeFor(r), w, r)
 	}
 }
 
 func (a *App) RevertTransaction() func(w http.ResponseWriter, r *http.Request) {
 	return func(w http.ResponseWriter, r *http.Request) {
-		handler.RevertTransaction(a.DB, w, r)
+		handler.RevertTransaction(a.storeFor(r), w, r)
+	}
+}
+
+func (a *App) RefundTransaction() func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		handler.RefundTransaction(a.storeFor(r), w, r)
+	}
+}
+
+func (a *App) CreateTransfer() func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		handler.CreateTransfer(a.storeFor(r), w, r)
+	}
+}
+
+func (a *App) AuthorizeHold() func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		handler.AuthorizeHold(a.storeFor(r), w, r)
+	}
+}
+
+func (a *App) GetHold() func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		handler.GetHold(a.storeFor(r), w, r)
+	}
+}
+
+func (a *App) CaptureHold() func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		handler.CaptureHold(a.storeFor(r), w, r)
+	}
+}
+
+func (a *App) VoidHold() func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		handler.VoidHold(a.storeFor(r), w, r)
+	}
+}
+
+func (a *App) ReconcileWallets() func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		handler.ReconcileWallets(a.storeFor(r), w, r)
 	}
 }
@@ -0,0 +1,145 @@
+// Package apperror holds the domain errors the API answers with. Each one
+// has a stable, machine-readable code that clients can branch on and the
+// HTTP status it is answered with.
+package apperror
+
+import (
+	"errors"
+	"net/http"
+)
+
+type Error struct {
+	Status  int
+	Code    string
+	Message string
+	Details map[string]interface{}
+	cause   error
+}
+
+func New(status int, code, message string) *Error {
+	return &Error{Status: status, Code: code, Message: message}
+}
+
+func (e *Error) Error() string {
+	if e.cause != nil {
+		return e.Message + ": " + e.cause.Error()
+	}
+	return e.Message
+}
+
+func (e *Error) Unwrap() error {
+	return e.cause
+}
+
+// Is reports errors with the same code as equal, whatever their message and
+// details, so errors.Is(err, ErrInsufficientFunds) holds for any variant.
+func (e *Error) Is(target error) bool {
+	t, ok := target.(*Error)
+	return ok && t.Code == e.Code
+}
+
+// WithMessage is a copy of e with a more specific message.
+func (e *Error) WithMessage(message string) *Error {
+	c := *e
+	c.Message = message
+	return &c
+}
+
+// WithDetails is a copy of e carrying details clients can act on.
+func (e *Error) WithDetails(details map[string]interface{}) *Error {
+	c := *e
+	c.Details = details
+	return &c
+}
+
+// From returns the domain error err is or wraps, or nil if there is none.
+func From(err error) *Error {
+	var e *Error
+	if errors.As(err, &e) {
+		return e
+	}
+	return nil
+}
+
+// BadRequest is an INVALID_REQUEST error with message.
+func BadRequest(message string) *Error {
+	return ErrInvalidRequest.WithMessage(message)
+}
+
+// Invalid returns err as it is if it carries a domain error, and otherwise
+// an INVALID_REQUEST error with err's message, or REQUEST_TOO_LARGE when the
+// body being read went over its limit.
+func Invalid(err error) error {
+	if From(err) != nil {
+		return err
+	}
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		return ErrRequestTooLarge
+	}
+	return BadRequest(err.Error())
+}
+
+// Internal returns err as it is if it carries a domain error, and otherwise
+// an INTERNAL error saying what failed. The cause is only kept for logging
+// and never sent to clients.
+func Internal(message string, err error) error {
+	if From(err) != nil {
+		return err
+	}
+	return &Error{Status: http.StatusInternalServerError, Code: ErrInternal.Code, Message: message, cause: err}
+}
+
+var (
+	ErrInvalidRequest  = New(http.StatusBadRequest, "INVALID_REQUEST", "invalid request")
+	ErrValidation      = New(http.StatusBadRequest, "VALIDATION_FAILED", "request failed validation")
+	ErrUnauthenticated = New(http.StatusUnauthorized, "UNAUTHENTICATED", "authentication required")
+	ErrForbidden       = New(http.StatusForbidden, "FORBIDDEN", "access denied")
+	ErrInternal        = New(http.StatusInternalServerError, "INTERNAL", "internal error")
+	ErrTimeout         = New(http.StatusServiceUnavailable, "TIMEOUT", "request timed out")
+	ErrRequestTooLarge = New(http.StatusRequestEntityTooLarge, "REQUEST_TOO_LARGE", "request body too large")
+
+	ErrOwnerNotFound        = New(http.StatusNotFound, "OWNER_NOT_FOUND", "owner not found")
+	ErrWalletNotFound       = New(http.StatusNotFound, "WALLET_NOT_FOUND", "wallet not found")
+	ErrTransactionNotFound  = New(http.StatusNotFound, "TRANSACTION_NOT_FOUND", "transaction not found")
+	ErrHoldNotFound         = New(http.StatusNotFound, "HOLD_NOT_FOUND", "hold not found")
+	ErrScheduleNotFound     = New(http.StatusNotFound, "SCHEDULE_NOT_FOUND", "schedule not found")
+	ErrEventNotFound        = New(http.StatusNotFound, "EVENT_NOT_FOUND", "event not found")
+	ErrSubscriptionNotFound = New(http.StatusNotFound, "SUBSCRIPTION_NOT_FOUND", "webhook subscription not found")
+
+	ErrOwnerExists          = New(http.StatusConflict, "OWNER_EXISTS", "an owner with this email already exists")
+	ErrIdempotencyKeyReused = New(http.StatusConflict, "IDEMPOTENCY_KEY_REUSED", "idempotency key was already used with a different request")
+
+	ErrInvalidType       = New(http.StatusBadRequest, "INVALID_TYPE", "invalid transaction type")
+	ErrInvalidAmount     = New(http.StatusBadRequest, "INVALID_AMOUNT", "amount must be positive")
+	ErrInsufficientFunds = New(http.StatusUnprocessableEntity, "INSUFFICIENT_FUNDS", "insufficient available balance")
+	ErrLimitExceeded     = New(http.StatusUnprocessableEntity, "LIMIT_EXCEEDED", "limit exceeded")
+
+	ErrCurrencyMismatch = New(http.StatusBadRequest, "CURRENCY_MISMATCH", "currency does not match the wallet currency")
+	ErrUnknownCurrency  = New(http.StatusBadRequest, "UNKNOWN_CURRENCY", "unknown currency")
+	ErrAmountTooSmall   = New(http.StatusBadRequest, "AMOUNT_TOO_SMALL", "amount rounds to zero in the wallet currency")
+	ErrAmountOutOfRange = New(http.StatusBadRequest, "AMOUNT_OUT_OF_RANGE", "amount out of range")
+	ErrFxRateRequired   = New(http.StatusBadRequest, "FX_RATE_REQUIRED", "fx_rate is required between wallets of different currencies")
+	ErrUnexpectedFxRate = New(http.StatusBadRequest, "UNEXPECTED_FX_RATE", "fx_rate is only allowed between wallets of different currencies")
+
+	ErrWalletFrozen            = New(http.StatusConflict, "WALLET_FROZEN", "wallet is frozen")
+	ErrWalletClosed            = New(http.StatusConflict, "WALLET_CLOSED", "wallet is closed")
+	ErrInvalidStatusTransition = New(http.StatusConflict, "INVALID_STATUS_TRANSITION", "wallet status does not allow this change")
+	ErrActiveHolds             = New(http.StatusConflict, "ACTIVE_HOLDS", "wallet has active holds")
+	ErrBalanceNotZero          = New(http.StatusConflict, "BALANCE_NOT_ZERO", "wallet balance must be zero, or moved to a nominated wallet")
+
+	ErrAlreadyReversed       = New(http.StatusConflict, "ALREADY_REVERSED", "transaction is already reversed")
+	ErrReversalOfReversal    = New(http.StatusBadRequest, "REVERSAL_OF_REVERSAL", "a reversal cannot be reversed")
+	ErrRefundExceedsAmount   = New(http.StatusBadRequest, "REFUND_EXCEEDS_AMOUNT", "refund amount exceeds the refundable amount")
+	ErrPartialTransferRefund = New(http.StatusBadRequest, "PARTIAL_TRANSFER_REFUND", "transfers can only be reverted in full")
+	ErrFeeReversal           = New(http.StatusBadRequest, "FEE_REVERSAL", "fees are reversed with the transaction they were charged on")
+	ErrPartialFeeReversal    = New(http.StatusBadRequest, "PARTIAL_FEE_REVERSAL", "fees can only be reversed with a full reversal")
+	ErrInvalidFeeType        = New(http.StatusBadRequest, "INVALID_FEE_TYPE", "fees apply to DEBIT and TRANSFER transactions only")
+	ErrRevenueWalletRequired = New(http.StatusBadRequest, "REVENUE_WALLET_REQUIRED", "revenue_wallet_id must be a wallet in the schedule currency")
+
+	ErrHoldNotActive      = New(http.StatusConflict, "HOLD_NOT_ACTIVE", "hold is not active")
+	ErrHoldExpired        = New(http.StatusConflict, "HOLD_EXPIRED", "hold has expired")
+	ErrCaptureExceedsHold = New(http.StatusBadRequest, "CAPTURE_EXCEEDS_HOLD", "capture amount exceeds the held amount")
+
+	ErrScheduleNotActive = New(http.StatusConflict, "SCHEDULE_NOT_ACTIVE", "schedule is not active")
+)
@@ -0,0 +1,33 @@
+package apperror
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestErrorsMatchByCode(t *testing.T) {
+	err := fmt.Errorf("posting debit: %w", ErrLimitExceeded.WithMessage("over the daily limit").WithDetails(map[string]interface{}{"limit": "daily_debit"}))
+
+	assert.True(t, errors.Is(err, ErrLimitExceeded))
+	assert.False(t, errors.Is(err, ErrInsufficientFunds))
+	assert.Equal(t, "over the daily limit", From(err).Message)
+	assert.Equal(t, "limit exceeded", ErrLimitExceeded.Message)
+}
+
+func TestInternalKeepsDomainErrors(t *testing.T) {
+	assert.Equal(t, ErrWalletFrozen, Internal("failed to process transaction", ErrWalletFrozen))
+
+	err := Internal("failed to process transaction", errors.New("connection refused"))
+
+	assert.Equal(t, ErrInternal.Code, From(err).Code)
+	assert.Equal(t, "failed to process transaction", From(err).Message)
+	assert.Equal(t, "failed to process transaction: connection refused", err.Error())
+}
+
+func TestInvalid(t *testing.T) {
+	assert.Equal(t, ErrCurrencyMismatch, Invalid(ErrCurrencyMismatch))
+	assert.Equal(t, BadRequest("reason is required"), Invalid(errors.New("reason is required")))
+}
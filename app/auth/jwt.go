@@ -0,0 +1,146 @@
+// Package auth verifies the HS256 JSON Web Tokens that callers present as
+// bearer tokens.
+package auth
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// AdminScope lets a caller act on every wallet.
+const AdminScope = "admin"
+
+var (
+	ErrInvalidToken = errors.New("invalid token")
+	ErrExpiredToken = errors.New("token has expired")
+)
+
+// Claims are the token claims the API uses. Subject is the ID of the calling
+// owner and Scope a space separated list of scopes. Tokens must expire.
+type Claims struct {
+	Subject   string `json:"sub"`
+	Scope     string `json:"scope,omitempty"`
+	ExpiresAt int64  `json:"exp"`
+	NotBefore int64  `json:"nbf,omitempty"`
+	IssuedAt  int64  `json:"iat,omitempty"`
+}
+
+func (c Claims) HasScope(scope string) bool {
+	for _, s := range strings.Fields(c.Scope) {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// OwnerId is the owner the token was issued to.
+func (c Claims) OwnerId() (uint, bool) {
+	id, err := strconv.ParseUint(c.Subject, 10, 64)
+	if err != nil || id == 0 {
+		return 0, false
+	}
+	return uint(id), true
+}
+
+type header struct {
+	Alg string `json:"alg"`
+	Typ string `json:"typ,omitempty"`
+	Kid string `json:"kid,omitempty"`
+}
+
+// Verifier checks tokens against HMAC keys by key ID. A token without a kid
+// header uses the key with the empty ID.
+type Verifier struct {
+	keys map[string][]byte
+	now  func() time.Time
+}
+
+func NewVerifier(keys map[string]string) *Verifier {
+	v := &Verifier{keys: make(map[string][]byte, len(keys)), now: time.Now}
+	for kid, key := range keys {
+		v.keys[kid] = []byte(key)
+	}
+	return v
+}
+
+// Verify checks the token's signature and validity period and returns its
+// claims. Only HS256 is accepted.
+func (v *Verifier) Verify(token string) (Claims, error) {
+	claims := Claims{}
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return claims, ErrInvalidToken
+	}
+	h := header{}
+	if err := decodeSegment(parts[0], &h); err != nil || h.Alg != "HS256" {
+		return claims, ErrInvalidToken
+	}
+	key, ok := v.keys[h.Kid]
+	if !ok {
+		return claims, ErrInvalidToken
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil || !hmac.Equal(signature, sign(parts[0]+"."+parts[1], key)) {
+		return claims, ErrInvalidToken
+	}
+	if err := decodeSegment(parts[1], &claims); err != nil || claims.Subject == "" || claims.ExpiresAt == 0 {
+		return Claims{}, ErrInvalidToken
+	}
+	now := v.now().Unix()
+	if now >= claims.ExpiresAt {
+		return Claims{}, ErrExpiredToken
+	}
+	if claims.NotBefore != 0 && now < claims.NotBefore {
+		return Claims{}, ErrInvalidToken
+	}
+	return claims, nil
+}
+
+// Sign issues an HS256 token for claims, signed with key under key ID kid.
+func Sign(claims Claims, kid string, key []byte) (string, error) {
+	h, err := json.Marshal(header{Alg: "HS256", Typ: "JWT", Kid: kid})
+	if err != nil {
+		return "", err
+	}
+	c, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+	unsigned := base64.RawURLEncoding.EncodeToString(h) + "." + base64.RawURLEncoding.EncodeToString(c)
+	return unsigned + "." + base64.RawURLEncoding.EncodeToString(sign(unsigned, key)), nil
+}
+
+func sign(unsigned string, key []byte) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(unsigned))
+	return mac.Sum(nil)
+}
+
+func decodeSegment(segment string, v interface{}) error {
+	data, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+type contextKey struct{}
+
+// NewContext returns a copy of ctx carrying the caller's claims.
+func NewContext(ctx context.Context, claims Claims) context.Context {
+	return context.WithValue(ctx, contextKey{}, claims)
+}
+
+// FromContext returns the claims of an authenticated caller.
+func FromContext(ctx context.Context) (Claims, bool) {
+	claims, ok := ctx.Value(contextKey{}).(Claims)
+	return claims, ok
+}
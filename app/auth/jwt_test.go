@@ -0,0 +1,54 @@
+package auth
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestVerifyAcceptsSignedToken(t *testing.T) {
+	verifier := NewVerifier(map[string]string{"2024": "secret"})
+	token, err := Sign(Claims{Subject: "7", Scope: "read admin", ExpiresAt: time.Now().Add(time.Hour).Unix()}, "2024", []byte("secret"))
+	assert.NoError(t, err)
+
+	claims, err := verifier.Verify(token)
+
+	assert.NoError(t, err)
+	ownerId, ok := claims.OwnerId()
+	assert.True(t, ok)
+	assert.Equal(t, uint(7), ownerId)
+	assert.True(t, claims.HasScope(AdminScope))
+}
+
+func TestVerifyRejectsInvalidTokens(t *testing.T) {
+	verifier := NewVerifier(map[string]string{"": "secret"})
+	expires := time.Now().Add(time.Hour).Unix()
+	valid, _ := Sign(Claims{Subject: "7", ExpiresAt: expires}, "", []byte("secret"))
+	wrongKey, _ := Sign(Claims{Subject: "7", ExpiresAt: expires}, "", []byte("other"))
+	unknownKid, _ := Sign(Claims{Subject: "7", ExpiresAt: expires}, "old", []byte("secret"))
+	noExpiry, _ := Sign(Claims{Subject: "7"}, "", []byte("secret"))
+	parts := strings.Split(valid, ".")
+	unsigned := "eyJhbGciOiJub25lIn0." + parts[1] + "."
+
+	for name, token := range map[string]string{
+		"malformed":   "not-a-token",
+		"wrong key":   wrongKey,
+		"unknown kid": unknownKid,
+		"no expiry":   noExpiry,
+		"alg none":    unsigned,
+	} {
+		_, err := verifier.Verify(token)
+		assert.Equal(t, ErrInvalidToken, err, name)
+	}
+}
+
+func TestVerifyRejectsExpiredToken(t *testing.T) {
+	verifier := NewVerifier(map[string]string{"": "secret"})
+	token, _ := Sign(Claims{Subject: "7", ExpiresAt: time.Now().Add(-time.Minute).Unix()}, "", []byte("secret"))
+
+	_, err := verifier.Verify(token)
+
+	assert.Equal(t, ErrExpiredToken, err)
+}
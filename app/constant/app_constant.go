@@ -4,3 +4,109 @@ const (
 	CREDIT = "CREDIT"
 	DEBIT  = "DEBIT"
 )
+
+// TRANSFER keys the fee schedule of transfers, whose legs are a DEBIT and a
+// CREDIT.
+const TRANSFER = "TRANSFER"
+
+const (
+	NOT_REVERSED       = "NONE"
+	PARTIALLY_REVERSED = "PARTIALLY_REVERSED"
+	REVERSED           = "REVERSED"
+)
+
+const (
+	SCHEDULE_ACTIVE    = "ACTIVE"
+	SCHEDULE_COMPLETED = "COMPLETED"
+	SCHEDULE_CANCELLED = "CANCELLED"
+	RUN_SUCCEEDED      = "SUCCEEDED"
+	RUN_FAILED         = "FAILED"
+)
+
+const (
+	EVENT_WALLET_CREATED       = "wallet.created"
+	EVENT_WALLET_FROZEN        = "wallet.frozen"
+	EVENT_WALLET_UNFROZEN      = "wallet.unfrozen"
+	EVENT_WALLET_CLOSED        = "wallet.closed"
+	EVENT_TRANSACTION_CREATED  = "transaction.created"
+	EVENT_TRANSACTION_REVERTED = "transaction.reverted"
+)
+
+const (
+	DELIVERY_PENDING   = "PENDING"
+	DELIVERY_DELIVERED = "DELIVERED"
+	DELIVERY_DEAD      = "DEAD"
+)
+
+const (
+	AUDIT_OWNER_CREATED        = "owner.created"
+	AUDIT_WALLET_CREATED       = "wallet.created"
+	AUDIT_WALLET_FROZEN        = "wallet.frozen"
+	AUDIT_WALLET_UNFROZEN      = "wallet.unfrozen"
+	AUDIT_WALLET_CLOSED        = "wallet.closed"
+	AUDIT_BALANCE_REBUILT      = "wallet.balance_rebuilt"
+	AUDIT_WALLET_LIMITS_SET    = "wallet.limits_set"
+	AUDIT_DEFAULT_LIMITS_SET   = "limits.default_set"
+	AUDIT_FEE_SCHEDULE_SET     = "fee_schedule.set"
+	AUDIT_TRANSACTION_CREATED  = "transaction.created"
+	AUDIT_TRANSACTION_REVERTED = "transaction.reverted"
+	AUDIT_TRANSACTION_REFUNDED = "transaction.refunded"
+	AUDIT_TRANSACTION_ADJUSTED = "transaction.adjusted"
+	AUDIT_TRANSFER_CREATED     = "transfer.created"
+	AUDIT_HOLD_AUTHORIZED      = "hold.authorized"
+	AUDIT_HOLD_CAPTURED        = "hold.captured"
+	AUDIT_HOLD_VOIDED          = "hold.voided"
+	AUDIT_SCHEDULE_CREATED     = "schedule.created"
+	AUDIT_SCHEDULE_CANCELLED   = "schedule.cancelled"
+	AUDIT_WEBHOOK_CREATED      = "webhook.created"
+	AUDIT_WEBHOOK_DELETED      = "webhook.deleted"
+	AUDIT_EVENT_REPLAYED       = "event.replayed"
+)
+
+const (
+	HOLD_ACTIVE   = "ACTIVE"
+	HOLD_CAPTURED = "CAPTURED"
+	HOLD_VOIDED   = "VOIDED"
+	HOLD_EXPIRED  = "EXPIRED"
+)
+
+// Kinds of discrepancy found by reconciliation.
+const (
+	BALANCE_MISMATCH      = "BALANCE_MISMATCH"
+	CLOSING_BALANCE_BREAK = "CLOSING_BALANCE_BREAK"
+)
+
+// Kinds of break found by hash chain verification.
+const (
+	CHAIN_LINK_BROKEN   = "LINK_BROKEN"
+	CHAIN_HASH_MISMATCH = "HASH_MISMATCH"
+	CHAIN_HEAD_MISMATCH = "HEAD_MISMATCH"
+)
+
+const (
+	WALLET_ACTIVE = "ACTIVE"
+	WALLET_FROZEN = "FROZEN"
+	WALLET_CLOSED = "CLOSED"
+)
+
+// Transaction limits, as named in limit errors.
+const (
+	LIMIT_MAX_TRANSACTION_AMOUNT = "MAX_TRANSACTION_AMOUNT"
+	LIMIT_MAX_BALANCE            = "MAX_BALANCE"
+	LIMIT_DAILY_DEBIT            = "DAILY_DEBIT"
+	LIMIT_DAILY_CREDIT           = "DAILY_CREDIT"
+	LIMIT_MONTHLY_DEBIT          = "MONTHLY_DEBIT"
+	LIMIT_MONTHLY_CREDIT         = "MONTHLY_CREDIT"
+)
+
+// DEFAULT_CURRENCY is used for wallets created without a currency. It must
+// match the column default of the currency columns in the model.
+const DEFAULT_CURRENCY = "USD"
+
+// Ledger system accounts, one of each per currency. EXTERNAL_FUNDING is the
+// other side of money entering or leaving wallets; FX_CONVERSION balances each
+// currency of a transfer between currencies.
+const (
+	EXTERNAL_FUNDING_ACCOUNT = "EXTERNAL_FUNDING"
+	FX_CONVERSION_ACCOUNT    = "FX_CONVERSION"
+)
@@ -0,0 +1,141 @@
+package handler
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"wallet/app/apperror"
+	"wallet/app/auth"
+	"wallet/app/model"
+	"wallet/app/store"
+)
+
+const requestIdHeader = "X-Request-ID"
+
+// recordAudit appends the audit record of a change made by the request, with
+// the JSON of the resource before the change (nil when it creates one) and
+// of its result. It must be called with the Store of the DB transaction
+// making the change, so the change is committed if and only if its record
+// is.
+func recordAudit(tx store.Store, r *http.Request, action string, walletId *uint, before, after interface{}) error {
+	record := model.AuditRecord{
+		Action:    action,
+		WalletId:  walletId,
+		RequestId: truncate(r.Header.Get(requestIdHeader), 64),
+		SourceIP:  sourceIP(r),
+	}
+	if claims, ok := auth.FromContext(r.Context()); ok {
+		record.Actor = truncate(claims.Subject, 255)
+	}
+	return appendAudit(tx, record, before, after)
+}
+
+// appendAudit completes record with the JSON of the resource before and
+// after the change and appends it.
+func appendAudit(tx store.Store, record model.AuditRecord, before, after interface{}) error {
+	var err error
+	if record.Before, err = json.Marshal(before); err != nil {
+		return err
+	}
+	if record.After, err = json.Marshal(after); err != nil {
+		return err
+	}
+	return tx.CreateAuditRecord(&record)
+}
+
+// auditTransaction records the transaction posted by the request.
+func auditTransaction(r *http.Request, action string) transactionHook {
+	return func(tx store.Store, transaction *model.Transaction) error {
+		return recordAudit(tx, r, action, &transaction.WalletId, nil, transaction)
+	}
+}
+
+// auditCommand records the transaction posted by a subcommand run from the
+// command line, which is the actor of the record.
+func auditCommand(command, action string) transactionHook {
+	return func(tx store.Store, transaction *model.Transaction) error {
+		record := model.AuditRecord{Actor: "command:" + command, Action: action, WalletId: &transaction.WalletId}
+		return appendAudit(tx, record, nil, transaction)
+	}
+}
+
+// sourceIP is the address the request came from. Forwarding headers are
+// ignored as callers can set them to anything.
+func sourceIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+type auditPage struct {
+	Records      []model.AuditRecord `json:"records"`
+	NextBeforeId uint                `json:"next_before_id,omitempty"`
+}
+
+// ListAuditRecords pages through the audit log newest first, filtered by
+// actor, action, wallet_id and the from/to time range.
+func ListAuditRecords(s store.Store, w http.ResponseWriter, r *http.Request) {
+	if !requireAdmin(w, r) {
+		return
+	}
+	filter, err := parseAuditQuery(r.URL.Query())
+	if err != nil {
+		respondError(w, r, apperror.Invalid(err))
+		return
+	}
+	limit := filter.Limit
+	filter.Limit++
+	records, err := s.ListAuditRecords(filter)
+	if err != nil {
+		respondError(w, r, apperror.Internal("failed while fetching audit records", err))
+		return
+	}
+	page := auditPage{Records: records}
+	if page.Records == nil {
+		page.Records = []model.AuditRecord{}
+	}
+	if len(records) > limit {
+		page.Records = records[:limit]
+		page.NextBeforeId = page.Records[limit-1].ID
+	}
+	respondSuccess(w, page)
+}
+
+func parseAuditQuery(values url.Values) (store.AuditFilter, error) {
+	filter := store.AuditFilter{Limit: defaultPageSize, Actor: values.Get("actor"), Action: values.Get("action")}
+	if limit := values.Get("limit"); limit != "" {
+		parsed, err := strconv.Atoi(limit)
+		if err != nil || parsed < 1 || parsed > maxPageSize {
+			return filter, fmt.Errorf("limit must be between 1 and %d", maxPageSize)
+		}
+		filter.Limit = parsed
+	}
+	if beforeId := values.Get("before_id"); beforeId != "" {
+		parsed, err := strconv.ParseUint(beforeId, 10, 64)
+		if err != nil {
+			return filter, fmt.Errorf("invalid before_id")
+		}
+		filter.BeforeId = uint(parsed)
+	}
+	if walletId := values.Get("wallet_id"); walletId != "" {
+		parsed, err := strconv.ParseUint(walletId, 10, 64)
+		if err != nil {
+			return filter, fmt.Errorf("invalid wallet_id")
+		}
+		id := uint(parsed)
+		filter.WalletId = &id
+	}
+	var err error
+	if filter.From, err = parseTimeParam(values, "from"); err != nil {
+		return filter, err
+	}
+	if filter.To, err = parseTimeParam(values, "to"); err != nil {
+		return filter, err
+	}
+	return filter, nil
+}
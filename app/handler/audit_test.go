@@ -0,0 +1,124 @@
+package handler
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+	"wallet/app/constant"
+	"wallet/app/model"
+	"wallet/app/store"
+	"wallet/testutils"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func listAuditRecords(t *testing.T, s store.Store, filter store.AuditFilter) []model.AuditRecord {
+	records, err := s.ListAuditRecords(filter)
+	assert.NoError(t, err)
+	return records
+}
+
+func TestTransactionsAreAudited(t *testing.T) {
+	memoryStore := store.NewMemoryStore()
+	testService := testutils.NewTestServer().
+		RegisterHandler("/transaction", memoryStore, CreateTransaction).
+		RegisterHandler("/transaction/{tran_id}", memoryStore, RevertTransaction)
+	defer testService.Server.Close()
+	wallet := newWallet(t, memoryStore, "USD", 0, 0)
+	req, _ := http.NewRequest(http.MethodPost, testService.Server.URL+"/transaction", strings.NewReader(fmt.Sprintf(`{"wallet_id":%d,"type":"CREDIT","amount":"10"}`, wallet.ID)))
+	req.Header.Set(requestIdHeader, "req-1")
+
+	resp, err := http.DefaultClient.Do(req)
+
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	credit := model.Transaction{}
+	decodeBody(t, resp, &credit)
+	records := listAuditRecords(t, memoryStore, store.AuditFilter{})
+	assert.Len(t, records, 1)
+	assert.Equal(t, "admin", records[0].Actor)
+	assert.Equal(t, constant.AUDIT_TRANSACTION_CREATED, records[0].Action)
+	assert.Equal(t, wallet.ID, *records[0].WalletId)
+	assert.Equal(t, "req-1", records[0].RequestId)
+	assert.Equal(t, "127.0.0.1", records[0].SourceIP)
+	assert.Equal(t, "null", string(records[0].Before))
+	assert.Contains(t, string(records[0].After), fmt.Sprintf(`"ID":%d`, credit.ID))
+
+	req, _ = http.NewRequest(http.MethodDelete, fmt.Sprintf("%s/transaction/%d", testService.Server.URL, credit.ID), nil)
+	resp, err = http.DefaultClient.Do(req)
+
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	records = listAuditRecords(t, memoryStore, store.AuditFilter{Action: constant.AUDIT_TRANSACTION_REVERTED})
+	assert.Len(t, records, 1)
+	before := model.Transaction{}
+	assert.NoError(t, json.Unmarshal(records[0].Before, &before))
+	assert.Equal(t, credit.ID, before.ID)
+	assert.Equal(t, constant.NOT_REVERSED, before.ReversalState)
+}
+
+func TestFailedChangesAreNotAudited(t *testing.T) {
+	memoryStore := store.NewMemoryStore()
+	testService := testutils.NewTestServer().RegisterHandler("/transaction", memoryStore, CreateTransaction)
+	defer testService.Server.Close()
+	wallet := newWallet(t, memoryStore, "USD", 0, 0)
+
+	resp, err := http.Post(testService.Server.URL+"/transaction", "application/json", strings.NewReader(fmt.Sprintf(`{"wallet_id":%d,"type":"DEBIT","amount":"10"}`, wallet.ID)))
+
+	assert.NoError(t, err)
+	assert.NotEqual(t, http.StatusOK, resp.StatusCode)
+	assert.Empty(t, listAuditRecords(t, memoryStore, store.AuditFilter{}))
+}
+
+func TestListAuditRecordsFiltersAndPages(t *testing.T) {
+	memoryStore := store.NewMemoryStore()
+	testService := testutils.NewTestServer().
+		RegisterHandler("/wallet", memoryStore, CreateWallet).
+		RegisterHandler("/audit", memoryStore, ListAuditRecords)
+	defer testService.Server.Close()
+	owner := newOwner(t, memoryStore, "audit@example.com")
+	for i := 0; i < 3; i++ {
+		resp, err := http.Post(testService.Server.URL+"/wallet", "application/json", strings.NewReader(fmt.Sprintf(`{"owner_id":%d}`, owner.ID)))
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+	}
+
+	resp, err := http.Get(testService.Server.URL + "/audit?action=wallet.created&limit=2")
+
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	page := auditPage{}
+	decodeBody(t, resp, &page)
+	assert.Len(t, page.Records, 2)
+	assert.True(t, page.Records[0].ID > page.Records[1].ID)
+	assert.Equal(t, page.Records[1].ID, page.NextBeforeId)
+
+	resp, err = http.Get(fmt.Sprintf("%s/audit?action=wallet.created&before_id=%d", testService.Server.URL, page.NextBeforeId))
+
+	assert.NoError(t, err)
+	next := auditPage{}
+	decodeBody(t, resp, &next)
+	assert.Len(t, next.Records, 1)
+	assert.Zero(t, next.NextBeforeId)
+
+	resp, err = http.Get(fmt.Sprintf("%s/audit?wallet_id=%d", testService.Server.URL, *next.Records[0].WalletId))
+
+	assert.NoError(t, err)
+	byWallet := auditPage{}
+	decodeBody(t, resp, &byWallet)
+	assert.Len(t, byWallet.Records, 1)
+	assert.Equal(t, next.Records[0].ID, byWallet.Records[0].ID)
+}
+
+func TestListAuditRecordsFailsWith403WithoutAdminScope(t *testing.T) {
+	memoryStore := store.NewMemoryStore()
+	testService := testutils.NewTestServer().AsOwner(1).RegisterHandler("/audit", memoryStore, ListAuditRecords)
+	defer testService.Server.Close()
+
+	resp, err := http.Get(testService.Server.URL + "/audit")
+
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+}
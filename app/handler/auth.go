@@ -0,0 +1,86 @@
+package handler
+
+import (
+	"net/http"
+	"strings"
+	"wallet/app/apperror"
+	"wallet/app/auth"
+	"wallet/app/model"
+	"wallet/app/store"
+)
+
+// Authenticate lets through requests carrying a valid bearer token and
+// stores its claims in the request context for the handlers to authorize
+// against.
+func Authenticate(verifier *auth.Verifier, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		header := r.Header.Get("Authorization")
+		if !strings.HasPrefix(header, "Bearer ") {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			respondError(w, r, apperror.ErrUnauthenticated.WithMessage("bearer token required"))
+			return
+		}
+		claims, err := verifier.Verify(strings.TrimPrefix(header, "Bearer "))
+		if err != nil {
+			w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+			respondError(w, r, apperror.ErrUnauthenticated.WithMessage(err.Error()))
+			return
+		}
+		next(w, r.WithContext(auth.NewContext(r.Context(), claims)))
+	}
+}
+
+func isAdmin(r *http.Request) bool {
+	claims, ok := auth.FromContext(r.Context())
+	return ok && claims.HasScope(auth.AdminScope)
+}
+
+// callerOwnerId is the owner making the request, if the token names one.
+func callerOwnerId(r *http.Request) (uint, bool) {
+	claims, ok := auth.FromContext(r.Context())
+	if !ok {
+		return 0, false
+	}
+	return claims.OwnerId()
+}
+
+// canAccessWallet reports whether the caller owns wallet or holds the admin
+// scope.
+func canAccessWallet(r *http.Request, wallet model.Wallet) bool {
+	if isAdmin(r) {
+		return true
+	}
+	ownerId, ok := callerOwnerId(r)
+	return ok && wallet.OwnerId != nil && *wallet.OwnerId == ownerId
+}
+
+// authorizeWallet loads the wallet and answers 404 when it does not exist,
+// even to admins, and 403 unless the caller may act on it. It reports
+// whether the handler may go on.
+func authorizeWallet(s store.Store, w http.ResponseWriter, r *http.Request, walletId uint) bool {
+	_, ok := authorizedWallet(s, w, r, walletId)
+	return ok
+}
+
+// authorizedWallet is authorizeWallet returning the wallet it loaded.
+func authorizedWallet(s store.Store, w http.ResponseWriter, r *http.Request, walletId uint) (model.Wallet, bool) {
+	wallet, err := s.GetWallet(walletId)
+	if err != nil {
+		respondError(w, r, apperror.Internal("failed while fetching wallet information", notFoundAs(err, apperror.ErrWalletNotFound)))
+		return wallet, false
+	}
+	if !canAccessWallet(r, wallet) {
+		respondError(w, r, apperror.ErrForbidden.WithMessage("access to wallet denied"))
+		return wallet, false
+	}
+	return wallet, true
+}
+
+// requireAdmin answers 403 unless the caller holds the admin scope.
+func requireAdmin(w http.ResponseWriter, r *http.Request) bool {
+	if !isAdmin(r) {
+		respondError(w, r, apperror.ErrForbidden.WithMessage("admin scope required"))
+		return false
+	}
+	return true
+}
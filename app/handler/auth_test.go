@@ -0,0 +1,129 @@
+package handler
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+	"wallet/app/auth"
+	"wallet/app/constant"
+	"wallet/app/model"
+	"wallet/app/money"
+	"wallet/app/store"
+	"wallet/testutils"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newOwnedWallet(t *testing.T, s store.Store, ownerId uint, balance int64) model.Wallet {
+	wallet := model.Wallet{OwnerId: &ownerId, Currency: "USD", Balance: money.New(balance, 2), HeldBalance: money.New(0, 2)}
+	assert.NoError(t, openWallet(s, &wallet))
+	return wallet
+}
+
+func TestAuthenticateRejectsMissingAndInvalidTokens(t *testing.T) {
+	verifier := auth.NewVerifier(map[string]string{"": "secret"})
+	handler := Authenticate(verifier, func(w http.ResponseWriter, r *http.Request) {
+		t.Error("handler called without a valid token")
+	})
+	forged, _ := auth.Sign(auth.Claims{Subject: "1", ExpiresAt: time.Now().Add(time.Hour).Unix()}, "", []byte("guess"))
+
+	for _, header := range []string{"", "Basic abc", "Bearer " + forged} {
+		writer := httptest.NewRecorder()
+		request := httptest.NewRequest(http.MethodGet, "/wallet/1", nil)
+		request.Header.Set("Authorization", header)
+		handler(writer, request)
+		assert.Equal(t, http.StatusUnauthorized, writer.Code, header)
+	}
+}
+
+func TestAuthenticatePassesClaimsToHandler(t *testing.T) {
+	verifier := auth.NewVerifier(map[string]string{"": "secret"})
+	token, _ := auth.Sign(auth.Claims{Subject: "42", ExpiresAt: time.Now().Add(time.Hour).Unix()}, "", []byte("secret"))
+	var ownerId uint
+	handler := Authenticate(verifier, func(w http.ResponseWriter, r *http.Request) {
+		ownerId, _ = callerOwnerId(r)
+	})
+	request := httptest.NewRequest(http.MethodGet, "/wallet/1", nil)
+	request.Header.Set("Authorization", "Bearer "+token)
+
+	handler(httptest.NewRecorder(), request)
+
+	assert.Equal(t, uint(42), ownerId)
+}
+
+func TestWalletHandlersReturn403ForOtherOwnersWallet(t *testing.T) {
+	memoryStore := store.NewMemoryStore()
+	owner := newOwner(t, memoryStore, "owner@example.com")
+	other := newOwner(t, memoryStore, "other@example.com")
+	wallet := newOwnedWallet(t, memoryStore, owner.ID, 10000)
+	transaction, err := processTransaction(model.Transaction{WalletId: wallet.ID, Type: constant.DEBIT, Amount: money.New(1000, 2)}, memoryStore)
+	assert.NoError(t, err)
+	testService := testutils.NewTestServer().AsOwner(other.ID).
+		RegisterHandler("/wallet/{wallet_id}", memoryStore, GetWallet).
+		RegisterHandler("/wallet/{wallet_id}/transactions", memoryStore, GetWalletTransactions).
+		RegisterHandler("/transaction", memoryStore, CreateTransaction).
+		RegisterHandler("/transaction/{tran_id}", memoryStore, RevertTransaction)
+	defer testService.Server.Close()
+	url := testService.Server.URL
+
+	resp, err := http.Get(fmt.Sprintf("%s/wallet/%d", url, wallet.ID))
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+	resp, err = http.Get(fmt.Sprintf("%s/wallet/%d/transactions", url, wallet.ID))
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+	resp, err = http.Post(url+"/transaction", "application/json", strings.NewReader(fmt.Sprintf(`{"wallet_id":%d, "type":"DEBIT", "amount":"10.00"}`, wallet.ID)))
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+	req, _ := http.NewRequest(http.MethodDelete, fmt.Sprintf("%s/transaction/%d", url, transaction.ID), nil)
+	resp, err = http.DefaultClient.Do(req)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+	assert.Equal(t, money.New(9000, 2), getWallet(t, memoryStore, wallet.ID).Balance)
+}
+
+func TestOwnerCanUseOwnWallet(t *testing.T) {
+	memoryStore := store.NewMemoryStore()
+	owner := newOwner(t, memoryStore, "owner@example.com")
+	wallet := newOwnedWallet(t, memoryStore, owner.ID, 10000)
+	testService := testutils.NewTestServer().AsOwner(owner.ID).
+		RegisterHandler("/wallet/{wallet_id}", memoryStore, GetWallet).
+		RegisterHandler("/transaction", memoryStore, CreateTransaction)
+	defer testService.Server.Close()
+
+	resp, err := http.Get(fmt.Sprintf("%s/wallet/%d", testService.Server.URL, wallet.ID))
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	resp, err = http.Post(testService.Server.URL+"/transaction", "application/json", strings.NewReader(fmt.Sprintf(`{"wallet_id":%d, "type":"DEBIT", "amount":"10.00"}`, wallet.ID)))
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+}
+
+func TestCreateWalletFailsWith403ForAnotherOwner(t *testing.T) {
+	memoryStore := store.NewMemoryStore()
+	owner := newOwner(t, memoryStore, "owner@example.com")
+	other := newOwner(t, memoryStore, "other@example.com")
+	writer := httptest.NewRecorder()
+	body := strings.NewReader(fmt.Sprintf(`{"owner_id":%d}`, other.ID))
+
+	CreateWallet(memoryStore, writer, asOwner(httptest.NewRequest(http.MethodPost, "/wallet", body), owner.ID))
+
+	assert.Equal(t, http.StatusForbidden, writer.Code)
+}
+
+func TestFreezeWalletFailsWith403WithoutAdminScope(t *testing.T) {
+	memoryStore := store.NewMemoryStore()
+	owner := newOwner(t, memoryStore, "owner@example.com")
+	wallet := newOwnedWallet(t, memoryStore, owner.ID, 0)
+	testService := testutils.NewTestServer().AsOwner(owner.ID).RegisterHandler("/wallet/{wallet_id}/freeze", memoryStore, FreezeWallet)
+	defer testService.Server.Close()
+
+	resp, err := http.Post(fmt.Sprintf("%s/wallet/%d/freeze", testService.Server.URL, wallet.ID), "application/json", strings.NewReader(`{"reason":"AML_REVIEW"}`))
+
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+	assert.Equal(t, constant.WALLET_ACTIVE, getWallet(t, memoryStore, wallet.ID).Status)
+}
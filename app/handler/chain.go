@@ -0,0 +1,153 @@
+package handler
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+	"wallet/app/apperror"
+	"wallet/app/constant"
+	"wallet/app/model"
+	"wallet/app/store"
+)
+
+// ChainBreak is the first link of a wallet's hash chain that does not hold.
+type ChainBreak struct {
+	TransactionId *uint  `json:"transaction_id,omitempty"`
+	Kind          string `json:"kind"`
+	Expected      string `json:"expected"`
+	Actual        string `json:"actual"`
+}
+
+type ChainReport struct {
+	WalletId            uint        `json:"wallet_id"`
+	TransactionsChecked int         `json:"transactions_checked"`
+	Head                string      `json:"head"`
+	Break               *ChainBreak `json:"break,omitempty"`
+}
+
+// ChainHead is the hash of a wallet's latest transaction.
+type ChainHead struct {
+	WalletId uint   `json:"wallet_id"`
+	Hash     string `json:"hash"`
+}
+
+// ChainHeads are the heads of every wallet's chain at a point in time. Root
+// hashes them all, so anchoring Root outside the system pins every
+// transaction made until then.
+type ChainHeads struct {
+	At    time.Time   `json:"at"`
+	Root  string      `json:"root"`
+	Heads []ChainHead `json:"heads"`
+}
+
+// chainTransaction links a transaction just created to the hash chain of its
+// wallet, which must be locked, and moves the wallet's head to it. The
+// caller writes the head with UpdateWalletChainHash.
+func chainTransaction(tx store.Store, wallet *model.Wallet, transaction *model.Transaction) error {
+	transaction.PrevHash = wallet.ChainHash
+	hash, err := transaction.ComputeHash()
+	if err != nil {
+		return err
+	}
+	transaction.Hash = hash
+	if err := tx.UpdateTransactionHash(transaction); err != nil {
+		return err
+	}
+	wallet.ChainHash = hash
+	return nil
+}
+
+// VerifyChain walks a wallet's transactions in the order they were made and
+// reports the first one whose hash does not match its contents or whose
+// PrevHash is not the hash of the one before, or a head that is not the
+// last hash, i.e. transactions removed from the end.
+func VerifyChain(s store.Store, walletId uint) (ChainReport, error) {
+	report := ChainReport{WalletId: walletId}
+	err := s.Atomic(func(tx store.Store) error {
+		wallet, err := tx.LockWallet(walletId)
+		if err != nil {
+			return err
+		}
+		transactions, err := tx.ListTransactions(walletId, store.TransactionFilter{})
+		if err != nil {
+			return err
+		}
+		sort.Slice(transactions, func(i, j int) bool { return transactions[i].ID < transactions[j].ID })
+		report.Head = wallet.ChainHash
+		previous := ""
+		for i := range transactions {
+			transaction := transactions[i]
+			report.TransactionsChecked++
+			if transaction.PrevHash != previous {
+				report.Break = &ChainBreak{TransactionId: &transaction.ID, Kind: constant.CHAIN_LINK_BROKEN, Expected: previous, Actual: transaction.PrevHash}
+				return nil
+			}
+			hash, err := transaction.ComputeHash()
+			if err != nil {
+				return err
+			}
+			if hash != transaction.Hash {
+				report.Break = &ChainBreak{TransactionId: &transaction.ID, Kind: constant.CHAIN_HASH_MISMATCH, Expected: hash, Actual: transaction.Hash}
+				return nil
+			}
+			previous = transaction.Hash
+		}
+		if wallet.ChainHash != previous {
+			report.Break = &ChainBreak{Kind: constant.CHAIN_HEAD_MISMATCH, Expected: previous, Actual: wallet.ChainHash}
+		}
+		return nil
+	})
+	return report, err
+}
+
+// GetChainHeads lists the head of every wallet's hash chain.
+func GetChainHeads(s store.Store) (ChainHeads, error) {
+	heads := ChainHeads{At: time.Now().UTC(), Heads: []ChainHead{}}
+	wallets, err := s.ListWallets()
+	if err != nil {
+		return heads, err
+	}
+	root := sha256.New()
+	for _, wallet := range wallets {
+		heads.Heads = append(heads.Heads, ChainHead{WalletId: wallet.ID, Hash: wallet.ChainHash})
+		fmt.Fprintf(root, "%d:%s\n", wallet.ID, wallet.ChainHash)
+	}
+	heads.Root = hex.EncodeToString(root.Sum(nil))
+	return heads, nil
+}
+
+// VerifyWalletChain verifies the hash chain of the wallet given as
+// ?wallet_id=.
+func VerifyWalletChain(s store.Store, w http.ResponseWriter, r *http.Request) {
+	if !requireAdmin(w, r) {
+		return
+	}
+	walletId, err := strconv.ParseUint(r.URL.Query().Get("wallet_id"), 10, 64)
+	if err != nil {
+		respondError(w, r, apperror.BadRequest("invalid wallet id"))
+		return
+	}
+	report, err := VerifyChain(s, uint(walletId))
+	err = notFoundAs(err, apperror.ErrWalletNotFound)
+	if err != nil {
+		respondError(w, r, apperror.Internal("failed to verify chain", err))
+		return
+	}
+	respondSuccess(w, report)
+}
+
+func ExportChainHeads(s store.Store, w http.ResponseWriter, r *http.Request) {
+	if !requireAdmin(w, r) {
+		return
+	}
+	heads, err := GetChainHeads(s)
+	if err != nil {
+		respondError(w, r, apperror.Internal("failed while fetching chain heads", err))
+		return
+	}
+	respondSuccess(w, heads)
+}
@@ -0,0 +1,93 @@
+package handler
+
+import (
+	"testing"
+	"wallet/app/constant"
+	"wallet/app/model"
+	"wallet/app/money"
+	"wallet/app/store"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newChainedWallet(t *testing.T, s store.Store) (model.Wallet, []model.Transaction) {
+	wallet := newWallet(t, s, "USD", 0, 0)
+	var transactions []model.Transaction
+	for _, transaction := range []model.Transaction{
+		{WalletId: wallet.ID, Type: constant.CREDIT, Amount: money.New(1000, 2)},
+		{WalletId: wallet.ID, Type: constant.DEBIT, Amount: money.New(250, 2)},
+		{WalletId: wallet.ID, Type: constant.CREDIT, Amount: money.New(75, 2)},
+	} {
+		posted, err := processTransaction(transaction, s)
+		assert.NoError(t, err)
+		transactions = append(transactions, *posted)
+	}
+	return getWallet(t, s, wallet.ID), transactions
+}
+
+func TestTransactionsAreChainedPerWallet(t *testing.T) {
+	memoryStore := store.NewMemoryStore()
+	wallet, transactions := newChainedWallet(t, memoryStore)
+	other := newWallet(t, memoryStore, "USD", 0, 0)
+	_, err := processTransaction(model.Transaction{WalletId: other.ID, Type: constant.CREDIT, Amount: money.New(100, 2)}, memoryStore)
+	assert.NoError(t, err)
+
+	assert.Empty(t, transactions[0].PrevHash)
+	assert.Equal(t, transactions[0].Hash, transactions[1].PrevHash)
+	assert.Equal(t, transactions[1].Hash, transactions[2].PrevHash)
+	assert.Equal(t, transactions[2].Hash, wallet.ChainHash)
+	report, err := VerifyChain(memoryStore, wallet.ID)
+	assert.NoError(t, err)
+	assert.Nil(t, report.Break)
+	assert.Equal(t, 3, report.TransactionsChecked)
+	assert.Equal(t, wallet.ChainHash, report.Head)
+}
+
+func TestVerifyChainReportsFirstBrokenLink(t *testing.T) {
+	memoryStore := store.NewMemoryStore()
+	wallet, transactions := newChainedWallet(t, memoryStore)
+	edited := transactions[1]
+	edited.Hash = transactions[0].Hash
+	assert.NoError(t, memoryStore.UpdateTransactionHash(&edited))
+
+	report, err := VerifyChain(memoryStore, wallet.ID)
+
+	assert.NoError(t, err)
+	assert.Equal(t, 2, report.TransactionsChecked)
+	assert.Equal(t, &ChainBreak{TransactionId: &edited.ID, Kind: constant.CHAIN_HASH_MISMATCH, Expected: transactions[1].Hash, Actual: edited.Hash}, report.Break)
+}
+
+func TestVerifyChainReportsUnchainedAndRemovedTransactions(t *testing.T) {
+	memoryStore := store.NewMemoryStore()
+	wallet, transactions := newChainedWallet(t, memoryStore)
+	wallet.ChainHash = transactions[1].Hash
+	assert.NoError(t, memoryStore.UpdateWalletChainHash(&wallet))
+
+	report, err := VerifyChain(memoryStore, wallet.ID)
+
+	assert.NoError(t, err)
+	assert.Equal(t, constant.CHAIN_HEAD_MISMATCH, report.Break.Kind)
+	assert.Equal(t, transactions[2].Hash, report.Break.Expected)
+
+	inserted := newTransaction(t, memoryStore, model.Transaction{WalletId: wallet.ID, Type: constant.CREDIT, Amount: money.New(1, 2), Currency: "USD"})
+	report, err = VerifyChain(memoryStore, wallet.ID)
+
+	assert.NoError(t, err)
+	assert.Equal(t, constant.CHAIN_LINK_BROKEN, report.Break.Kind)
+	assert.Equal(t, inserted.ID, *report.Break.TransactionId)
+}
+
+func TestChainHeadsChangeWithEveryTransaction(t *testing.T) {
+	memoryStore := store.NewMemoryStore()
+	wallet, _ := newChainedWallet(t, memoryStore)
+	heads, err := GetChainHeads(memoryStore)
+	assert.NoError(t, err)
+	assert.Equal(t, []ChainHead{{WalletId: wallet.ID, Hash: wallet.ChainHash}}, heads.Heads)
+
+	_, err = processTransaction(model.Transaction{WalletId: wallet.ID, Type: constant.CREDIT, Amount: money.New(1, 2)}, memoryStore)
+	assert.NoError(t, err)
+
+	next, err := GetChainHeads(memoryStore)
+	assert.NoError(t, err)
+	assert.NotEqual(t, heads.Root, next.Root)
+}
@@ -1,10 +1,11 @@
 package handler
 
 import (
+	"context"
 	"encoding/json"
-	"fmt"
-	"log"
 	"net/http"
+	"wallet/app/apperror"
+	"wallet/app/store"
 )
 
 func respondSuccess(w http.ResponseWriter, payload interface{}) {
@@ -19,11 +20,46 @@ func respondSuccess(w http.ResponseWriter, payload interface{}) {
 	w.Write([]byte(response))
 }
 
-func respondError(w http.ResponseWriter, code int, message string) {
-	payload := map[string]string{"error": message}
+// notFoundAs is notFound when err says a record the request names does not
+// exist, and err otherwise.
+func notFoundAs(err error, notFound *apperror.Error) error {
+	if err == store.ErrNotFound {
+		return notFound
+	}
+	return err
+}
+
+type errorResponse struct {
+	Code      string                 `json:"code"`
+	Message   string                 `json:"message"`
+	Details   map[string]interface{} `json:"details,omitempty"`
+	RequestId string                 `json:"request_id,omitempty"`
+}
+
+// respondError answers with the domain error err carries. Any other error is
+// answered as an INTERNAL error without exposing it to the client.
+func respondError(w http.ResponseWriter, r *http.Request, err error) {
+	appErr := apperror.From(err)
+	if appErr == nil {
+		appErr = apperror.ErrInternal
+	}
+	// past the deadline, internal errors are the DB giving up on the request
+	if appErr.Code == apperror.ErrInternal.Code && r.Context().Err() == context.DeadlineExceeded {
+		appErr = apperror.ErrTimeout
+	}
+	payload := errorResponse{
+		Code:      appErr.Code,
+		Message:   appErr.Message,
+		Details:   appErr.Details,
+		RequestId: r.Header.Get(requestIdHeader),
+	}
 	w.Header().Set("Content-Type", "application/json")
 	response, _ := json.Marshal(payload)
-	w.WriteHeader(code)
-	log.Print(fmt.Sprintf("Error processing request with %s", message))
+	w.WriteHeader(appErr.Status)
+	if appErr.Status >= http.StatusInternalServerError {
+		loggerFor(r).Error("request failed", "code", appErr.Code, "error", err)
+	} else {
+		loggerFor(r).Info("request rejected", "code", appErr.Code, "error", err)
+	}
 	w.Write([]byte(response))
 }
@@ -2,11 +2,17 @@ package handler
 
 import (
 	"encoding/json"
+	"fmt"
 	"github.com/stretchr/testify/assert"
 	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
 	"testing"
+	"wallet/app/apperror"
+	"wallet/app/auth"
+	"wallet/app/model"
+	"wallet/app/money"
+	"wallet/app/store"
 )
 
 type args struct {
@@ -51,17 +57,85 @@ func Test_respondSuccess(t *testing.T) {
 
 func Test_responseError(t *testing.T) {
 	writer := httptest.NewRecorder()
-	message := "something went wrong"
-	code := http.StatusInternalServerError
-	errorResponse := make(map[string]string)
+	request := httptest.NewRequest(http.MethodGet, "/", nil)
+	request.Header.Set(requestIdHeader, "req-1")
+	errorResponse := errorResponse{}
 
-	respondError(writer, code, message)
+	respondError(writer, request, apperror.ErrLimitExceeded.WithDetails(map[string]interface{}{"limit": "daily_debit"}))
 
 	resp := writer.Result()
 	body, _ := ioutil.ReadAll(resp.Body)
 	json.Unmarshal(body, &errorResponse)
 
-	assert.Equal(t, errorResponse["error"], message)
+	assert.Equal(t, "LIMIT_EXCEEDED", errorResponse.Code)
+	assert.Equal(t, apperror.ErrLimitExceeded.Message, errorResponse.Message)
+	assert.Equal(t, "daily_debit", errorResponse.Details["limit"])
+	assert.Equal(t, "req-1", errorResponse.RequestId)
 	assert.Equal(t, resp.Header.Get("Content-Type"), "application/json")
-	assert.Equal(t, 500, resp.StatusCode)
+	assert.Equal(t, http.StatusUnprocessableEntity, resp.StatusCode)
+}
+
+func Test_responseErrorHidesInternalErrors(t *testing.T) {
+	writer := httptest.NewRecorder()
+	errorResponse := errorResponse{}
+
+	respondError(writer, httptest.NewRequest(http.MethodGet, "/", nil), apperror.Internal("failed while fetching wallet information", fmt.Errorf("connection refused")))
+
+	resp := writer.Result()
+	body, _ := ioutil.ReadAll(resp.Body)
+	json.Unmarshal(body, &errorResponse)
+
+	assert.Equal(t, "INTERNAL", errorResponse.Code)
+	assert.Equal(t, "failed while fetching wallet information", errorResponse.Message)
+	assert.Equal(t, http.StatusInternalServerError, resp.StatusCode)
+}
+
+func newWallet(t *testing.T, s store.Store, currency string, balance, held int64) model.Wallet {
+	exponent, err := money.CurrencyExponent(currency)
+	assert.NoError(t, err)
+	wallet := model.Wallet{
+		Currency:    currency,
+		Balance:     money.New(balance, exponent),
+		HeldBalance: money.New(held, exponent),
+	}
+	assert.NoError(t, openWallet(s, &wallet))
+	return wallet
+}
+
+func newOwner(t *testing.T, s store.Store, email string) model.Owner {
+	owner := model.Owner{Name: "Test Owner", Email: email}
+	assert.NoError(t, s.CreateOwner(&owner))
+	return owner
+}
+
+// asOwner authenticates r as the owner, without the admin scope.
+func asOwner(r *http.Request, ownerId uint) *http.Request {
+	return r.WithContext(auth.NewContext(r.Context(), auth.Claims{Subject: fmt.Sprint(ownerId)}))
+}
+
+// asAdmin authenticates r as the admin caller testutils.NewTestServer uses.
+func asAdmin(r *http.Request) *http.Request {
+	return r.WithContext(auth.NewContext(r.Context(), auth.Claims{Subject: "admin", Scope: auth.AdminScope}))
+}
+
+func newTransaction(t *testing.T, s store.Store, transaction model.Transaction) model.Transaction {
+	assert.NoError(t, s.CreateTransaction(&transaction))
+	return transaction
+}
+
+func getWallet(t *testing.T, s store.Store, id uint) model.Wallet {
+	wallet, err := s.GetWallet(id)
+	assert.NoError(t, err)
+	return wallet
+}
+
+func getTransaction(t *testing.T, s store.Store, id uint) model.Transaction {
+	transaction, err := s.GetTransaction(id)
+	assert.NoError(t, err)
+	return transaction
+}
+
+func decodeBody(t *testing.T, resp *http.Response, v interface{}) {
+	defer resp.Body.Close()
+	assert.NoError(t, json.NewDecoder(resp.Body).Decode(v))
 }
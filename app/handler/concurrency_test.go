@@ -0,0 +1,169 @@
+package handler
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"wallet/app/model"
+	"wallet/app/money"
+	"wallet/app/store"
+	"wallet/testutils"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Each race runs against the MemoryStore, whose Atomic calls stand in for
+// row locks, and again against a real MySQL database (see
+// testutils.NewIntegrationDb), whose row locks cannot be exercised through
+// sqlmock.
+
+// doConcurrently sends every request at once and counts the answers by
+// status.
+func doConcurrently(t *testing.T, requests []*http.Request) map[int]int {
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	statuses := make(map[int]int)
+	for _, request := range requests {
+		wg.Add(1)
+		go func(request *http.Request) {
+			defer wg.Done()
+			resp, err := http.DefaultClient.Do(request)
+			if !assert.NoError(t, err) {
+				return
+			}
+			resp.Body.Close()
+			mu.Lock()
+			statuses[resp.StatusCode]++
+			mu.Unlock()
+		}(request)
+	}
+	wg.Wait()
+	return statuses
+}
+
+func postConcurrently(t *testing.T, url string, bodies []string) map[int]int {
+	requests := make([]*http.Request, len(bodies))
+	for i, body := range bodies {
+		requests[i] = newRequest(t, http.MethodPost, url, body)
+	}
+	return doConcurrently(t, requests)
+}
+
+func newRequest(t *testing.T, method, url, body string) *http.Request {
+	request, err := http.NewRequest(method, url, strings.NewReader(body))
+	assert.NoError(t, err)
+	request.Header.Set("Content-Type", "application/json")
+	return request
+}
+
+func newFundedWallet(t *testing.T, s store.Store, balance money.Money) model.Wallet {
+	wallet := model.Wallet{Balance: balance}
+	assert.NoError(t, openWallet(s, &wallet))
+	return wallet
+}
+
+func TestConcurrentDebitsNeverOverdraw(t *testing.T) {
+	testConcurrentDebitsNeverOverdraw(t, store.NewMemoryStore())
+}
+
+func TestConcurrentDebitsNeverOverdrawOnMySQL(t *testing.T) {
+	testConcurrentDebitsNeverOverdraw(t, store.NewGormStore(testutils.NewIntegrationDb(t)))
+}
+
+func testConcurrentDebitsNeverOverdraw(t *testing.T, s store.Store) {
+	testService := testutils.NewTestServer().RegisterHandler("/transaction", s, CreateTransaction)
+	defer testService.Server.Close()
+	wallet := newFundedWallet(t, s, money.New(20000, 2))
+
+	bodies := make([]string, 50)
+	for i := range bodies {
+		bodies[i] = fmt.Sprintf(`{"wallet_id":%d, "amount":"10.00", "type":"DEBIT"}`, wallet.ID)
+	}
+	statuses := postConcurrently(t, testService.Server.URL+"/transaction", bodies)
+
+	assert.Equal(t, 20, statuses[http.StatusOK])
+	assert.Equal(t, 30, statuses[http.StatusUnprocessableEntity])
+	assertWalletBalance(t, s, wallet, money.New(0, 2), 20)
+}
+
+func TestConcurrentCreditsAndDebitsAreAllApplied(t *testing.T) {
+	testConcurrentCreditsAndDebitsAreAllApplied(t, store.NewMemoryStore())
+}
+
+func TestConcurrentCreditsAndDebitsAreAllAppliedOnMySQL(t *testing.T) {
+	testConcurrentCreditsAndDebitsAreAllApplied(t, store.NewGormStore(testutils.NewIntegrationDb(t)))
+}
+
+func testConcurrentCreditsAndDebitsAreAllApplied(t *testing.T, s store.Store) {
+	testService := testutils.NewTestServer().RegisterHandler("/transaction", s, CreateTransaction)
+	defer testService.Server.Close()
+	wallet := newFundedWallet(t, s, money.New(100000, 2))
+
+	bodies := make([]string, 100)
+	for i := range bodies {
+		tranType := "CREDIT"
+		if i%2 == 1 {
+			tranType = "DEBIT"
+		}
+		bodies[i] = fmt.Sprintf(`{"wallet_id":%d, "amount":"%d.25", "type":"%s"}`, wallet.ID, i/2+1, tranType)
+	}
+	statuses := postConcurrently(t, testService.Server.URL+"/transaction", bodies)
+
+	assert.Equal(t, 100, statuses[http.StatusOK])
+	assertWalletBalance(t, s, wallet, money.New(100000, 2), 100)
+}
+
+func TestTransfersRacingARevertApplyOnce(t *testing.T) {
+	testTransfersRacingARevertApplyOnce(t, store.NewMemoryStore())
+}
+
+func TestTransfersRacingARevertApplyOnceOnMySQL(t *testing.T) {
+	testTransfersRacingARevertApplyOnce(t, store.NewGormStore(testutils.NewIntegrationDb(t)))
+}
+
+// testTransfersRacingARevertApplyOnce reverts a transfer while transfers try
+// to send the same money back. The receiving wallet can only pay once, so
+// exactly one of them may succeed.
+func testTransfersRacingARevertApplyOnce(t *testing.T, s store.Store) {
+	testService := testutils.NewTestServer().
+		RegisterHandler("/transfer", s, CreateTransfer).
+		RegisterHandler("/transaction/{tran_id}", s, RevertTransaction)
+	defer testService.Server.Close()
+	from := newFundedWallet(t, s, money.New(10000, 2))
+	to := newFundedWallet(t, s, money.New(0, 2))
+	transfer, err := processTransfer(s, model.Transfer{FromWalletId: from.ID, ToWalletId: to.ID, Amount: money.New(10000, 2)})
+	assert.NoError(t, err)
+
+	var requests []*http.Request
+	for i := 0; i < 10; i++ {
+		requests = append(requests,
+			newRequest(t, http.MethodDelete, fmt.Sprintf("%s/transaction/%d", testService.Server.URL, transfer.Transactions[0].ID), ""),
+			newRequest(t, http.MethodPost, testService.Server.URL+"/transfer", fmt.Sprintf(`{"from_wallet_id":%d, "to_wallet_id":%d, "amount":"100.00"}`, to.ID, from.ID)))
+	}
+	statuses := doConcurrently(t, requests)
+
+	assert.Equal(t, 1, statuses[http.StatusOK])
+	assertWalletBalance(t, s, from, money.New(10000, 2), 2)
+	assertWalletBalance(t, s, to, money.New(0, 2), 2)
+}
+
+// assertWalletBalance checks the final balance and that every closing
+// balance chains from the previous one, which only holds if no update was
+// lost.
+func assertWalletBalance(t *testing.T, s store.Store, initial model.Wallet, want money.Money, transactionCount int) {
+	wallet, err := s.GetWallet(initial.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, want, wallet.Balance)
+
+	transactions, err := s.ListTransactions(initial.ID, store.TransactionFilter{})
+	assert.NoError(t, err)
+	assert.Len(t, transactions, transactionCount)
+	balance := initial.Balance
+	for i := len(transactions) - 1; i >= 0; i-- {
+		transaction := transactions[i]
+		balance = getUpdatedWalletBalance(model.Wallet{Balance: balance}, transaction)
+		assert.Equal(t, balance, transaction.ClosingBalance)
+	}
+}
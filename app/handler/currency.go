@@ -0,0 +1,57 @@
+package handler
+
+import (
+	"wallet/app/apperror"
+	"wallet/app/model"
+	"wallet/app/money"
+)
+
+// moneyError is the domain error for the errors of package money.
+func moneyError(err error) error {
+	switch err {
+	case money.ErrUnknownCurrency:
+		return apperror.ErrUnknownCurrency
+	case money.ErrOutOfRange:
+		return apperror.ErrAmountOutOfRange
+	}
+	return err
+}
+
+// amountInCurrency returns an amount a request gave in field at the exponent
+// of currency. Amounts are never rounded: one with more decimal places than
+// currency allows fails validation against field. Such amounts used to be
+// rounded to the currency; refusing them means the API never moves a
+// different amount from the one the client sent.
+func amountInCurrency(field string, amount money.Money, currency string) (money.Money, error) {
+	exponent, err := money.CurrencyExponent(currency)
+	if err != nil {
+		return amount, moneyError(err)
+	}
+	scaled, err := amount.Rescale(exponent)
+	if err == money.ErrPrecision {
+		return amount, fieldErrors{field: {precisionMessage(currency)}}.err()
+	}
+	if err != nil {
+		return amount, moneyError(err)
+	}
+	return scaled, nil
+}
+
+func precisionMessage(currency string) string {
+	return "has more decimal places than " + currency + " allows"
+}
+
+// inWalletCurrency checks a transaction's currency, when one was given,
+// against its wallet and puts the amount in the wallet currency.
+func inWalletCurrency(transaction *model.Transaction, wallet model.Wallet) error {
+	if transaction.Currency != "" && transaction.Currency != wallet.Currency {
+		return apperror.ErrCurrencyMismatch
+	}
+	amount, err := amountInCurrency("amount", transaction.Amount, wallet.Currency)
+	if err != nil {
+		return err
+	}
+	transaction.Currency = wallet.Currency
+	transaction.Amount = amount
+	return nil
+}
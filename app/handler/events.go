@@ -0,0 +1,198 @@
+package handler
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+	"wallet/app/constant"
+	"wallet/app/model"
+	"wallet/app/store"
+)
+
+const (
+	signatureHeader = "Wallet-Signature"
+	eventIdHeader   = "Wallet-Event-Id"
+	eventTypeHeader = "Wallet-Event-Type"
+)
+
+// WebhookMaxAttempts is how many times a delivery is tried before it is
+// dead-lettered, and WebhookRetryDelay the wait before the first retry,
+// doubling after each one.
+var (
+	WebhookMaxAttempts = 8
+	WebhookRetryDelay  = 30 * time.Second
+)
+
+// webhookClient delivers webhooks. Receivers that hang are treated as
+// failed deliveries.
+var webhookClient = &http.Client{Timeout: 10 * time.Second}
+
+// walletStatusEvents names the event of each wallet status change.
+var walletStatusEvents = map[string]string{
+	constant.WALLET_ACTIVE: constant.EVENT_WALLET_UNFROZEN,
+	constant.WALLET_FROZEN: constant.EVENT_WALLET_FROZEN,
+	constant.WALLET_CLOSED: constant.EVENT_WALLET_CLOSED,
+}
+
+// recordEvent writes an event carrying the JSON of data to the outbox. It
+// must be called with the Store of the DB transaction making the change, so
+// the event is committed if and only if the change is.
+func recordEvent(tx store.Store, eventType string, walletId uint, data interface{}) error {
+	payload, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	return tx.CreateEvent(&model.Event{Type: eventType, WalletId: walletId, Data: payload})
+}
+
+func recordTransactionEvent(tx store.Store, transaction model.Transaction) error {
+	eventType := constant.EVENT_TRANSACTION_CREATED
+	if transaction.ReversedTransactionId != nil {
+		eventType = constant.EVENT_TRANSACTION_REVERTED
+	}
+	return recordEvent(tx, eventType, transaction.WalletId, transaction)
+}
+
+// DispatchEvents queues a delivery of every undispatched event to each active
+// subscription wanting it.
+func DispatchEvents(s store.Store) error {
+	events, err := s.ListUndispatchedEvents(100)
+	if err != nil {
+		return err
+	}
+	subscriptions, err := s.ListWebhookSubscriptions()
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	for _, event := range events {
+		err := s.Atomic(func(tx store.Store) error {
+			for _, subscription := range subscriptions {
+				if !subscription.Active || !subscription.Subscribes(event.Type) {
+					continue
+				}
+				if err := queueDelivery(tx, event.ID, subscription.ID, now); err != nil {
+					return err
+				}
+			}
+			return tx.MarkEventDispatched(event.ID)
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// queueDelivery sends the event to the subscription again from the first
+// attempt, whether or not it was delivered before.
+func queueDelivery(tx store.Store, eventId, subscriptionId uint, now time.Time) error {
+	delivery, err := tx.GetWebhookDelivery(eventId, subscriptionId)
+	if err != nil && err != store.ErrNotFound {
+		return err
+	}
+	delivery.Status = constant.DELIVERY_PENDING
+	delivery.Attempts = 0
+	delivery.NextAttemptAt = now
+	delivery.ResponseCode = 0
+	delivery.LastError = ""
+	if err == nil {
+		return tx.UpdateWebhookDelivery(&delivery)
+	}
+	delivery.EventId = eventId
+	delivery.SubscriptionId = subscriptionId
+	if err := tx.CreateWebhookDelivery(&delivery); err != nil && err != store.ErrDuplicateKey {
+		return err
+	}
+	return nil
+}
+
+// DeliverWebhooks attempts every delivery that is due. Receivers may see an
+// event more than once and should deduplicate by its ID.
+func DeliverWebhooks(s store.Store) error {
+	now := time.Now()
+	deliveries, err := s.ListDueWebhookDeliveries(now, 100)
+	if err != nil {
+		return err
+	}
+	for _, delivery := range deliveries {
+		if err := deliverWebhook(s, delivery, now); err != nil {
+			Logger.Error("failed to deliver webhook", "delivery_id", delivery.ID, "event_id", delivery.EventId, "error", err)
+		}
+	}
+	return nil
+}
+
+func deliverWebhook(s store.Store, delivery model.WebhookDelivery, now time.Time) error {
+	event, err := s.GetEvent(delivery.EventId)
+	if err != nil {
+		return err
+	}
+	subscription, err := s.GetWebhookSubscription(delivery.SubscriptionId)
+	if err != nil {
+		return err
+	}
+	delivery.Attempts++
+	delivery.ResponseCode = 0
+	delivery.LastError = ""
+	if subscription.Active {
+		delivery.ResponseCode, err = postWebhook(subscription, event, now)
+	} else {
+		err = fmt.Errorf("subscription is no longer active")
+		delivery.Attempts = WebhookMaxAttempts
+	}
+	switch {
+	case err == nil:
+		delivery.Status = constant.DELIVERY_DELIVERED
+	case delivery.Attempts >= WebhookMaxAttempts:
+		delivery.Status = constant.DELIVERY_DEAD
+		delivery.LastError = truncate(err.Error(), 255)
+	default:
+		delivery.NextAttemptAt = now.Add(WebhookRetryDelay << uint(delivery.Attempts-1))
+		delivery.LastError = truncate(err.Error(), 255)
+	}
+	return s.UpdateWebhookDelivery(&delivery)
+}
+
+// postWebhook posts the event to the subscription's URL, signed with its
+// secret, and returns the response code. Anything but a 2xx fails.
+func postWebhook(subscription model.WebhookSubscription, event model.Event, now time.Time) (int, error) {
+	body, err := json.Marshal(event)
+	if err != nil {
+		return 0, err
+	}
+	request, err := http.NewRequest(http.MethodPost, subscription.URL, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set(eventIdHeader, strconv.FormatUint(uint64(event.ID), 10))
+	request.Header.Set(eventTypeHeader, event.Type)
+	request.Header.Set(signatureHeader, signWebhook(subscription.Secret, now, body))
+	response, err := webhookClient.Do(request)
+	if err != nil {
+		return 0, err
+	}
+	response.Body.Close()
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		return response.StatusCode, fmt.Errorf("webhook answered %d", response.StatusCode)
+	}
+	return response.StatusCode, nil
+}
+
+// signWebhook is the Wallet-Signature header of body sent at now:
+// "t=<unix seconds>,v1=<hex HMAC-SHA256 of "<t>.<body>" keyed with secret>".
+// Signing the timestamp lets receivers refuse replayed deliveries.
+func signWebhook(secret string, now time.Time, body []byte) string {
+	timestamp := strconv.FormatInt(now.Unix(), 10)
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(body)
+	return "t=" + timestamp + ",v1=" + hex.EncodeToString(mac.Sum(nil))
+}
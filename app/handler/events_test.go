@@ -0,0 +1,144 @@
+package handler
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+	"wallet/app/constant"
+	"wallet/app/model"
+	"wallet/app/money"
+	"wallet/app/store"
+	"wallet/testutils"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func eventTypesOf(t *testing.T, s store.Store) []string {
+	events, err := s.ListUndispatchedEvents(100)
+	assert.NoError(t, err)
+	var types []string
+	for _, event := range events {
+		types = append(types, event.Type)
+	}
+	return types
+}
+
+func newSubscription(t *testing.T, s store.Store, url string) model.WebhookSubscription {
+	subscription := model.WebhookSubscription{URL: url, EventTypes: []string{"*"}, Secret: "secret", Active: true}
+	assert.NoError(t, s.CreateWebhookSubscription(&subscription))
+	return subscription
+}
+
+func TestEventsAreRecordedWithTheirChanges(t *testing.T) {
+	memoryStore := store.NewMemoryStore()
+	wallet := newWallet(t, memoryStore, "USD", 0, 0)
+	credit, err := processTransaction(model.Transaction{WalletId: wallet.ID, Type: constant.CREDIT, Amount: money.New(1000, 2)}, memoryStore)
+	assert.NoError(t, err)
+	_, err = processTransaction(model.Transaction{WalletId: wallet.ID, Type: constant.DEBIT, Amount: money.New(5000, 2)}, memoryStore)
+	assert.Error(t, err)
+	_, err = processReversal(memoryStore, credit.ID, nil, false)
+	assert.NoError(t, err)
+	_, err = processStatusChange(memoryStore, wallet.ID, constant.WALLET_FROZEN, walletStatusRequest{Reason: "AML_REVIEW"})
+	assert.NoError(t, err)
+
+	assert.Equal(t, []string{
+		constant.EVENT_WALLET_CREATED,
+		constant.EVENT_TRANSACTION_CREATED,
+		constant.EVENT_TRANSACTION_REVERTED,
+		constant.EVENT_WALLET_FROZEN,
+	}, eventTypesOf(t, memoryStore))
+}
+
+func TestWebhooksAreDeliveredSigned(t *testing.T) {
+	memoryStore := store.NewMemoryStore()
+	var body []byte
+	var signature, eventType string
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ = ioutil.ReadAll(r.Body)
+		signature = r.Header.Get(signatureHeader)
+		eventType = r.Header.Get(eventTypeHeader)
+	}))
+	defer receiver.Close()
+	subscription := newSubscription(t, memoryStore, receiver.URL)
+	wallet := newWallet(t, memoryStore, "USD", 0, 0)
+
+	assert.NoError(t, DispatchEvents(memoryStore))
+	assert.NoError(t, DeliverWebhooks(memoryStore))
+
+	assert.Equal(t, constant.EVENT_WALLET_CREATED, eventType)
+	assert.Contains(t, string(body), fmt.Sprintf(`"wallet_id":%d`, wallet.ID))
+	parts := strings.SplitN(signature, ",", 2)
+	assert.Len(t, parts, 2)
+	mac := hmac.New(sha256.New, []byte("secret"))
+	mac.Write([]byte(strings.TrimPrefix(parts[0], "t=") + "."))
+	mac.Write(body)
+	assert.Equal(t, "v1="+hex.EncodeToString(mac.Sum(nil)), parts[1])
+	assert.Empty(t, eventTypesOf(t, memoryStore))
+	events, err := memoryStore.ListWebhookDeliveries(constant.DELIVERY_DELIVERED)
+	assert.NoError(t, err)
+	assert.Len(t, events, 1)
+	assert.Equal(t, subscription.ID, events[0].SubscriptionId)
+}
+
+func TestFailingWebhooksAreDeadLetteredAndReplayed(t *testing.T) {
+	defer func(attempts int, delay time.Duration) {
+		WebhookMaxAttempts, WebhookRetryDelay = attempts, delay
+	}(WebhookMaxAttempts, WebhookRetryDelay)
+	WebhookMaxAttempts, WebhookRetryDelay = 2, 0
+	memoryStore := store.NewMemoryStore()
+	calls := 0
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer receiver.Close()
+	newSubscription(t, memoryStore, receiver.URL)
+	newWallet(t, memoryStore, "USD", 0, 0)
+	assert.NoError(t, DispatchEvents(memoryStore))
+
+	for i := 0; i < 3; i++ {
+		assert.NoError(t, DeliverWebhooks(memoryStore))
+	}
+
+	assert.Equal(t, 2, calls)
+	testService := testutils.NewTestServer().
+		RegisterHandler("/webhooks/deliveries", memoryStore, ListWebhookDeliveries).
+		RegisterHandler("/events/{event_id}/replay", memoryStore, ReplayEvent)
+	defer testService.Server.Close()
+	resp, err := http.Get(testService.Server.URL + "/webhooks/deliveries")
+	assert.NoError(t, err)
+	dead := []model.WebhookDelivery{}
+	decodeBody(t, resp, &dead)
+	assert.Len(t, dead, 1)
+	assert.Equal(t, http.StatusServiceUnavailable, dead[0].ResponseCode)
+
+	resp, err = http.Post(fmt.Sprintf("%s/events/%d/replay", testService.Server.URL, dead[0].EventId), "application/json", nil)
+
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	replayed := []model.WebhookDelivery{}
+	decodeBody(t, resp, &replayed)
+	assert.Len(t, replayed, 1)
+	assert.Equal(t, constant.DELIVERY_PENDING, replayed[0].Status)
+	assert.Equal(t, 0, replayed[0].Attempts)
+	assert.NoError(t, DeliverWebhooks(memoryStore))
+	assert.Equal(t, 3, calls)
+}
+
+func TestCreateWebhookSubscriptionFailsWith400ForUnknownEventType(t *testing.T) {
+	memoryStore := store.NewMemoryStore()
+	testService := testutils.NewTestServer().RegisterHandler("/webhooks", memoryStore, CreateWebhookSubscription)
+	defer testService.Server.Close()
+
+	resp, err := http.Post(testService.Server.URL+"/webhooks", "application/json", strings.NewReader(`{"url":"https://example.com/hook","event_types":["wallet.deleted"]}`))
+
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+}
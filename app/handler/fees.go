@@ -0,0 +1,223 @@
+package handler
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"wallet/app/apperror"
+	"wallet/app/constant"
+	"wallet/app/model"
+	"wallet/app/money"
+	"wallet/app/store"
+
+	"github.com/gorilla/mux"
+)
+
+// feeScheduleFor is the schedule pricing transactionType from walletId, or
+// nil when there is none. A wallet's currency never changes, so reading it
+// before the wallet is locked is safe, and lets the revenue wallet be locked
+// in order with the others.
+func feeScheduleFor(tx store.Store, transactionType string, walletId uint) (*model.FeeSchedule, error) {
+	wallet, err := tx.GetWallet(walletId)
+	if err != nil {
+		return nil, notFoundAs(err, apperror.ErrWalletNotFound)
+	}
+	schedule, err := tx.GetFeeSchedule(transactionType, wallet.Currency)
+	if err == store.ErrNotFound || (err == nil && schedule.RevenueWalletId == walletId) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &schedule, nil
+}
+
+// newFeeLegs returns the DEBIT from the payer and the CREDIT to the revenue
+// wallet of a fee under schedule. Their amounts are set by chargeFee.
+func newFeeLegs(schedule model.FeeSchedule, payerId uint) (model.Transaction, model.Transaction) {
+	debit := model.Transaction{Type: constant.DEBIT, WalletId: payerId}
+	credit := model.Transaction{Type: constant.CREDIT, WalletId: schedule.RevenueWalletId}
+	return debit, credit
+}
+
+// computeFee prices amount under schedule: the flat fee and percentage, in
+// basis points, of the tier covering amount, capped by Min and Max and
+// rounded to the currency of amount.
+func computeFee(schedule model.FeeSchedule, amount money.Money) (money.Money, error) {
+	tier := schedule.Tier(amount)
+	percentage, err := amount.Convert(money.New(tier.BasisPoints, 4), amount.Exponent)
+	if err != nil {
+		return amount, err
+	}
+	fee := tier.Flat.Add(percentage)
+	if schedule.Min != nil && fee.Cmp(*schedule.Min) < 0 {
+		fee = *schedule.Min
+	}
+	if schedule.Max != nil && fee.Cmp(*schedule.Max) > 0 {
+		fee = *schedule.Max
+	}
+	return fee.Round(amount.Exponent)
+}
+
+// chargeFee posts the fee under schedule on charged, which has already been
+// posted, against wallets locked together with the fee legs. A fee that
+// comes to zero is not posted.
+func chargeFee(tx store.Store, wallets map[uint]*model.Wallet, schedule model.FeeSchedule, charged *model.Transaction, debit, credit *model.Transaction) error {
+	fee, err := computeFee(schedule, charged.Amount)
+	if err != nil {
+		return err
+	}
+	if fee.IsZero() {
+		return nil
+	}
+	for _, leg := range []*model.Transaction{debit, credit} {
+		leg.Amount = fee
+		leg.Currency = charged.Currency
+		leg.Description = fmt.Sprint("Fee for :", charged.ID)
+		leg.FeeOfTransactionId = &charged.ID
+	}
+	if err := applyTransactions(tx, wallets, debit, credit); err != nil {
+		return err
+	}
+	charged.Fee = debit
+	return nil
+}
+
+// feeReversals locks the legs of the fees charged on transactionId and
+// returns them with the transactions reversing them.
+func feeReversals(tx store.Store, transactionId uint) ([]model.Transaction, []*model.Transaction, error) {
+	fees, err := tx.LockFeeTransactions(transactionId)
+	if err != nil {
+		return nil, nil, err
+	}
+	var reversals []*model.Transaction
+	for i := range fees {
+		if err := checkReversible(fees[i]); err != nil {
+			return nil, nil, err
+		}
+		reversal := createRevertTransaction(fees[i])
+		reversal.Amount = fees[i].RefundableAmount
+		reversals = append(reversals, &reversal)
+	}
+	return fees, reversals, nil
+}
+
+// markFeesReversed marks the fee legs reversed and sets the reversal of the
+// payer's leg as the Fee of reversal.
+func markFeesReversed(tx store.Store, reversal *model.Transaction, fees []model.Transaction, reversals []*model.Transaction) error {
+	for i := range fees {
+		if err := markRefunded(tx, &fees[i], fees[i].RefundableAmount); err != nil {
+			return err
+		}
+		if fees[i].Type == constant.DEBIT {
+			reversal.Fee = reversals[i]
+		}
+	}
+	return nil
+}
+
+// SetFeeSchedule replaces the fee schedule of a transaction type and
+// currency.
+func SetFeeSchedule(s store.Store, w http.ResponseWriter, r *http.Request) {
+	if !requireAdmin(w, r) {
+		return
+	}
+	vars := mux.Vars(r)
+	transactionType, currency := vars["type"], vars["currency"]
+	if transactionType != constant.DEBIT && transactionType != constant.TRANSFER {
+		respondError(w, r, apperror.ErrInvalidFeeType)
+		return
+	}
+	if _, err := money.CurrencyExponent(currency); err != nil {
+		respondError(w, r, apperror.ErrUnknownCurrency.WithMessage("unsupported currency "+currency))
+		return
+	}
+	request := model.FeeSchedule{}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		respondError(w, r, apperror.Invalid(err))
+		return
+	}
+	if err := feeScheduleInCurrency(&request, currency); err != nil {
+		respondError(w, r, apperror.Invalid(err))
+		return
+	}
+	revenueWallet, err := s.GetWallet(request.RevenueWalletId)
+	if err == store.ErrNotFound || (err == nil && revenueWallet.Currency != currency) {
+		respondError(w, r, apperror.ErrRevenueWalletRequired)
+		return
+	}
+	if err != nil {
+		respondError(w, r, apperror.Internal("failed while fetching wallet information", err))
+		return
+	}
+	schedule, err := s.GetFeeSchedule(transactionType, currency)
+	if err != nil && err != store.ErrNotFound {
+		respondError(w, r, apperror.Internal("failed while fetching fee schedule", err))
+		return
+	}
+	var before interface{}
+	if schedule.ID != 0 {
+		before = schedule
+	}
+	schedule.TransactionType = transactionType
+	schedule.Currency = currency
+	schedule.Min = request.Min
+	schedule.Max = request.Max
+	schedule.RevenueWalletId = request.RevenueWalletId
+	schedule.Tiers = request.Tiers
+	err = s.Atomic(func(tx store.Store) error {
+		if err := tx.SaveFeeSchedule(&schedule); err != nil {
+			return err
+		}
+		return recordAudit(tx, r, constant.AUDIT_FEE_SCHEDULE_SET, nil, before, schedule)
+	})
+	if err != nil {
+		respondError(w, r, apperror.Internal("failed to save fee schedule", err))
+		return
+	}
+	respondSuccess(w, schedule)
+}
+
+// feeScheduleInCurrency validates schedule and puts its amounts in
+// currency. Tiers must be in ascending order of UpTo with only the last one
+// open ended.
+func feeScheduleInCurrency(schedule *model.FeeSchedule, currency string) error {
+	if len(schedule.Tiers) == 0 {
+		return fmt.Errorf("at least one tier is required")
+	}
+	amounts := []**money.Money{&schedule.Min, &schedule.Max}
+	fields := []string{"min", "max"}
+	for i := range schedule.Tiers {
+		tier := &schedule.Tiers[i]
+		last := i == len(schedule.Tiers)-1
+		if (tier.UpTo == nil) != last {
+			return fmt.Errorf("the last tier, and only the last, must leave up_to unset")
+		}
+		if i > 0 && !last && tier.UpTo.Cmp(*schedule.Tiers[i-1].UpTo) <= 0 {
+			return fmt.Errorf("tiers must be in ascending order of up_to")
+		}
+		if tier.BasisPoints < 0 || tier.BasisPoints > 10000 {
+			return fmt.Errorf("basis_points must be between 0 and 10000")
+		}
+		flat := &tier.Flat
+		amounts = append(amounts, &tier.UpTo, &flat)
+		fields = append(fields, fmt.Sprintf("tiers[%d].up_to", i), fmt.Sprintf("tiers[%d].flat", i))
+	}
+	for i, amount := range amounts {
+		if *amount == nil {
+			continue
+		}
+		if (*amount).IsNegative() {
+			return fmt.Errorf("fee amounts must not be negative")
+		}
+		scaled, err := amountInCurrency(fields[i], **amount, currency)
+		if err != nil {
+			return err
+		}
+		**amount = scaled
+	}
+	if schedule.Min != nil && schedule.Max != nil && schedule.Min.Cmp(*schedule.Max) > 0 {
+		return fmt.Errorf("min must not exceed max")
+	}
+	return nil
+}
@@ -0,0 +1,211 @@
+package handler
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+	"wallet/app/constant"
+	"wallet/app/model"
+	"wallet/app/money"
+	"wallet/app/store"
+	"wallet/testutils"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// newFeeSchedule charges transactionType in USD a flat 0.50 plus 1% up to
+// 100.00 and 0.5% above, at least 1.00 and at most 20.00.
+func newFeeSchedule(t *testing.T, s store.Store, transactionType string, revenueWalletId uint) {
+	schedule := model.FeeSchedule{
+		TransactionType: transactionType,
+		Currency:        "USD",
+		Min:             usd(100),
+		Max:             usd(2000),
+		RevenueWalletId: revenueWalletId,
+		Tiers: []model.FeeTier{
+			{UpTo: usd(10000), Flat: money.New(50, 2), BasisPoints: 100},
+			{BasisPoints: 50},
+		},
+	}
+	assert.NoError(t, s.SaveFeeSchedule(&schedule))
+}
+
+func TestComputeFee(t *testing.T) {
+	schedule := model.FeeSchedule{
+		Min: usd(100),
+		Max: usd(2000),
+		Tiers: []model.FeeTier{
+			{UpTo: usd(10000), Flat: money.New(50, 2), BasisPoints: 100},
+			{BasisPoints: 50},
+		},
+	}
+	tests := []struct {
+		amount money.Money
+		fee    money.Money
+	}{
+		{money.New(1000, 2), money.New(100, 2)},
+		{money.New(8000, 2), money.New(130, 2)},
+		{money.New(10000, 2), money.New(150, 2)},
+		{money.New(10001, 2), money.New(100, 2)},
+		{money.New(30099, 2), money.New(150, 2)},
+		{money.New(1000000, 2), money.New(2000, 2)},
+	}
+	for _, test := range tests {
+		fee, err := computeFee(schedule, test.amount)
+		assert.NoError(t, err)
+		assert.Equal(t, test.fee, fee, test.amount.String())
+	}
+}
+
+func TestDebitIsChargedItsFee(t *testing.T) {
+	memoryStore := store.NewMemoryStore()
+	wallet := newWallet(t, memoryStore, "USD", 10000, 0)
+	revenue := newWallet(t, memoryStore, "USD", 0, 0)
+	newFeeSchedule(t, memoryStore, constant.DEBIT, revenue.ID)
+
+	debit, err := processTransaction(model.Transaction{WalletId: wallet.ID, Type: constant.DEBIT, Amount: money.New(8000, 2)}, memoryStore)
+
+	assert.NoError(t, err)
+	assert.Equal(t, money.New(130, 2), debit.Fee.Amount)
+	assert.EqualValues(t, debit.ID, *debit.Fee.FeeOfTransactionId)
+	assert.Equal(t, money.New(1870, 2), getWallet(t, memoryStore, wallet.ID).Balance)
+	assert.Equal(t, money.New(130, 2), getWallet(t, memoryStore, revenue.ID).Balance)
+}
+
+func TestDebitFailsWhenFeeExceedsBalance(t *testing.T) {
+	memoryStore := store.NewMemoryStore()
+	wallet := newWallet(t, memoryStore, "USD", 8000, 0)
+	revenue := newWallet(t, memoryStore, "USD", 0, 0)
+	newFeeSchedule(t, memoryStore, constant.DEBIT, revenue.ID)
+
+	_, err := processTransaction(model.Transaction{WalletId: wallet.ID, Type: constant.DEBIT, Amount: money.New(8000, 2)}, memoryStore)
+
+	assert.Error(t, err)
+	assert.Equal(t, money.New(8000, 2), getWallet(t, memoryStore, wallet.ID).Balance)
+	assert.Equal(t, money.New(0, 2), getWallet(t, memoryStore, revenue.ID).Balance)
+}
+
+func TestCaptureIsChargedTheDebitFee(t *testing.T) {
+	memoryStore := store.NewMemoryStore()
+	wallet := newWallet(t, memoryStore, "USD", 10000, 8000)
+	revenue := newWallet(t, memoryStore, "USD", 0, 0)
+	newFeeSchedule(t, memoryStore, constant.DEBIT, revenue.ID)
+	hold := newHold(t, memoryStore, wallet.ID, 8000, time.Now().Add(time.Hour))
+
+	debit, err := processCapture(memoryStore, hold.ID, nil)
+
+	assert.NoError(t, err)
+	assert.Equal(t, money.New(130, 2), debit.Fee.Amount)
+	assert.Equal(t, money.New(1870, 2), getWallet(t, memoryStore, wallet.ID).Balance)
+	assert.Equal(t, money.New(130, 2), getWallet(t, memoryStore, revenue.ID).Balance)
+}
+
+func TestRevertTransactionReversesFeeOnRequest(t *testing.T) {
+	memoryStore := store.NewMemoryStore()
+	testService := testutils.NewTestServer().RegisterHandler("/transaction/{tran_id}", memoryStore, RevertTransaction)
+	defer testService.Server.Close()
+	wallet := newWallet(t, memoryStore, "USD", 10000, 0)
+	revenue := newWallet(t, memoryStore, "USD", 0, 0)
+	newFeeSchedule(t, memoryStore, constant.DEBIT, revenue.ID)
+	kept, err := processTransaction(model.Transaction{WalletId: wallet.ID, Type: constant.DEBIT, Amount: money.New(1000, 2)}, memoryStore)
+	assert.NoError(t, err)
+	reversed, err := processTransaction(model.Transaction{WalletId: wallet.ID, Type: constant.DEBIT, Amount: money.New(1000, 2)}, memoryStore)
+	assert.NoError(t, err)
+
+	req, _ := http.NewRequest(http.MethodDelete, fmt.Sprintf("%s/transaction/%d", testService.Server.URL, kept.ID), nil)
+	resp, err := http.DefaultClient.Do(req)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	req, _ = http.NewRequest(http.MethodDelete, fmt.Sprintf("%s/transaction/%d?reverse_fee=true", testService.Server.URL, reversed.ID), nil)
+	resp, err = http.DefaultClient.Do(req)
+
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	reversal := model.Transaction{}
+	decodeBody(t, resp, &reversal)
+	assert.EqualValues(t, reversed.Fee.ID, *reversal.Fee.ReversedTransactionId)
+	assert.Equal(t, money.New(9900, 2), getWallet(t, memoryStore, wallet.ID).Balance)
+	assert.Equal(t, money.New(100, 2), getWallet(t, memoryStore, revenue.ID).Balance)
+	assert.Equal(t, constant.NOT_REVERSED, getTransaction(t, memoryStore, kept.Fee.ID).ReversalState)
+	assert.Equal(t, constant.REVERSED, getTransaction(t, memoryStore, reversed.Fee.ID).ReversalState)
+}
+
+func TestRevertTransactionFailsWith400ForFee(t *testing.T) {
+	memoryStore := store.NewMemoryStore()
+	testService := testutils.NewTestServer().RegisterHandler("/transaction/{tran_id}", memoryStore, RevertTransaction)
+	defer testService.Server.Close()
+	wallet := newWallet(t, memoryStore, "USD", 10000, 0)
+	revenue := newWallet(t, memoryStore, "USD", 0, 0)
+	newFeeSchedule(t, memoryStore, constant.DEBIT, revenue.ID)
+	debit, err := processTransaction(model.Transaction{WalletId: wallet.ID, Type: constant.DEBIT, Amount: money.New(1000, 2)}, memoryStore)
+	assert.NoError(t, err)
+	req, _ := http.NewRequest(http.MethodDelete, fmt.Sprintf("%s/transaction/%d", testService.Server.URL, debit.Fee.ID), nil)
+
+	resp, err := http.DefaultClient.Do(req)
+
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	assert.Equal(t, money.New(100, 2), getWallet(t, memoryStore, revenue.ID).Balance)
+}
+
+func TestTransferIsChargedItsFeeAndRevertedWithIt(t *testing.T) {
+	memoryStore := store.NewMemoryStore()
+	from := newWallet(t, memoryStore, "USD", 50000, 0)
+	to := newWallet(t, memoryStore, "USD", 0, 0)
+	revenue := newWallet(t, memoryStore, "USD", 0, 0)
+	newFeeSchedule(t, memoryStore, constant.TRANSFER, revenue.ID)
+
+	transfer, err := processTransfer(memoryStore, model.Transfer{FromWalletId: from.ID, ToWalletId: to.ID, Amount: money.New(20000, 2)})
+
+	assert.NoError(t, err)
+	assert.Equal(t, money.New(100, 2), transfer.Transactions[0].Fee.Amount)
+	assert.Equal(t, money.New(29900, 2), getWallet(t, memoryStore, from.ID).Balance)
+	assert.Equal(t, money.New(20000, 2), getWallet(t, memoryStore, to.ID).Balance)
+	assert.Equal(t, money.New(100, 2), getWallet(t, memoryStore, revenue.ID).Balance)
+
+	_, err = revertTransfer(memoryStore, transfer.ID, true)
+
+	assert.NoError(t, err)
+	assert.Equal(t, money.New(50000, 2), getWallet(t, memoryStore, from.ID).Balance)
+	assert.Equal(t, money.New(0, 2), getWallet(t, memoryStore, to.ID).Balance)
+	assert.Equal(t, money.New(0, 2), getWallet(t, memoryStore, revenue.ID).Balance)
+}
+
+func TestSetFeeScheduleRejectsTiersOutOfOrder(t *testing.T) {
+	memoryStore := store.NewMemoryStore()
+	testService := testutils.NewTestServer().RegisterHandler("/fees/{type}/{currency}", memoryStore, SetFeeSchedule)
+	defer testService.Server.Close()
+	revenue := newWallet(t, memoryStore, "USD", 0, 0)
+	body := fmt.Sprintf(`{"revenue_wallet_id":%d, "tiers":[{"up_to":"100.00","basis_points":100},{"up_to":"50.00","basis_points":50},{"basis_points":25}]}`, revenue.ID)
+	req, _ := http.NewRequest(http.MethodPut, testService.Server.URL+"/fees/DEBIT/USD", strings.NewReader(body))
+
+	resp, err := http.DefaultClient.Do(req)
+
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	_, err = memoryStore.GetFeeSchedule(constant.DEBIT, "USD")
+	assert.Equal(t, store.ErrNotFound, err)
+}
+
+func TestSetFeeScheduleSavesSchedule(t *testing.T) {
+	memoryStore := store.NewMemoryStore()
+	testService := testutils.NewTestServer().RegisterHandler("/fees/{type}/{currency}", memoryStore, SetFeeSchedule)
+	defer testService.Server.Close()
+	revenue := newWallet(t, memoryStore, "JPY", 0, 0)
+	body := fmt.Sprintf(`{"revenue_wallet_id":%d, "min":"10", "tiers":[{"up_to":"10000","flat":"100"},{"basis_points":30}]}`, revenue.ID)
+	req, _ := http.NewRequest(http.MethodPut, testService.Server.URL+"/fees/TRANSFER/JPY", strings.NewReader(body))
+
+	resp, err := http.DefaultClient.Do(req)
+
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	schedule, err := memoryStore.GetFeeSchedule(constant.TRANSFER, "JPY")
+	assert.NoError(t, err)
+	assert.Equal(t, money.New(10, 0), *schedule.Min)
+	assert.Nil(t, schedule.Max)
+	assert.Equal(t, money.New(100, 0), schedule.Tiers[0].Flat)
+	assert.Equal(t, money.New(10000, 0), *schedule.Tiers[0].UpTo)
+	assert.EqualValues(t, 30, schedule.Tiers[1].BasisPoints)
+}
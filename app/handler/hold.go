@@ -0,0 +1,309 @@
+package handler
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+	"wallet/app/apperror"
+	"wallet/app/constant"
+	"wallet/app/logging"
+	"wallet/app/model"
+	"wallet/app/money"
+	"wallet/app/store"
+
+	"github.com/gorilla/mux"
+)
+
+// HoldTTL is how long an authorization hold reserves funds before it
+// expires.
+var HoldTTL = 7 * 24 * time.Hour
+
+// AuthorizeHold reserves funds on a wallet without debiting them.
+func AuthorizeHold(s store.Store, w http.ResponseWriter, r *http.Request) {
+	hold := model.Hold{}
+	if err := json.NewDecoder(r.Body).Decode(&hold); err != nil {
+		respondError(w, r, apperror.Invalid(err))
+		return
+	}
+	if hold.Amount.IsNegative() || hold.Amount.IsZero() {
+		respondError(w, r, apperror.ErrInvalidAmount)
+		return
+	}
+	if !authorizeWallet(s, w, r, hold.WalletId) {
+		return
+	}
+	var result *model.Hold
+	err := s.Atomic(func(tx store.Store) error {
+		var err error
+		result, err = processAuthorization(tx, model.Hold{
+			WalletId:    hold.WalletId,
+			Amount:      hold.Amount,
+			Currency:    hold.Currency,
+			Description: hold.Description,
+		})
+		if err != nil {
+			return err
+		}
+		return recordAudit(tx, r, constant.AUDIT_HOLD_AUTHORIZED, &result.WalletId, nil, result)
+	})
+	if err != nil {
+		respondError(w, r, apperror.Internal("failed to authorize hold", err))
+		return
+	}
+	respondSuccess(w, *result)
+}
+
+type captureRequest struct {
+	Amount *money.Money `json:"amount"`
+}
+
+// CaptureHold debits some or all of the held amount and releases the rest.
+func CaptureHold(s store.Store, w http.ResponseWriter, r *http.Request) {
+	holdId, err := strconv.ParseInt(mux.Vars(r)["hold_id"], 10, 64)
+	if err != nil {
+		respondError(w, r, apperror.BadRequest("invalid hold id"))
+		return
+	}
+	if !authorizeHold(s, w, r, uint(holdId)) {
+		return
+	}
+	request := captureRequest{}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		respondError(w, r, apperror.Invalid(err))
+		return
+	}
+	if request.Amount != nil && (request.Amount.IsNegative() || request.Amount.IsZero()) {
+		respondError(w, r, apperror.ErrInvalidAmount)
+		return
+	}
+	var tran *model.Transaction
+	err = s.Atomic(func(tx store.Store) error {
+		before, err := tx.GetHold(uint(holdId))
+		if err != nil {
+			return notFoundAs(err, apperror.ErrHoldNotFound)
+		}
+		if tran, err = processCapture(tx, uint(holdId), request.Amount); err != nil {
+			return err
+		}
+		return recordAudit(tx, r, constant.AUDIT_HOLD_CAPTURED, &before.WalletId, before, tran)
+	})
+	if err != nil {
+		respondError(w, r, apperror.Internal("failed to capture hold", err))
+		return
+	}
+	respondSuccess(w, *tran)
+}
+
+// VoidHold releases a hold without debiting the wallet.
+func VoidHold(s store.Store, w http.ResponseWriter, r *http.Request) {
+	holdId, err := strconv.ParseInt(mux.Vars(r)["hold_id"], 10, 64)
+	if err != nil {
+		respondError(w, r, apperror.BadRequest("invalid hold id"))
+		return
+	}
+	if !authorizeHold(s, w, r, uint(holdId)) {
+		return
+	}
+	var hold *model.Hold
+	err = s.Atomic(func(tx store.Store) error {
+		before, err := tx.GetHold(uint(holdId))
+		if err != nil {
+			return notFoundAs(err, apperror.ErrHoldNotFound)
+		}
+		if hold, err = releaseHold(tx, uint(holdId), constant.HOLD_VOIDED); err != nil {
+			return err
+		}
+		return recordAudit(tx, r, constant.AUDIT_HOLD_VOIDED, &hold.WalletId, before, hold)
+	})
+	if err != nil {
+		respondError(w, r, apperror.Internal("failed to void hold", err))
+		return
+	}
+	respondSuccess(w, *hold)
+}
+
+func GetHold(s store.Store, w http.ResponseWriter, r *http.Request) {
+	holdId, err := strconv.ParseInt(mux.Vars(r)["hold_id"], 10, 64)
+	if err != nil {
+		respondError(w, r, apperror.BadRequest("invalid hold id"))
+		return
+	}
+	hold, err := s.GetHold(uint(holdId))
+	if err != nil {
+		respondError(w, r, apperror.Internal("failed while fetching hold information", notFoundAs(err, apperror.ErrHoldNotFound)))
+		return
+	}
+	if !authorizeWallet(s, w, r, hold.WalletId) {
+		return
+	}
+	respondSuccess(w, hold)
+}
+
+// authorizeHold checks the hold exists and the caller may act on the wallet
+// holding it.
+func authorizeHold(s store.Store, w http.ResponseWriter, r *http.Request, holdId uint) bool {
+	hold, err := s.GetHold(holdId)
+	if err != nil {
+		respondError(w, r, apperror.Internal("failed while fetching hold information", notFoundAs(err, apperror.ErrHoldNotFound)))
+		return false
+	}
+	return authorizeWallet(s, w, r, hold.WalletId)
+}
+
+func processAuthorization(s store.Store, hold model.Hold) (*model.Hold, error) {
+	err := s.Atomic(func(tx store.Store) error {
+		wallet, err := tx.LockWallet(hold.WalletId)
+		if err != nil {
+			return notFoundAs(err, apperror.ErrWalletNotFound)
+		}
+		if hold.Currency != "" && hold.Currency != wallet.Currency {
+			return apperror.ErrCurrencyMismatch
+		}
+		if err := checkWalletStatus(constant.DEBIT, wallet); err != nil {
+			return err
+		}
+		hold.Currency = wallet.Currency
+		if hold.Amount, err = amountInCurrency("amount", hold.Amount, wallet.Currency); err != nil {
+			return err
+		}
+		if wallet.Balance.Sub(wallet.HeldBalance).Cmp(hold.Amount) < 0 {
+			return apperror.ErrInsufficientFunds
+		}
+		wallet.HeldBalance = wallet.HeldBalance.Add(hold.Amount)
+		if err := tx.UpdateWalletBalances(&wallet); err != nil {
+			return err
+		}
+		hold.Status = constant.HOLD_ACTIVE
+		hold.CapturedAmount = money.New(0, hold.Amount.Exponent)
+		hold.ExpiresAt = time.Now().Add(HoldTTL)
+		return tx.CreateHold(&hold)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &hold, nil
+}
+
+// lockActiveHold locks the hold row and checks it can still be used. Holds
+// past their expiry are reported as expired even before the sweeper has
+// released them.
+func lockActiveHold(tx store.Store, holdId uint) (model.Hold, error) {
+	hold, err := tx.LockHold(holdId)
+	if err != nil {
+		return hold, notFoundAs(err, apperror.ErrHoldNotFound)
+	}
+	if hold.Status != constant.HOLD_ACTIVE {
+		return hold, apperror.ErrHoldNotActive
+	}
+	if !hold.ExpiresAt.After(time.Now()) {
+		return hold, apperror.ErrHoldExpired
+	}
+	return hold, nil
+}
+
+// processCapture debits amount (or the whole hold when nil) and releases the
+// full hold in the same DB transaction. A capture is charged the fee of a
+// direct DEBIT of the same amount.
+func processCapture(s store.Store, holdId uint, amount *money.Money) (*model.Transaction, error) {
+	var debit model.Transaction
+	err := s.Atomic(func(tx store.Store) error {
+		hold, err := lockActiveHold(tx, holdId)
+		if err != nil {
+			return err
+		}
+		captured := hold.Amount
+		if amount != nil {
+			if captured, err = amountInCurrency("amount", *amount, hold.Currency); err != nil {
+				return err
+			}
+			if captured.Cmp(hold.Amount) > 0 {
+				return apperror.ErrCaptureExceedsHold
+			}
+		}
+		debit = model.Transaction{
+			Type:        constant.DEBIT,
+			Amount:      captured,
+			Currency:    hold.Currency,
+			Description: fmt.Sprint("Capture of hold :", hold.ID),
+			WalletId:    hold.WalletId,
+		}
+		schedule, err := feeScheduleFor(tx, constant.DEBIT, hold.WalletId)
+		if err != nil {
+			return err
+		}
+		transactions := []*model.Transaction{&debit}
+		var fee, revenue model.Transaction
+		if schedule != nil {
+			fee, revenue = newFeeLegs(*schedule, hold.WalletId)
+			transactions = append(transactions, &fee, &revenue)
+		}
+		wallets, err := lockWallets(tx, transactions)
+		if err != nil {
+			return err
+		}
+		wallet := wallets[hold.WalletId]
+		wallet.HeldBalance = wallet.HeldBalance.Sub(hold.Amount)
+		if err := applyTransactions(tx, wallets, &debit); err != nil {
+			return err
+		}
+		if schedule != nil {
+			if err := chargeFee(tx, wallets, *schedule, &debit, &fee, &revenue); err != nil {
+				return err
+			}
+		}
+		hold.CapturedAmount = captured
+		hold.Status = constant.HOLD_CAPTURED
+		hold.TransactionId = &debit.ID
+		return tx.UpdateHold(&hold)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &debit, nil
+}
+
+// releaseHold returns the held amount to the wallet's available balance and
+// moves the hold to status, which is VOIDED or EXPIRED.
+func releaseHold(s store.Store, holdId uint, status string) (*model.Hold, error) {
+	var hold model.Hold
+	err := s.Atomic(func(tx store.Store) error {
+		var err error
+		hold, err = lockActiveHold(tx, holdId)
+		if err == apperror.ErrHoldExpired && status == constant.HOLD_EXPIRED {
+			err = nil
+		}
+		if err != nil {
+			return err
+		}
+		wallet, err := tx.LockWallet(hold.WalletId)
+		if err != nil {
+			return err
+		}
+		wallet.HeldBalance = wallet.HeldBalance.Sub(hold.Amount)
+		if err := tx.UpdateWalletBalances(&wallet); err != nil {
+			return err
+		}
+		hold.Status = status
+		return tx.UpdateHold(&hold)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &hold, nil
+}
+
+// ExpireHolds releases every active hold past its expiry time.
+func ExpireHolds(s store.Store) error {
+	holds, err := s.ListExpiredHolds(time.Now())
+	if err != nil {
+		return err
+	}
+	for _, hold := range holds {
+		if _, err := releaseHold(s, hold.ID, constant.HOLD_EXPIRED); err != nil && err != apperror.ErrHoldNotActive {
+			Logger.Error("failed to expire hold", "hold_id", hold.ID, logging.WalletID, hold.WalletId, "error", err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,157 @@
+package handler
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+	"wallet/app/constant"
+	"wallet/app/model"
+	"wallet/app/money"
+	"wallet/app/store"
+	"wallet/testutils"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newHold(t *testing.T, s store.Store, walletId uint, amount int64, expiresAt time.Time) model.Hold {
+	hold := model.Hold{
+		WalletId:       walletId,
+		Amount:         money.New(amount, 2),
+		CapturedAmount: money.New(0, 2),
+		Status:         constant.HOLD_ACTIVE,
+		ExpiresAt:      expiresAt,
+	}
+	assert.NoError(t, s.CreateHold(&hold))
+	return hold
+}
+
+func TestAuthorizeHoldReservesAvailableBalance(t *testing.T) {
+	memoryStore := store.NewMemoryStore()
+	testService := testutils.NewTestServer().RegisterHandler("/hold", memoryStore, AuthorizeHold)
+	defer testService.Server.Close()
+	wallet := newWallet(t, memoryStore, "USD", 10000, 2000)
+
+	resp, err := http.Post(testService.Server.URL+"/hold", "application/json", strings.NewReader(fmt.Sprintf(`{"wallet_id":%d, "amount":"30.00"}`, wallet.ID)))
+
+	hold := model.Hold{}
+	decodeBody(t, resp, &hold)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.NoError(t, err)
+	assert.Equal(t, "ACTIVE", hold.Status)
+	assert.True(t, hold.ExpiresAt.After(time.Now()))
+	assert.Equal(t, money.New(5000, 2), getWallet(t, memoryStore, wallet.ID).HeldBalance)
+}
+
+func TestAuthorizeHoldFailsWith422WhenAvailableBalanceIsTooLow(t *testing.T) {
+	memoryStore := store.NewMemoryStore()
+	testService := testutils.NewTestServer().RegisterHandler("/hold", memoryStore, AuthorizeHold)
+	defer testService.Server.Close()
+	wallet := newWallet(t, memoryStore, "USD", 10000, 8000)
+
+	resp, err := http.Post(testService.Server.URL+"/hold", "application/json", strings.NewReader(fmt.Sprintf(`{"wallet_id":%d, "amount":"30.00"}`, wallet.ID)))
+
+	assert.Equal(t, http.StatusUnprocessableEntity, resp.StatusCode)
+	assert.NoError(t, err)
+	assert.Equal(t, money.New(8000, 2), getWallet(t, memoryStore, wallet.ID).HeldBalance)
+}
+
+func TestAuthorizeHoldRejectsAmountsFinerThanWalletCurrency(t *testing.T) {
+	memoryStore := store.NewMemoryStore()
+	testService := testutils.NewTestServer().RegisterHandler("/hold", memoryStore, AuthorizeHold)
+	defer testService.Server.Close()
+	wallet := newWallet(t, memoryStore, "JPY", 10000, 0)
+
+	resp, err := http.Post(testService.Server.URL+"/hold", "application/json", strings.NewReader(fmt.Sprintf(`{"wallet_id":%d, "amount":"30.5"}`, wallet.ID)))
+
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	assert.True(t, getWallet(t, memoryStore, wallet.ID).HeldBalance.IsZero())
+}
+
+func TestCaptureHoldDebitsCapturedAmountAndReleasesHold(t *testing.T) {
+	memoryStore := store.NewMemoryStore()
+	testService := testutils.NewTestServer().RegisterHandler("/hold/{hold_id}/capture", memoryStore, CaptureHold)
+	defer testService.Server.Close()
+	wallet := newWallet(t, memoryStore, "USD", 10000, 3000)
+	hold := newHold(t, memoryStore, wallet.ID, 3000, time.Now().Add(time.Hour))
+
+	resp, err := http.Post(fmt.Sprintf("%s/hold/%d/capture", testService.Server.URL, hold.ID), "application/json", strings.NewReader(`{"amount":"20.00"}`))
+
+	debit := model.Transaction{}
+	decodeBody(t, resp, &debit)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.NoError(t, err)
+	assert.Equal(t, "DEBIT", debit.Type)
+	assert.Equal(t, money.New(2000, 2), debit.Amount)
+	assert.Equal(t, money.New(8000, 2), debit.ClosingBalance)
+	wallet = getWallet(t, memoryStore, wallet.ID)
+	assert.Equal(t, money.New(8000, 2), wallet.Balance)
+	assert.True(t, wallet.HeldBalance.IsZero())
+	hold, err = memoryStore.GetHold(hold.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, constant.HOLD_CAPTURED, hold.Status)
+	assert.Equal(t, money.New(2000, 2), hold.CapturedAmount)
+	assert.EqualValues(t, debit.ID, *hold.TransactionId)
+}
+
+func TestCaptureHoldFailsWith409ForExpiredHold(t *testing.T) {
+	memoryStore := store.NewMemoryStore()
+	testService := testutils.NewTestServer().RegisterHandler("/hold/{hold_id}/capture", memoryStore, CaptureHold)
+	defer testService.Server.Close()
+	wallet := newWallet(t, memoryStore, "USD", 10000, 3000)
+	hold := newHold(t, memoryStore, wallet.ID, 3000, time.Now().Add(-time.Minute))
+
+	resp, err := http.Post(fmt.Sprintf("%s/hold/%d/capture", testService.Server.URL, hold.ID), "application/json", strings.NewReader(`{}`))
+
+	assert.Equal(t, http.StatusConflict, resp.StatusCode)
+	assert.NoError(t, err)
+	assert.Equal(t, money.New(10000, 2), getWallet(t, memoryStore, wallet.ID).Balance)
+}
+
+func TestVoidHoldReleasesHeldBalance(t *testing.T) {
+	memoryStore := store.NewMemoryStore()
+	testService := testutils.NewTestServer().RegisterHandler("/hold/{hold_id}", memoryStore, VoidHold)
+	defer testService.Server.Close()
+	wallet := newWallet(t, memoryStore, "USD", 10000, 5000)
+	hold := newHold(t, memoryStore, wallet.ID, 3000, time.Now().Add(time.Hour))
+	req, _ := http.NewRequest("DELETE", fmt.Sprintf("%s/hold/%d", testService.Server.URL, hold.ID), nil)
+
+	resp, err := http.DefaultClient.Do(req)
+
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.NoError(t, err)
+	assert.Equal(t, money.New(2000, 2), getWallet(t, memoryStore, wallet.ID).HeldBalance)
+	hold, err = memoryStore.GetHold(hold.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, constant.HOLD_VOIDED, hold.Status)
+}
+
+func TestExpireHoldsReleasesExpiredHolds(t *testing.T) {
+	memoryStore := store.NewMemoryStore()
+	wallet := newWallet(t, memoryStore, "USD", 10000, 5000)
+	expired := newHold(t, memoryStore, wallet.ID, 3000, time.Now().Add(-time.Minute))
+	active := newHold(t, memoryStore, wallet.ID, 2000, time.Now().Add(time.Hour))
+
+	assert.NoError(t, ExpireHolds(memoryStore))
+
+	assert.Equal(t, money.New(2000, 2), getWallet(t, memoryStore, wallet.ID).HeldBalance)
+	expired, _ = memoryStore.GetHold(expired.ID)
+	assert.Equal(t, constant.HOLD_EXPIRED, expired.Status)
+	active, _ = memoryStore.GetHold(active.ID)
+	assert.Equal(t, constant.HOLD_ACTIVE, active.Status)
+}
+
+func TestCreateTransactionCannotDebitHeldFunds(t *testing.T) {
+	memoryStore := store.NewMemoryStore()
+	testService := testutils.NewTestServer().RegisterHandler("/transaction", memoryStore, CreateTransaction)
+	defer testService.Server.Close()
+	wallet := newWallet(t, memoryStore, "USD", 10000, 9000)
+
+	resp, err := http.Post(testService.Server.URL+"/transaction", "application/json", strings.NewReader(fmt.Sprintf(`{"wallet_id":%d, "amount":"10.01", "type":"DEBIT"}`, wallet.ID)))
+
+	assert.Equal(t, http.StatusUnprocessableEntity, resp.StatusCode)
+	assert.NoError(t, err)
+	assert.Equal(t, money.New(10000, 2), getWallet(t, memoryStore, wallet.ID).Balance)
+}
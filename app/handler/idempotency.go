@@ -0,0 +1,103 @@
+package handler
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"time"
+	"wallet/app/apperror"
+	"wallet/app/auth"
+	"wallet/app/model"
+	"wallet/app/store"
+)
+
+const idempotencyKeyHeader = "Idempotency-Key"
+
+// IdempotencyRetention is how long a stored Idempotency-Key is honoured.
+var IdempotencyRetention = 24 * time.Hour
+
+// requestFingerprint identifies a request by its route and body. Keys are
+// already scoped to the caller, see idempotencySubject.
+func requestFingerprint(r *http.Request, body []byte) string {
+	hash := sha256.New()
+	hash.Write([]byte(r.Method + " " + r.URL.Path + "\n"))
+	hash.Write(body)
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+// idempotencySubject is the caller an Idempotency-Key belongs to: the subject
+// of its token.
+func idempotencySubject(r *http.Request) string {
+	claims, _ := auth.FromContext(r.Context())
+	return claims.Subject
+}
+
+func findIdempotencyKey(s store.Store, subject, key string) (*model.IdempotencyKey, error) {
+	record, err := s.GetIdempotencyKey(subject, key)
+	if err == store.ErrNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if now := time.Now(); !record.ExpiresAt.After(now) {
+		return nil, s.DeleteExpiredIdempotencyKey(subject, key, now)
+	}
+	return &record, nil
+}
+
+// replayIdempotentRequest answers the request from a key the caller stored
+// and reports whether it did so. Handlers authorize the request first, so a
+// caller who lost access is not answered from a key.
+func replayIdempotentRequest(s store.Store, w http.ResponseWriter, r *http.Request, key, fingerprint string) bool {
+	record, err := findIdempotencyKey(s, idempotencySubject(r), key)
+	if err != nil {
+		respondError(w, r, apperror.Internal("failed while fetching idempotency key", err))
+		return true
+	}
+	if record == nil {
+		return false
+	}
+	if record.Fingerprint != fingerprint {
+		respondError(w, r, apperror.ErrIdempotencyKeyReused)
+		return true
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Idempotent-Replayed", "true")
+	w.WriteHeader(record.StatusCode)
+	w.Write([]byte(record.Response))
+	return true
+}
+
+// storeIdempotencyKey returns a hook that saves the caller's key with the
+// resulting transaction in the same DB transaction, so a key is only ever
+// recorded for a committed transaction.
+func storeIdempotencyKey(r *http.Request, key, fingerprint string) transactionHook {
+	subject := idempotencySubject(r)
+	return func(tx store.Store, transaction *model.Transaction) error {
+		if key == "" {
+			return nil
+		}
+		response, err := json.Marshal(transaction)
+		if err != nil {
+			return err
+		}
+		now := time.Now()
+		return tx.CreateIdempotencyKey(&model.IdempotencyKey{
+			Subject:       subject,
+			Key:           key,
+			Fingerprint:   fingerprint,
+			TransactionID: transaction.ID,
+			StatusCode:    http.StatusOK,
+			Response:      string(response),
+			CreatedAt:     now,
+			ExpiresAt:     now.Add(IdempotencyRetention),
+		})
+	}
+}
+
+// PurgeExpiredIdempotencyKeys deletes keys past their retention window.
+func PurgeExpiredIdempotencyKeys(s store.Store) error {
+	return s.DeleteExpiredIdempotencyKeys(time.Now())
+}
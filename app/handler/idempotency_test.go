@@ -0,0 +1,165 @@
+package handler
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+	"wallet/app/model"
+	"wallet/app/money"
+	"wallet/app/store"
+	"wallet/testutils"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func postWithIdempotencyKey(t *testing.T, url, key, body string) *http.Response {
+	req, err := http.NewRequest("POST", url, strings.NewReader(body))
+	assert.NoError(t, err)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(idempotencyKeyHeader, key)
+	resp, err := http.DefaultClient.Do(req)
+	assert.NoError(t, err)
+	return resp
+}
+
+func fingerprintFor(body string) string {
+	return requestFingerprint(asAdmin(httptest.NewRequest("POST", "/transaction", nil)), []byte(body))
+}
+
+// newIdempotencyKey stores a key sent by the admin caller of
+// testutils.NewTestServer.
+func newIdempotencyKey(t *testing.T, s store.Store, key, body, response string, expiresAt time.Time) {
+	assert.NoError(t, s.CreateIdempotencyKey(&model.IdempotencyKey{
+		Subject:       "admin",
+		Key:           key,
+		Fingerprint:   fingerprintFor(body),
+		TransactionID: 7,
+		StatusCode:    http.StatusOK,
+		Response:      response,
+		CreatedAt:     expiresAt.Add(-IdempotencyRetention),
+		ExpiresAt:     expiresAt,
+	}))
+}
+
+func TestCreateTransactionStoresIdempotencyKey(t *testing.T) {
+	memoryStore := store.NewMemoryStore()
+	testService := testutils.NewTestServer().RegisterHandler("/transaction", memoryStore, CreateTransaction)
+	defer testService.Server.Close()
+	wallet := newWallet(t, memoryStore, "USD", 20000, 0)
+	body := fmt.Sprintf(`{"wallet_id":%d, "amount":"5.00", "type":"CREDIT"}`, wallet.ID)
+
+	resp := postWithIdempotencyKey(t, testService.Server.URL+"/transaction", "key-1", body)
+
+	transaction := model.Transaction{}
+	decodeBody(t, resp, &transaction)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	record, err := memoryStore.GetIdempotencyKey("admin", "key-1")
+	assert.NoError(t, err)
+	assert.Equal(t, fingerprintFor(body), record.Fingerprint)
+	assert.Equal(t, transaction.ID, record.TransactionID)
+	assert.Equal(t, http.StatusOK, record.StatusCode)
+}
+
+func TestCreateTransactionReplaysStoredResponse(t *testing.T) {
+	memoryStore := store.NewMemoryStore()
+	testService := testutils.NewTestServer().RegisterHandler("/transaction", memoryStore, CreateTransaction)
+	defer testService.Server.Close()
+	wallet := newWallet(t, memoryStore, "USD", 20000, 0)
+	body := fmt.Sprintf(`{"wallet_id":%d, "amount":"5.00", "type":"CREDIT"}`, wallet.ID)
+	stored := `{"ID":7,"amount":"5.00","type":"CREDIT"}`
+	newIdempotencyKey(t, memoryStore, "key-1", body, stored, time.Now().Add(time.Hour))
+
+	resp := postWithIdempotencyKey(t, testService.Server.URL+"/transaction", "key-1", body)
+
+	respBody, _ := ioutil.ReadAll(resp.Body)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, stored, string(respBody))
+	assert.Equal(t, "true", resp.Header.Get("Idempotent-Replayed"))
+	assert.Equal(t, money.New(20000, 2), getWallet(t, memoryStore, wallet.ID).Balance)
+}
+
+func TestCreateTransactionFailsWith409ForReusedKeyWithDifferentBody(t *testing.T) {
+	memoryStore := store.NewMemoryStore()
+	testService := testutils.NewTestServer().RegisterHandler("/transaction", memoryStore, CreateTransaction)
+	defer testService.Server.Close()
+	wallet := newWallet(t, memoryStore, "USD", 20000, 0)
+	newIdempotencyKey(t, memoryStore, "key-1", fmt.Sprintf(`{"wallet_id":%d, "amount":"5.00", "type":"CREDIT"}`, wallet.ID), "{}", time.Now().Add(time.Hour))
+
+	resp := postWithIdempotencyKey(t, testService.Server.URL+"/transaction", "key-1", fmt.Sprintf(`{"wallet_id":%d, "amount":"50.00", "type":"CREDIT"}`, wallet.ID))
+
+	assert.Equal(t, http.StatusConflict, resp.StatusCode)
+	assert.Equal(t, money.New(20000, 2), getWallet(t, memoryStore, wallet.ID).Balance)
+}
+
+func TestCreateTransactionIgnoresExpiredIdempotencyKey(t *testing.T) {
+	memoryStore := store.NewMemoryStore()
+	testService := testutils.NewTestServer().RegisterHandler("/transaction", memoryStore, CreateTransaction)
+	defer testService.Server.Close()
+	wallet := newWallet(t, memoryStore, "USD", 20000, 0)
+	body := fmt.Sprintf(`{"wallet_id":%d, "amount":"5.00", "type":"CREDIT"}`, wallet.ID)
+	newIdempotencyKey(t, memoryStore, "key-1", body, "{}", time.Now().Add(-time.Hour))
+
+	resp := postWithIdempotencyKey(t, testService.Server.URL+"/transaction", "key-1", body)
+
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, money.New(20500, 2), getWallet(t, memoryStore, wallet.ID).Balance)
+	record, err := memoryStore.GetIdempotencyKey("admin", "key-1")
+	assert.NoError(t, err)
+	assert.True(t, record.ExpiresAt.After(time.Now()))
+}
+
+func TestCreateTransactionScopesIdempotencyKeysToTheCaller(t *testing.T) {
+	memoryStore := store.NewMemoryStore()
+	owner := newOwner(t, memoryStore, "owner@example.com")
+	wallet := newOwnedWallet(t, memoryStore, owner.ID, 20000)
+	testService := testutils.NewTestServer().AsOwner(owner.ID).RegisterHandler("/transaction", memoryStore, CreateTransaction)
+	defer testService.Server.Close()
+	newIdempotencyKey(t, memoryStore, "key-1", `{"wallet_id":99, "amount":"1.00", "type":"CREDIT"}`, "{}", time.Now().Add(time.Hour))
+
+	resp := postWithIdempotencyKey(t, testService.Server.URL+"/transaction", "key-1", fmt.Sprintf(`{"wallet_id":%d, "amount":"5.00", "type":"CREDIT"}`, wallet.ID))
+
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, money.New(20500, 2), getWallet(t, memoryStore, wallet.ID).Balance)
+	_, err := memoryStore.GetIdempotencyKey(fmt.Sprint(owner.ID), "key-1")
+	assert.NoError(t, err)
+}
+
+func TestCreateTransactionDoesNotReplayToCallersWhoLostAccess(t *testing.T) {
+	memoryStore := store.NewMemoryStore()
+	owner := newOwner(t, memoryStore, "owner@example.com")
+	other := newOwner(t, memoryStore, "other@example.com")
+	wallet := newOwnedWallet(t, memoryStore, owner.ID, 20000)
+	testService := testutils.NewTestServer().AsOwner(other.ID).RegisterHandler("/transaction", memoryStore, CreateTransaction)
+	defer testService.Server.Close()
+	body := fmt.Sprintf(`{"wallet_id":%d, "amount":"5.00", "type":"CREDIT"}`, wallet.ID)
+	assert.NoError(t, memoryStore.CreateIdempotencyKey(&model.IdempotencyKey{
+		Subject:     fmt.Sprint(other.ID),
+		Key:         "key-1",
+		Fingerprint: fingerprintFor(body),
+		StatusCode:  http.StatusOK,
+		Response:    `{"ID":7}`,
+		ExpiresAt:   time.Now().Add(time.Hour),
+	}))
+
+	resp := postWithIdempotencyKey(t, testService.Server.URL+"/transaction", "key-1", body)
+
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+	assert.Empty(t, resp.Header.Get("Idempotent-Replayed"))
+}
+
+func TestPurgeExpiredIdempotencyKeys(t *testing.T) {
+	memoryStore := store.NewMemoryStore()
+	newIdempotencyKey(t, memoryStore, "expired", "{}", "{}", time.Now().Add(-time.Minute))
+	newIdempotencyKey(t, memoryStore, "live", "{}", "{}", time.Now().Add(time.Hour))
+
+	assert.NoError(t, PurgeExpiredIdempotencyKeys(memoryStore))
+
+	_, err := memoryStore.GetIdempotencyKey("admin", "expired")
+	assert.Equal(t, store.ErrNotFound, err)
+	_, err = memoryStore.GetIdempotencyKey("admin", "live")
+	assert.NoError(t, err)
+}
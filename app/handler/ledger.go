@@ -0,0 +1,174 @@
+package handler
+
+import (
+	"wallet/app/constant"
+	"wallet/app/logging"
+	"wallet/app/model"
+	"wallet/app/money"
+	"wallet/app/store"
+)
+
+// openWallet creates wallet together with its ledger account and the system
+// accounts of its currency. A non-zero opening Balance is posted against
+// external funding so that the balance can be rebuilt from the journal.
+func openWallet(s store.Store, wallet *model.Wallet) error {
+	if wallet.Currency == "" {
+		wallet.Currency = constant.DEFAULT_CURRENCY
+	}
+	if wallet.Status == "" {
+		wallet.Status = constant.WALLET_ACTIVE
+	}
+	return s.Atomic(func(tx store.Store) error {
+		for _, code := range []string{constant.EXTERNAL_FUNDING_ACCOUNT, constant.FX_CONVERSION_ACCOUNT} {
+			if err := tx.EnsureAccount(&model.Account{Code: code, Currency: wallet.Currency}); err != nil {
+				return err
+			}
+		}
+		if err := tx.CreateWallet(wallet); err != nil {
+			return err
+		}
+		account := model.Account{
+			Code:     model.WalletAccountCode(wallet.ID),
+			Currency: wallet.Currency,
+			WalletId: &wallet.ID,
+		}
+		if err := tx.EnsureAccount(&account); err != nil {
+			return err
+		}
+		if err := recordEvent(tx, constant.EVENT_WALLET_CREATED, wallet.ID, wallet); err != nil {
+			return err
+		}
+		if wallet.Balance.IsZero() {
+			return nil
+		}
+		return postEntry(tx, &model.JournalEntry{
+			Description: "Opening balance",
+			Postings:    []model.Posting{newPosting(account, wallet.Balance)},
+		})
+	})
+}
+
+// journalTransactions posts one journal entry moving the amounts of
+// transactions in or out of their wallets' accounts, and links each
+// transaction to it.
+func journalTransactions(tx store.Store, transactions ...*model.Transaction) error {
+	entry := model.JournalEntry{Description: transactions[0].Description}
+	for _, transaction := range transactions {
+		account, err := tx.GetAccount(model.WalletAccountCode(transaction.WalletId), transaction.Currency)
+		if err != nil {
+			return err
+		}
+		entry.Postings = append(entry.Postings, newPosting(account, signedAmount(*transaction)))
+	}
+	if err := postEntry(tx, &entry); err != nil {
+		return err
+	}
+	for _, transaction := range transactions {
+		transaction.JournalEntryId = &entry.ID
+	}
+	return nil
+}
+
+// postEntry balances entry against system accounts and saves it. An entry in
+// a single currency is balanced against external funding; one spanning
+// several currencies balances each of them against FX conversion.
+func postEntry(tx store.Store, entry *model.JournalEntry) error {
+	net := make(map[string]money.Money)
+	var currencies []string
+	for _, posting := range entry.Postings {
+		if _, ok := net[posting.Currency]; !ok {
+			currencies = append(currencies, posting.Currency)
+		}
+		net[posting.Currency] = net[posting.Currency].Add(posting.Signed())
+	}
+	code := constant.EXTERNAL_FUNDING_ACCOUNT
+	if len(currencies) > 1 {
+		code = constant.FX_CONVERSION_ACCOUNT
+	}
+	for _, currency := range currencies {
+		if net[currency].IsZero() {
+			continue
+		}
+		account, err := tx.GetAccount(code, currency)
+		if err != nil {
+			return err
+		}
+		entry.Postings = append(entry.Postings, newPosting(account, negate(net[currency])))
+	}
+	return tx.CreateJournalEntry(entry)
+}
+
+// newPosting credits account with a positive amount and debits it with a
+// negative one.
+func newPosting(account model.Account, amount money.Money) model.Posting {
+	posting := model.Posting{
+		AccountId: account.ID,
+		Direction: constant.CREDIT,
+		Amount:    amount,
+		Currency:  account.Currency,
+	}
+	if amount.IsNegative() {
+		posting.Direction = constant.DEBIT
+		posting.Amount = negate(amount)
+	}
+	return posting
+}
+
+func negate(amount money.Money) money.Money {
+	return money.New(0, amount.Exponent).Sub(amount)
+}
+
+// ledgerBalance is the balance of wallet according to the journal.
+func ledgerBalance(s store.Store, wallet model.Wallet) (money.Money, error) {
+	account, err := s.GetAccount(model.WalletAccountCode(wallet.ID), wallet.Currency)
+	if err != nil {
+		return money.Money{}, err
+	}
+	return s.AccountBalance(account)
+}
+
+// BalanceRebuild is a wallet whose Balance was reset to its ledger balance.
+type BalanceRebuild struct {
+	WalletId uint        `json:"wallet_id"`
+	From     money.Money `json:"from"`
+	To       money.Money `json:"to"`
+}
+
+// RebuildWalletBalances resets every wallet's Balance, which is a projection
+// of the journal, to the balance of its ledger account, as the
+// rebuild-balances subcommand. Each reset is audited and reported.
+func RebuildWalletBalances(s store.Store) ([]BalanceRebuild, error) {
+	rebuilds := []BalanceRebuild{}
+	wallets, err := s.ListWallets()
+	if err != nil {
+		return rebuilds, err
+	}
+	for _, wallet := range wallets {
+		err := s.Atomic(func(tx store.Store) error {
+			locked, err := tx.LockWallet(wallet.ID)
+			if err != nil {
+				return err
+			}
+			balance, err := ledgerBalance(tx, locked)
+			if err != nil {
+				return err
+			}
+			if balance.Cmp(locked.Balance) == 0 {
+				return nil
+			}
+			Logger.Warn("rebuilding wallet balance", logging.WalletID, locked.ID, "from", locked.Balance.String(), "to", balance.String())
+			before := locked
+			locked.Balance = balance
+			if err := tx.UpdateWalletBalances(&locked); err != nil {
+				return err
+			}
+			rebuilds = append(rebuilds, BalanceRebuild{WalletId: locked.ID, From: before.Balance, To: balance})
+			record := model.AuditRecord{Actor: "command:rebuild-balances", Action: constant.AUDIT_BALANCE_REBUILT, WalletId: &locked.ID}
+			return appendAudit(tx, record, before, locked)
+		})
+		if err != nil {
+			return rebuilds, err
+		}
+	}
+	return rebuilds, nil
+}
@@ -0,0 +1,73 @@
+package handler
+
+import (
+	"testing"
+	"wallet/app/constant"
+	"wallet/app/model"
+	"wallet/app/money"
+	"wallet/app/store"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func accountBalance(t *testing.T, s store.Store, code, currency string) money.Money {
+	account, err := s.GetAccount(code, currency)
+	assert.NoError(t, err)
+	balance, err := s.AccountBalance(account)
+	assert.NoError(t, err)
+	return balance
+}
+
+func TestTransactionsPostBalancedJournalEntries(t *testing.T) {
+	memoryStore := store.NewMemoryStore()
+	wallet := newWallet(t, memoryStore, "USD", 10000, 0)
+
+	credit, err := processTransaction(model.Transaction{WalletId: wallet.ID, Type: constant.CREDIT, Amount: money.New(2550, 2), Currency: "USD"}, memoryStore)
+	assert.NoError(t, err)
+	_, err = processTransaction(model.Transaction{WalletId: wallet.ID, Type: constant.DEBIT, Amount: money.New(4000, 2), Currency: "USD"}, memoryStore)
+	assert.NoError(t, err)
+
+	assert.NotNil(t, credit.JournalEntryId)
+	stored := getWallet(t, memoryStore, wallet.ID)
+	balance, err := ledgerBalance(memoryStore, stored)
+	assert.NoError(t, err)
+	assert.Equal(t, money.New(8550, 2), stored.Balance)
+	assert.Equal(t, stored.Balance, balance)
+	assert.Equal(t, money.New(-8550, 2), accountBalance(t, memoryStore, constant.EXTERNAL_FUNDING_ACCOUNT, "USD"))
+}
+
+func TestTransferBetweenCurrenciesBalancesAgainstFxConversion(t *testing.T) {
+	memoryStore := store.NewMemoryStore()
+	from := newWallet(t, memoryStore, "USD", 10000, 0)
+	to := newWallet(t, memoryStore, "JPY", 0, 0)
+	rate := money.New(150, 0)
+
+	_, err := processTransfer(memoryStore, model.Transfer{FromWalletId: from.ID, ToWalletId: to.ID, Amount: money.New(1000, 2), Currency: "USD", FxRate: &rate})
+
+	assert.NoError(t, err)
+	assert.Equal(t, money.New(-10000, 2), accountBalance(t, memoryStore, constant.EXTERNAL_FUNDING_ACCOUNT, "USD"))
+	assert.Equal(t, money.New(1000, 2), accountBalance(t, memoryStore, constant.FX_CONVERSION_ACCOUNT, "USD"))
+	assert.Equal(t, money.New(-1500, 0), accountBalance(t, memoryStore, constant.FX_CONVERSION_ACCOUNT, "JPY"))
+	toBalance, err := ledgerBalance(memoryStore, getWallet(t, memoryStore, to.ID))
+	assert.NoError(t, err)
+	assert.Equal(t, money.New(1500, 0), toBalance)
+}
+
+func TestRebuildWalletBalancesRestoresProjection(t *testing.T) {
+	memoryStore := store.NewMemoryStore()
+	wallet := newWallet(t, memoryStore, "USD", 10000, 0)
+	drifted := getWallet(t, memoryStore, wallet.ID)
+	drifted.Balance = money.New(1, 2)
+	assert.NoError(t, memoryStore.UpdateWalletBalances(&drifted))
+
+	rebuilds, err := RebuildWalletBalances(memoryStore)
+
+	assert.NoError(t, err)
+	assert.Equal(t, []BalanceRebuild{{WalletId: wallet.ID, From: money.New(1, 2), To: money.New(10000, 2)}}, rebuilds)
+	assert.Equal(t, money.New(10000, 2), getWallet(t, memoryStore, wallet.ID).Balance)
+	records, err := memoryStore.ListAuditRecords(store.AuditFilter{Action: constant.AUDIT_BALANCE_REBUILT})
+	assert.NoError(t, err)
+	if assert.Len(t, records, 1) {
+		assert.Equal(t, "command:rebuild-balances", records[0].Actor)
+	}
+}
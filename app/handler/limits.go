@@ -0,0 +1,250 @@
+package handler
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+	"wallet/app/apperror"
+	"wallet/app/constant"
+	"wallet/app/model"
+	"wallet/app/money"
+	"wallet/app/store"
+
+	"github.com/gorilla/mux"
+)
+
+// effectiveLimits are the limits of the wallet's currency profile with the
+// wallet's own overrides applied.
+func effectiveLimits(s store.Store, wallet model.Wallet) (model.Limits, error) {
+	limits := model.Limits{}
+	profile, err := s.GetLimitProfile(wallet.Currency)
+	if err != nil && err != store.ErrNotFound {
+		return limits, err
+	}
+	if err == nil {
+		limits = profile.Limits
+	}
+	override, err := s.GetWalletLimit(wallet.ID)
+	if err != nil && err != store.ErrNotFound {
+		return limits, err
+	}
+	if err == nil {
+		limits = limits.Override(override.Limits)
+	}
+	return limits, nil
+}
+
+// checkLimits checks transaction against the limits of wallet, whose
+// Balance is still the balance before the transaction. Reversals undo
+// earlier transactions and fees follow the transaction they are charged on,
+// so neither is limited.
+func checkLimits(tx store.Store, wallet model.Wallet, transaction model.Transaction) error {
+	if transaction.ReversedTransactionId != nil || transaction.FeeOfTransactionId != nil {
+		return nil
+	}
+	limits, err := effectiveLimits(tx, wallet)
+	if err != nil {
+		return err
+	}
+	if err := checkLimit(constant.LIMIT_MAX_TRANSACTION_AMOUNT, limits.MaxTransactionAmount, transaction.Amount); err != nil {
+		return err
+	}
+	daily, monthly := limits.DailyDebit, limits.MonthlyDebit
+	dailyName, monthlyName := constant.LIMIT_DAILY_DEBIT, constant.LIMIT_MONTHLY_DEBIT
+	if transaction.Type == constant.CREDIT {
+		if err := checkLimit(constant.LIMIT_MAX_BALANCE, limits.MaxBalance, wallet.Balance.Add(transaction.Amount)); err != nil {
+			return err
+		}
+		daily, monthly = limits.DailyCredit, limits.MonthlyCredit
+		dailyName, monthlyName = constant.LIMIT_DAILY_CREDIT, constant.LIMIT_MONTHLY_CREDIT
+	}
+	now := time.Now().UTC()
+	periods := []struct {
+		name  string
+		limit *money.Money
+		since time.Time
+	}{
+		{dailyName, daily, time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)},
+		{monthlyName, monthly, time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC)},
+	}
+	for _, period := range periods {
+		if period.limit == nil {
+			continue
+		}
+		total, err := tx.SumTransactions(wallet, transaction.Type, period.since)
+		if err != nil {
+			return err
+		}
+		if err := checkLimit(period.name, period.limit, total.Add(transaction.Amount)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func checkLimit(name string, limit *money.Money, attempted money.Money) error {
+	if limit != nil && attempted.Cmp(*limit) > 0 {
+		return limitExceeded(name, *limit, attempted)
+	}
+	return nil
+}
+
+// limitExceeded rejects a transaction that would exceed one of the wallet's
+// limits. Attempted is the amount, balance or total the transaction would
+// have reached.
+func limitExceeded(name string, max, attempted money.Money) error {
+	return apperror.ErrLimitExceeded.
+		WithMessage(fmt.Sprintf("transaction exceeds the %s limit of %s", name, max)).
+		WithDetails(map[string]interface{}{"limit": name, "max": max, "attempted": attempted})
+}
+
+// limitFields are the request fields of model.Limits.Fields, in order.
+var limitFields = []string{"max_transaction_amount", "max_balance", "daily_debit", "daily_credit", "monthly_debit", "monthly_credit"}
+
+// limitsInCurrency puts every limit set in currency.
+func limitsInCurrency(limits model.Limits, currency string) (model.Limits, error) {
+	for i, limit := range limits.Fields() {
+		if *limit == nil {
+			continue
+		}
+		if (*limit).IsNegative() {
+			return limits, fmt.Errorf("limits must not be negative")
+		}
+		scaled, err := amountInCurrency(limitFields[i], **limit, currency)
+		if err != nil {
+			return limits, err
+		}
+		*limit = &scaled
+	}
+	return limits, nil
+}
+
+// walletLimitsResponse shows a wallet's own overrides and the limits in
+// force once the currency profile is applied.
+type walletLimitsResponse struct {
+	WalletId  uint         `json:"wallet_id"`
+	Currency  string       `json:"currency"`
+	Overrides model.Limits `json:"overrides"`
+	Effective model.Limits `json:"effective"`
+}
+
+func GetWalletLimits(s store.Store, w http.ResponseWriter, r *http.Request) {
+	walletId, err := strconv.ParseInt(mux.Vars(r)["wallet_id"], 10, 64)
+	if err != nil {
+		respondError(w, r, apperror.BadRequest("invalid wallet id"))
+		return
+	}
+	wallet, ok := authorizedWallet(s, w, r, uint(walletId))
+	if !ok {
+		return
+	}
+	respondWalletLimits(s, w, r, wallet)
+}
+
+// SetWalletLimits replaces the wallet's overrides. Limits left out fall back
+// to the currency profile.
+func SetWalletLimits(s store.Store, w http.ResponseWriter, r *http.Request) {
+	if !requireAdmin(w, r) {
+		return
+	}
+	walletId, err := strconv.ParseInt(mux.Vars(r)["wallet_id"], 10, 64)
+	if err != nil {
+		respondError(w, r, apperror.BadRequest("invalid wallet id"))
+		return
+	}
+	limits := model.Limits{}
+	if err := json.NewDecoder(r.Body).Decode(&limits); err != nil {
+		respondError(w, r, apperror.Invalid(err))
+		return
+	}
+	wallet, err := s.GetWallet(uint(walletId))
+	if err != nil {
+		respondError(w, r, apperror.Internal("failed while fetching wallet information", notFoundAs(err, apperror.ErrWalletNotFound)))
+		return
+	}
+	if limits, err = limitsInCurrency(limits, wallet.Currency); err != nil {
+		respondError(w, r, apperror.Invalid(err))
+		return
+	}
+	limit, err := s.GetWalletLimit(wallet.ID)
+	if err != nil && err != store.ErrNotFound {
+		respondError(w, r, apperror.Internal("failed while fetching wallet limits", err))
+		return
+	}
+	before := limit
+	limit.WalletId = wallet.ID
+	limit.Currency = wallet.Currency
+	limit.Limits = limits
+	err = s.Atomic(func(tx store.Store) error {
+		if err := tx.SaveWalletLimit(&limit); err != nil {
+			return err
+		}
+		return recordAudit(tx, r, constant.AUDIT_WALLET_LIMITS_SET, &wallet.ID, before.Limits, limit.Limits)
+	})
+	if err != nil {
+		respondError(w, r, apperror.Internal("failed to save wallet limits", err))
+		return
+	}
+	respondWalletLimits(s, w, r, wallet)
+}
+
+func respondWalletLimits(s store.Store, w http.ResponseWriter, r *http.Request, wallet model.Wallet) {
+	response := walletLimitsResponse{WalletId: wallet.ID, Currency: wallet.Currency}
+	override, err := s.GetWalletLimit(wallet.ID)
+	if err != nil && err != store.ErrNotFound {
+		respondError(w, r, apperror.Internal("failed while fetching wallet limits", err))
+		return
+	}
+	response.Overrides = override.Limits
+	if response.Effective, err = effectiveLimits(s, wallet); err != nil {
+		respondError(w, r, apperror.Internal("failed while fetching wallet limits", err))
+		return
+	}
+	respondSuccess(w, response)
+}
+
+// SetDefaultLimits replaces the default limit profile of a currency.
+func SetDefaultLimits(s store.Store, w http.ResponseWriter, r *http.Request) {
+	if !requireAdmin(w, r) {
+		return
+	}
+	currency := mux.Vars(r)["currency"]
+	if _, err := money.CurrencyExponent(currency); err != nil {
+		respondError(w, r, apperror.ErrUnknownCurrency.WithMessage("unsupported currency "+currency))
+		return
+	}
+	limits := model.Limits{}
+	if err := json.NewDecoder(r.Body).Decode(&limits); err != nil {
+		respondError(w, r, apperror.Invalid(err))
+		return
+	}
+	limits, err := limitsInCurrency(limits, currency)
+	if err != nil {
+		respondError(w, r, apperror.Invalid(err))
+		return
+	}
+	profile, err := s.GetLimitProfile(currency)
+	if err != nil && err != store.ErrNotFound {
+		respondError(w, r, apperror.Internal("failed while fetching limit profile", err))
+		return
+	}
+	var before interface{}
+	if profile.ID != 0 {
+		before = profile
+	}
+	profile.Currency = currency
+	profile.Limits = limits
+	err = s.Atomic(func(tx store.Store) error {
+		if err := tx.SaveLimitProfile(&profile); err != nil {
+			return err
+		}
+		return recordAudit(tx, r, constant.AUDIT_DEFAULT_LIMITS_SET, nil, before, profile)
+	})
+	if err != nil {
+		respondError(w, r, apperror.Internal("failed to save limit profile", err))
+		return
+	}
+	respondSuccess(w, profile)
+}
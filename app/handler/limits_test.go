@@ -0,0 +1,100 @@
+package handler
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+	"wallet/app/constant"
+	"wallet/app/model"
+	"wallet/app/money"
+	"wallet/app/store"
+	"wallet/testutils"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func usd(units int64) *money.Money {
+	m := money.New(units, 2)
+	return &m
+}
+
+func TestCreateTransactionFailsWith422AboveMaxTransactionAmount(t *testing.T) {
+	memoryStore := store.NewMemoryStore()
+	testService := testutils.NewTestServer().RegisterHandler("/transaction", memoryStore, CreateTransaction)
+	defer testService.Server.Close()
+	wallet := newWallet(t, memoryStore, "USD", 0, 0)
+	assert.NoError(t, memoryStore.SaveLimitProfile(&model.LimitProfile{Currency: "USD", Limits: model.Limits{MaxTransactionAmount: usd(10000)}}))
+
+	resp, err := http.Post(testService.Server.URL+"/transaction", "application/json", strings.NewReader(fmt.Sprintf(`{"wallet_id":%d, "type":"CREDIT", "amount":"100.01"}`, wallet.ID)))
+
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusUnprocessableEntity, resp.StatusCode)
+	body := errorResponse{}
+	decodeBody(t, resp, &body)
+	assert.Equal(t, "LIMIT_EXCEEDED", body.Code)
+	assert.Equal(t, constant.LIMIT_MAX_TRANSACTION_AMOUNT, body.Details["limit"])
+	assert.Equal(t, "100.00", body.Details["max"])
+	assert.Equal(t, "100.01", body.Details["attempted"])
+	assert.Equal(t, money.New(0, 2), getWallet(t, memoryStore, wallet.ID).Balance)
+}
+
+func TestDailyDebitLimitCountsEarlierDebits(t *testing.T) {
+	memoryStore := store.NewMemoryStore()
+	wallet := newWallet(t, memoryStore, "USD", 10000, 0)
+	assert.NoError(t, memoryStore.SaveLimitProfile(&model.LimitProfile{Currency: "USD", Limits: model.Limits{DailyDebit: usd(5000)}}))
+	debit := model.Transaction{WalletId: wallet.ID, Type: constant.DEBIT, Amount: money.New(3000, 2)}
+
+	_, err := processTransaction(debit, memoryStore)
+	assert.NoError(t, err)
+	_, err = processTransaction(debit, memoryStore)
+
+	assert.Equal(t, limitExceeded(constant.LIMIT_DAILY_DEBIT, money.New(5000, 2), money.New(6000, 2)), err)
+}
+
+func TestWalletLimitOverridesProfile(t *testing.T) {
+	memoryStore := store.NewMemoryStore()
+	wallet := newWallet(t, memoryStore, "USD", 0, 0)
+	assert.NoError(t, memoryStore.SaveLimitProfile(&model.LimitProfile{Currency: "USD", Limits: model.Limits{MaxBalance: usd(1000), DailyCredit: usd(100000)}}))
+	assert.NoError(t, memoryStore.SaveWalletLimit(&model.WalletLimit{WalletId: wallet.ID, Currency: "USD", Limits: model.Limits{MaxBalance: usd(50000)}}))
+
+	_, err := processTransaction(model.Transaction{WalletId: wallet.ID, Type: constant.CREDIT, Amount: money.New(20000, 2)}, memoryStore)
+	assert.NoError(t, err)
+	_, err = processTransaction(model.Transaction{WalletId: wallet.ID, Type: constant.CREDIT, Amount: money.New(40000, 2)}, memoryStore)
+
+	assert.Equal(t, limitExceeded(constant.LIMIT_MAX_BALANCE, money.New(50000, 2), money.New(60000, 2)), err)
+}
+
+func TestReversalsAreNotLimited(t *testing.T) {
+	memoryStore := store.NewMemoryStore()
+	wallet := newWallet(t, memoryStore, "USD", 10000, 0)
+	debit, err := processTransaction(model.Transaction{WalletId: wallet.ID, Type: constant.DEBIT, Amount: money.New(5000, 2)}, memoryStore)
+	assert.NoError(t, err)
+	assert.NoError(t, memoryStore.SaveLimitProfile(&model.LimitProfile{Currency: "USD", Limits: model.Limits{MaxTransactionAmount: usd(100), MaxBalance: usd(100)}}))
+
+	_, err = processReversal(memoryStore, debit.ID, nil, false)
+
+	assert.NoError(t, err)
+	assert.Equal(t, money.New(10000, 2), getWallet(t, memoryStore, wallet.ID).Balance)
+}
+
+func TestSetWalletLimitsReturnsEffectiveLimits(t *testing.T) {
+	memoryStore := store.NewMemoryStore()
+	testService := testutils.NewTestServer().RegisterHandler("/wallet/{wallet_id}/limits", memoryStore, SetWalletLimits)
+	defer testService.Server.Close()
+	wallet := newWallet(t, memoryStore, "JPY", 0, 0)
+	dailyDebit := money.New(1000, 0)
+	assert.NoError(t, memoryStore.SaveLimitProfile(&model.LimitProfile{Currency: "JPY", Limits: model.Limits{DailyDebit: &dailyDebit}}))
+	req, _ := http.NewRequest(http.MethodPut, fmt.Sprintf("%s/wallet/%d/limits", testService.Server.URL, wallet.ID), strings.NewReader(`{"max_balance":"5000"}`))
+
+	resp, err := http.DefaultClient.Do(req)
+
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	response := walletLimitsResponse{}
+	decodeBody(t, resp, &response)
+	assert.Nil(t, response.Overrides.DailyDebit)
+	assert.Equal(t, money.New(5000, 0), *response.Overrides.MaxBalance)
+	assert.Equal(t, money.New(5000, 0), *response.Effective.MaxBalance)
+	assert.Equal(t, money.New(1000, 0), *response.Effective.DailyDebit)
+}
@@ -0,0 +1,139 @@
+package handler
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"runtime/debug"
+	"time"
+	"wallet/app/apperror"
+	"wallet/app/logging"
+
+	"github.com/gorilla/mux"
+)
+
+// Logger is what handlers log with, outside of requests and for the requests
+// Log has not seen.
+var Logger = slog.Default()
+
+const maxRequestIdLength = 64
+
+// RequestID gives every request an X-Request-ID, keeping the one the caller
+// sent when it is usable, and echoes it in the response. Handlers read it
+// from the request header, so audit records and error bodies carry it too.
+func RequestID(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		requestId := r.Header.Get(requestIdHeader)
+		if !isValidRequestId(requestId) {
+			requestId = newRequestId()
+			r.Header.Set(requestIdHeader, requestId)
+		}
+		w.Header().Set(requestIdHeader, requestId)
+		next(w, r)
+	}
+}
+
+func isValidRequestId(requestId string) bool {
+	if requestId == "" || len(requestId) > maxRequestIdLength {
+		return false
+	}
+	for _, c := range requestId {
+		if c <= ' ' || c > '~' {
+			return false
+		}
+	}
+	return true
+}
+
+func newRequestId() string {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return fmt.Sprint(time.Now().UnixNano())
+	}
+	return hex.EncodeToString(id)
+}
+
+// Log gives the handlers of a request a logger carrying its request ID and
+// writes an access record once the request is served. It must run inside
+// RequestID.
+func Log(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		logger := Logger.With(logging.RequestID, r.Header.Get(requestIdHeader))
+		recorder := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next(recorder, r.WithContext(logging.NewContext(r.Context(), logger)))
+		logger.Info("request served",
+			"method", r.Method,
+			"path", r.URL.Path,
+			"status", recorder.status,
+			"duration_ms", time.Since(start).Milliseconds())
+	}
+}
+
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (s *statusRecorder) WriteHeader(status int) {
+	s.status = status
+	s.ResponseWriter.WriteHeader(status)
+}
+
+// loggerFor is the logger for serving r, carrying the request ID and the
+// wallet or transaction named in the path.
+func loggerFor(r *http.Request) *slog.Logger {
+	logger, ok := logging.FromContext(r.Context())
+	if !ok {
+		logger = Logger
+	}
+	vars := mux.Vars(r)
+	if walletId, ok := vars["wallet_id"]; ok {
+		logger = logger.With(logging.WalletID, walletId)
+	}
+	if tranId, ok := vars["tran_id"]; ok {
+		logger = logger.With(logging.TransactionID, tranId)
+	}
+	return logger
+}
+
+// Recover answers a request whose handler panicked with an INTERNAL error and
+// logs the panic with its stack trace, instead of dropping the connection.
+func Recover(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		defer func() {
+			recovered := recover()
+			if recovered == nil {
+				return
+			}
+			if recovered == http.ErrAbortHandler {
+				panic(recovered)
+			}
+			loggerFor(r).Error("panic serving request", "panic", fmt.Sprint(recovered), "stack", string(debug.Stack()))
+			respondError(w, r, apperror.ErrInternal)
+		}()
+		next(w, r)
+	}
+}
+
+// Timeout bounds a request to d. Handlers pass the request's context to the
+// Store, so DB transactions still open at the deadline are rolled back.
+func Timeout(d time.Duration, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := context.WithTimeout(r.Context(), d)
+		defer cancel()
+		next(w, r.WithContext(ctx))
+	}
+}
+
+// LimitBody refuses to read more than max bytes of a request body; handlers
+// answer REQUEST_TOO_LARGE when they hit the limit.
+func LimitBody(max int64, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		r.Body = http.MaxBytesReader(w, r.Body, max)
+		next(w, r)
+	}
+}
@@ -0,0 +1,128 @@
+package handler
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+	"wallet/app/constant"
+	"wallet/app/logging"
+	"wallet/app/model"
+	"wallet/app/money"
+	"wallet/app/store"
+
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRequestIDIsGeneratedAndEchoed(t *testing.T) {
+	var seen string
+	handler := RequestID(func(w http.ResponseWriter, r *http.Request) {
+		seen = r.Header.Get(requestIdHeader)
+	})
+	writer := httptest.NewRecorder()
+
+	handler(writer, httptest.NewRequest(http.MethodGet, "/", nil))
+
+	assert.Len(t, seen, 32)
+	assert.Equal(t, seen, writer.Header().Get(requestIdHeader))
+}
+
+func TestRequestIDKeepsUsableCallerIds(t *testing.T) {
+	var seen string
+	handler := RequestID(func(w http.ResponseWriter, r *http.Request) {
+		seen = r.Header.Get(requestIdHeader)
+	})
+	for requestId, kept := range map[string]bool{
+		"req-1":                 true,
+		strings.Repeat("a", 65): false,
+		"two words":             false,
+	} {
+		request := httptest.NewRequest(http.MethodGet, "/", nil)
+		request.Header.Set(requestIdHeader, requestId)
+
+		handler(httptest.NewRecorder(), request)
+
+		assert.Equal(t, kept, seen == requestId, requestId)
+	}
+}
+
+func TestRecoverAnswersPanicsWithInternalError(t *testing.T) {
+	handler := RequestID(Recover(func(w http.ResponseWriter, r *http.Request) {
+		var transaction *model.Transaction
+		respondSuccess(w, *transaction)
+	}))
+	writer := httptest.NewRecorder()
+
+	handler(writer, httptest.NewRequest(http.MethodPost, "/transaction", nil))
+
+	assert.Equal(t, http.StatusInternalServerError, writer.Code)
+	body := errorResponse{}
+	decodeBody(t, writer.Result(), &body)
+	assert.Equal(t, "INTERNAL", body.Code)
+	assert.Equal(t, writer.Header().Get(requestIdHeader), body.RequestId)
+}
+
+func TestTimeoutRollsBackStoreTransactions(t *testing.T) {
+	memoryStore := store.NewMemoryStore()
+	wallet := newWallet(t, memoryStore, "USD", 0, 0)
+	handler := Timeout(time.Millisecond, func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(5 * time.Millisecond)
+		_, err := processTransaction(model.Transaction{WalletId: wallet.ID, Type: constant.CREDIT, Amount: money.New(100, 2)}, memoryStore.WithContext(r.Context()))
+		respondError(w, r, err)
+	})
+	writer := httptest.NewRecorder()
+
+	handler(writer, httptest.NewRequest(http.MethodPost, "/transaction", nil))
+
+	assert.Equal(t, http.StatusServiceUnavailable, writer.Code)
+	assert.True(t, getWallet(t, memoryStore, wallet.ID).Balance.IsZero())
+}
+
+func TestLimitBodyRejectsLargeBodies(t *testing.T) {
+	memoryStore := store.NewMemoryStore()
+	handler := LimitBody(16, func(w http.ResponseWriter, r *http.Request) {
+		CreateTransaction(memoryStore, w, asAdmin(r))
+	})
+	writer := httptest.NewRecorder()
+
+	handler(writer, httptest.NewRequest(http.MethodPost, "/transaction", strings.NewReader(`{"wallet_id":1, "amount":"1.00", "type":"CREDIT"}`)))
+
+	assert.Equal(t, http.StatusRequestEntityTooLarge, writer.Code)
+}
+
+func TestLogRecordsRequestsWithTheirIds(t *testing.T) {
+	var buf bytes.Buffer
+	logger := Logger
+	defer func() { Logger = logger }()
+	Logger = logging.New(&buf, "info", "json")
+	memoryStore := store.NewMemoryStore()
+	router := mux.NewRouter()
+	router.HandleFunc("/wallet/{wallet_id}", func(w http.ResponseWriter, r *http.Request) {
+		GetWallet(memoryStore, w, asAdmin(r))
+	})
+	request := httptest.NewRequest(http.MethodGet, "/wallet/42", nil)
+	request.Header.Set(requestIdHeader, "req-1")
+
+	RequestID(Log(router.ServeHTTP))(httptest.NewRecorder(), request)
+
+	records := []map[string]interface{}{}
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		record := map[string]interface{}{}
+		assert.NoError(t, json.Unmarshal([]byte(line), &record))
+		records = append(records, record)
+	}
+	if assert.Len(t, records, 2) {
+		assert.Equal(t, "request rejected", records[0]["msg"])
+		assert.Equal(t, "WALLET_NOT_FOUND", records[0]["code"])
+		assert.Equal(t, "42", records[0][logging.WalletID])
+		assert.Equal(t, "request served", records[1]["msg"])
+		assert.Equal(t, float64(http.StatusNotFound), records[1]["status"])
+		for _, record := range records {
+			assert.Equal(t, "req-1", record[logging.RequestID])
+		}
+	}
+}
@@ -0,0 +1,69 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"wallet/app/apperror"
+	"wallet/app/constant"
+	"wallet/app/model"
+	"wallet/app/store"
+
+	"github.com/gorilla/mux"
+)
+
+type createOwnerRequest struct {
+	Name  string `json:"name"`
+	Email string `json:"email"`
+}
+
+// CreateOwner registers a customer. Only admins may create owners; the
+// owner's ID is then the subject of the tokens issued to them.
+func CreateOwner(s store.Store, w http.ResponseWriter, r *http.Request) {
+	if !requireAdmin(w, r) {
+		return
+	}
+	request := createOwnerRequest{}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		respondError(w, r, apperror.Invalid(err))
+		return
+	}
+	if request.Name == "" || request.Email == "" {
+		respondError(w, r, apperror.BadRequest("name and email are required"))
+		return
+	}
+	owner := model.Owner{Name: request.Name, Email: request.Email}
+	err := s.Atomic(func(tx store.Store) error {
+		if err := tx.CreateOwner(&owner); err != nil {
+			return err
+		}
+		return recordAudit(tx, r, constant.AUDIT_OWNER_CREATED, nil, nil, owner)
+	})
+	if err != nil {
+		if err == store.ErrDuplicateKey {
+			err = apperror.ErrOwnerExists
+		}
+		respondError(w, r, apperror.Internal("failed to create owner", err))
+		return
+	}
+	respondSuccess(w, owner)
+}
+
+// GetOwner returns an owner to the owner themselves or to an admin.
+func GetOwner(s store.Store, w http.ResponseWriter, r *http.Request) {
+	ownerId, err := strconv.ParseInt(mux.Vars(r)["owner_id"], 10, 64)
+	if err != nil {
+		respondError(w, r, apperror.BadRequest("invalid owner id"))
+		return
+	}
+	if callerId, ok := callerOwnerId(r); !isAdmin(r) && (!ok || callerId != uint(ownerId)) {
+		respondError(w, r, apperror.ErrForbidden.WithMessage("access to owner denied"))
+		return
+	}
+	owner, err := s.GetOwner(uint(ownerId))
+	if err != nil {
+		respondError(w, r, apperror.Internal("failed while fetching owner information", notFoundAs(err, apperror.ErrOwnerNotFound)))
+		return
+	}
+	respondSuccess(w, owner)
+}
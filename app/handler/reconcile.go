@@ -0,0 +1,168 @@
+package handler
+
+import (
+	"net/http"
+	"strconv"
+	"wallet/app/apperror"
+	"wallet/app/constant"
+	"wallet/app/logging"
+	"wallet/app/model"
+	"wallet/app/money"
+	"wallet/app/store"
+)
+
+// Discrepancy is a wallet whose balance does not match its transaction
+// history, or a transaction whose closing balance does not follow from the
+// one before it.
+type Discrepancy struct {
+	WalletId      uint        `json:"wallet_id"`
+	TransactionId *uint       `json:"transaction_id,omitempty"`
+	Kind          string      `json:"kind"`
+	Expected      money.Money `json:"expected"`
+	Actual        money.Money `json:"actual"`
+	// AdjustmentId is the adjustment transaction written in repair mode.
+	AdjustmentId *uint `json:"adjustment_id,omitempty"`
+}
+
+type ReconciliationReport struct {
+	WalletsChecked int           `json:"wallets_checked"`
+	Repair         bool          `json:"repair"`
+	Discrepancies  []Discrepancy `json:"discrepancies"`
+}
+
+// ReconcileWallets reports discrepancies between wallet balances and their
+// transaction histories. With ?repair=true balance mismatches are also
+// repaired, see Reconcile.
+func ReconcileWallets(s store.Store, w http.ResponseWriter, r *http.Request) {
+	if !requireAdmin(w, r) {
+		return
+	}
+	repair := false
+	if value := r.URL.Query().Get("repair"); value != "" {
+		var err error
+		if repair, err = strconv.ParseBool(value); err != nil {
+			respondError(w, r, apperror.BadRequest("invalid repair flag"))
+			return
+		}
+	}
+	report, err := reconcile(s, repair, auditTransaction(r, constant.AUDIT_TRANSACTION_ADJUSTED))
+	if err != nil {
+		respondError(w, r, apperror.Internal("failed to reconcile wallets", err))
+		return
+	}
+	respondSuccess(w, report)
+}
+
+// Reconcile runs a reconciliation from the reconcile subcommand, see
+// reconcile.
+func Reconcile(s store.Store, repair bool) (ReconciliationReport, error) {
+	return reconcile(s, repair, auditCommand("reconcile", constant.AUDIT_TRANSACTION_ADJUSTED))
+}
+
+// reconcile recomputes each wallet's balance as its credits minus its debits
+// and checks that every closing balance chains from the previous one. In
+// repair mode a wallet whose balance differs from its history gets an
+// adjustment transaction for the difference, recorded by audit and in the
+// event outbox like any other transaction. Balance agrees with the journal,
+// so it is taken as correct: the adjustment only completes the history and
+// does not move the balance. Broken closing balances are reported but left
+// as they are.
+func reconcile(s store.Store, repair bool, audit transactionHook) (ReconciliationReport, error) {
+	report := ReconciliationReport{Repair: repair, Discrepancies: []Discrepancy{}}
+	wallets, err := s.ListWallets()
+	if err != nil {
+		return report, err
+	}
+	for _, wallet := range wallets {
+		var discrepancies []Discrepancy
+		err := s.Atomic(func(tx store.Store) error {
+			locked, err := tx.LockWallet(wallet.ID)
+			if err != nil {
+				return err
+			}
+			discrepancies, err = reconcileWallet(tx, locked, repair, audit)
+			return err
+		})
+		if err != nil {
+			return report, err
+		}
+		report.WalletsChecked++
+		report.Discrepancies = append(report.Discrepancies, discrepancies...)
+	}
+	return report, nil
+}
+
+func reconcileWallet(tx store.Store, wallet model.Wallet, repair bool, audit transactionHook) ([]Discrepancy, error) {
+	transactions, err := tx.ListTransactions(wallet.ID, store.TransactionFilter{})
+	if err != nil {
+		return nil, err
+	}
+	var discrepancies []Discrepancy
+	exponent := wallet.Balance.Exponent
+	balance, previous := money.New(0, exponent), money.New(0, exponent)
+	// transactions are listed newest first
+	for i := len(transactions) - 1; i >= 0; i-- {
+		transaction := transactions[i]
+		expected := previous.Add(signedAmount(transaction))
+		if expected.Cmp(transaction.ClosingBalance) != 0 {
+			discrepancies = append(discrepancies, Discrepancy{
+				WalletId:      wallet.ID,
+				TransactionId: &transaction.ID,
+				Kind:          constant.CLOSING_BALANCE_BREAK,
+				Expected:      expected,
+				Actual:        transaction.ClosingBalance,
+			})
+		}
+		balance = balance.Add(signedAmount(transaction))
+		previous = transaction.ClosingBalance
+	}
+	if balance.Cmp(wallet.Balance) == 0 {
+		return discrepancies, nil
+	}
+	discrepancy := Discrepancy{
+		WalletId: wallet.ID,
+		Kind:     constant.BALANCE_MISMATCH,
+		Expected: balance,
+		Actual:   wallet.Balance,
+	}
+	if repair {
+		adjustment := model.Transaction{
+			Type:           constant.CREDIT,
+			Amount:         wallet.Balance.Sub(balance),
+			Currency:       wallet.Currency,
+			Description:    "Reconciliation adjustment",
+			WalletId:       wallet.ID,
+			ClosingBalance: wallet.Balance,
+		}
+		if adjustment.Amount.IsNegative() {
+			adjustment.Type = constant.DEBIT
+			adjustment.Amount = negate(adjustment.Amount)
+		}
+		if err := tx.CreateTransaction(&adjustment); err != nil {
+			return nil, err
+		}
+		if err := chainTransaction(tx, &wallet, &adjustment); err != nil {
+			return nil, err
+		}
+		if err := tx.UpdateWalletChainHash(&wallet); err != nil {
+			return nil, err
+		}
+		if err := recordTransactionEvent(tx, adjustment); err != nil {
+			return nil, err
+		}
+		if err := audit(tx, &adjustment); err != nil {
+			return nil, err
+		}
+		Logger.Warn("adjusted wallet history", logging.WalletID, wallet.ID, logging.TransactionID, adjustment.ID, "type", adjustment.Type, "amount", adjustment.Amount.String())
+		discrepancy.AdjustmentId = &adjustment.ID
+	}
+	return append(discrepancies, discrepancy), nil
+}
+
+// signedAmount is the amount by which transaction moves its wallet's balance.
+func signedAmount(transaction model.Transaction) money.Money {
+	if transaction.Type == constant.DEBIT {
+		return negate(transaction.Amount)
+	}
+	return transaction.Amount
+}
@@ -0,0 +1,110 @@
+package handler
+
+import (
+	"net/http"
+	"testing"
+	"wallet/app/constant"
+	"wallet/app/model"
+	"wallet/app/money"
+	"wallet/app/store"
+	"wallet/testutils"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReconcileReportsNothingForConsistentWallets(t *testing.T) {
+	memoryStore := store.NewMemoryStore()
+	wallet := newWallet(t, memoryStore, "USD", 0, 0)
+	_, err := processTransaction(model.Transaction{WalletId: wallet.ID, Type: constant.CREDIT, Amount: money.New(5000, 2), Currency: "USD"}, memoryStore)
+	assert.NoError(t, err)
+	_, err = processTransaction(model.Transaction{WalletId: wallet.ID, Type: constant.DEBIT, Amount: money.New(1250, 2), Currency: "USD"}, memoryStore)
+	assert.NoError(t, err)
+
+	report, err := Reconcile(memoryStore, false)
+
+	assert.NoError(t, err)
+	assert.Equal(t, 1, report.WalletsChecked)
+	assert.Empty(t, report.Discrepancies)
+}
+
+func TestReconcileReportsBrokenClosingBalance(t *testing.T) {
+	memoryStore := store.NewMemoryStore()
+	wallet := newWallet(t, memoryStore, "USD", 3000, 0)
+	newTransaction(t, memoryStore, model.Transaction{WalletId: wallet.ID, Type: constant.CREDIT, Amount: money.New(1000, 2), ClosingBalance: money.New(1000, 2)})
+	broken := newTransaction(t, memoryStore, model.Transaction{WalletId: wallet.ID, Type: constant.CREDIT, Amount: money.New(2000, 2), ClosingBalance: money.New(2500, 2)})
+
+	report, err := Reconcile(memoryStore, false)
+
+	assert.NoError(t, err)
+	assert.Equal(t, []Discrepancy{{
+		WalletId:      wallet.ID,
+		TransactionId: &broken.ID,
+		Kind:          constant.CLOSING_BALANCE_BREAK,
+		Expected:      money.New(3000, 2),
+		Actual:        money.New(2500, 2),
+	}}, report.Discrepancies)
+}
+
+func TestReconcileRepairWritesAdjustmentTransaction(t *testing.T) {
+	memoryStore := store.NewMemoryStore()
+	wallet := newWallet(t, memoryStore, "USD", 1500, 0)
+	newTransaction(t, memoryStore, model.Transaction{WalletId: wallet.ID, Type: constant.CREDIT, Amount: money.New(2000, 2), ClosingBalance: money.New(2000, 2)})
+
+	report, err := Reconcile(memoryStore, true)
+
+	assert.NoError(t, err)
+	assert.Len(t, report.Discrepancies, 1)
+	discrepancy := report.Discrepancies[0]
+	assert.Equal(t, constant.BALANCE_MISMATCH, discrepancy.Kind)
+	assert.Equal(t, money.New(2000, 2), discrepancy.Expected)
+	assert.Equal(t, money.New(1500, 2), discrepancy.Actual)
+	adjustment := getTransaction(t, memoryStore, *discrepancy.AdjustmentId)
+	assert.Equal(t, constant.DEBIT, adjustment.Type)
+	assert.Equal(t, money.New(500, 2), adjustment.Amount)
+	assert.Equal(t, money.New(1500, 2), adjustment.ClosingBalance)
+	assert.Equal(t, money.New(1500, 2), getWallet(t, memoryStore, wallet.ID).Balance)
+	records, err := memoryStore.ListAuditRecords(store.AuditFilter{Action: constant.AUDIT_TRANSACTION_ADJUSTED})
+	assert.NoError(t, err)
+	if assert.Len(t, records, 1) {
+		assert.Equal(t, "command:reconcile", records[0].Actor)
+		assert.Equal(t, wallet.ID, *records[0].WalletId)
+	}
+	events, err := memoryStore.ListUndispatchedEvents(10)
+	assert.NoError(t, err)
+	last := events[len(events)-1]
+	assert.Equal(t, constant.EVENT_TRANSACTION_CREATED, last.Type)
+	assert.Contains(t, string(last.Data), "Reconciliation adjustment")
+
+	report, err = Reconcile(memoryStore, false)
+	assert.NoError(t, err)
+	assert.Empty(t, report.Discrepancies)
+}
+
+func TestReconcileWalletsFailsWith400ForInvalidRepairFlag(t *testing.T) {
+	memoryStore := store.NewMemoryStore()
+	testService := testutils.NewTestServer().RegisterHandler("/admin/reconcile", memoryStore, ReconcileWallets)
+	defer testService.Server.Close()
+
+	resp, err := http.Get(testService.Server.URL + "/admin/reconcile?repair=maybe")
+
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+}
+
+func TestReconcileWalletsReturnsReport(t *testing.T) {
+	memoryStore := store.NewMemoryStore()
+	newWallet(t, memoryStore, "USD", 1000, 0)
+	testService := testutils.NewTestServer().RegisterHandler("/admin/reconcile", memoryStore, ReconcileWallets)
+	defer testService.Server.Close()
+
+	resp, err := http.Get(testService.Server.URL + "/admin/reconcile")
+
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	report := ReconciliationReport{}
+	decodeBody(t, resp, &report)
+	assert.Equal(t, 1, report.WalletsChecked)
+	assert.False(t, report.Repair)
+	assert.Len(t, report.Discrepancies, 1)
+	assert.Nil(t, report.Discrepancies[0].AdjustmentId)
+}
@@ -0,0 +1,385 @@
+package handler
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"time"
+	"unicode/utf8"
+	"wallet/app/apperror"
+	"wallet/app/constant"
+	"wallet/app/logging"
+	"wallet/app/model"
+	"wallet/app/money"
+	"wallet/app/recurrence"
+	"wallet/app/store"
+
+	"github.com/gorilla/mux"
+)
+
+// ScheduleMaxAttempts is how many times an occurrence of a schedule is tried
+// before it is recorded as failed, and ScheduleRetryDelay the wait before
+// the first retry, doubling after each one.
+var (
+	ScheduleMaxAttempts = 3
+	ScheduleRetryDelay  = time.Minute
+)
+
+// scheduleResponse shows a schedule with the runs of its occurrences.
+type scheduleResponse struct {
+	model.Schedule
+	History []model.ScheduleRun `json:"history"`
+}
+
+func CreateSchedule(s store.Store, w http.ResponseWriter, r *http.Request) {
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		respondError(w, r, apperror.Invalid(err))
+		return
+	}
+	request := createScheduleRequest{}
+	if err := decodeStrict(body, &request); err != nil {
+		respondError(w, r, err)
+		return
+	}
+	schedule := request.schedule()
+	if err := validateSchedule(&schedule); err != nil {
+		respondError(w, r, apperror.Invalid(err))
+		return
+	}
+	if !authorizeWallet(s, w, r, schedule.WalletId) {
+		return
+	}
+	if err := scheduleInWalletCurrency(s, &schedule); err != nil {
+		respondError(w, r, apperror.Internal("failed while fetching wallet information", err))
+		return
+	}
+	schedule.NextRunAt = schedule.StartAt
+	schedule.Status = constant.SCHEDULE_ACTIVE
+	err = s.Atomic(func(tx store.Store) error {
+		if err := tx.CreateSchedule(&schedule); err != nil {
+			return err
+		}
+		return recordAudit(tx, r, constant.AUDIT_SCHEDULE_CREATED, &schedule.WalletId, nil, schedule)
+	})
+	if err != nil {
+		respondError(w, r, apperror.Internal("failed to create schedule", err))
+		return
+	}
+	respondSuccess(w, scheduleResponse{Schedule: schedule, History: []model.ScheduleRun{}})
+}
+
+// createScheduleRequest is the body of POST /walletapi/schedule. The progress
+// of a schedule, its runs, attempts, next run and status, is only ever set
+// by the server.
+type createScheduleRequest struct {
+	Type        string      `json:"type"`
+	WalletId    uint        `json:"wallet_id"`
+	ToWalletId  *uint       `json:"to_wallet_id"`
+	Amount      money.Money `json:"amount"`
+	Currency    string      `json:"currency"`
+	Description string      `json:"description"`
+	Rule        string      `json:"rule"`
+	StartAt     time.Time   `json:"start_at"`
+	EndAt       *time.Time  `json:"end_at"`
+	MaxRuns     int         `json:"max_runs"`
+}
+
+func (request createScheduleRequest) schedule() model.Schedule {
+	return model.Schedule{
+		Type:        request.Type,
+		WalletId:    request.WalletId,
+		ToWalletId:  request.ToWalletId,
+		Amount:      request.Amount,
+		Currency:    request.Currency,
+		Description: request.Description,
+		Rule:        request.Rule,
+		StartAt:     request.StartAt,
+		EndAt:       request.EndAt,
+		MaxRuns:     request.MaxRuns,
+	}
+}
+
+// validateSchedule checks a new schedule, starting it now when it gives no
+// start_at. Starts in the past are refused rather than backfilled.
+func validateSchedule(schedule *model.Schedule) error {
+	switch schedule.Type {
+	case constant.CREDIT, constant.DEBIT:
+		schedule.ToWalletId = nil
+	case constant.TRANSFER:
+		if schedule.ToWalletId == nil || *schedule.ToWalletId == schedule.WalletId {
+			return fmt.Errorf("to_wallet_id is required and must differ from wallet_id")
+		}
+	default:
+		return fmt.Errorf("type must be CREDIT, DEBIT or TRANSFER")
+	}
+	if schedule.Amount.IsNegative() || schedule.Amount.IsZero() {
+		return apperror.ErrInvalidAmount
+	}
+	if _, err := recurrence.Parse(schedule.Rule); err != nil {
+		return err
+	}
+	now := time.Now()
+	if schedule.StartAt.IsZero() {
+		schedule.StartAt = now
+	} else if schedule.StartAt.Before(now) {
+		return fmt.Errorf("start_at must not be in the past")
+	}
+	if schedule.EndAt != nil && schedule.EndAt.Before(schedule.StartAt) {
+		return fmt.Errorf("end_at must not be before start_at")
+	}
+	if schedule.MaxRuns < 0 {
+		return fmt.Errorf("max_runs must not be negative")
+	}
+	return nil
+}
+
+// scheduleInWalletCurrency sets the schedule's currency to its wallet's and
+// puts the amount in it, refusing amounts with more decimal places than it
+// allows. Scheduled transfers carry no fx rate, so both
+// wallets must use the same currency.
+func scheduleInWalletCurrency(s store.Store, schedule *model.Schedule) error {
+	wallet, err := s.GetWallet(schedule.WalletId)
+	if err != nil {
+		return notFoundAs(err, apperror.ErrWalletNotFound)
+	}
+	if schedule.Currency != "" && schedule.Currency != wallet.Currency {
+		return apperror.ErrCurrencyMismatch
+	}
+	amount, err := amountInCurrency("amount", schedule.Amount, wallet.Currency)
+	if err != nil {
+		return err
+	}
+	schedule.Currency = wallet.Currency
+	schedule.Amount = amount
+	if schedule.ToWalletId == nil {
+		return nil
+	}
+	to, err := s.GetWallet(*schedule.ToWalletId)
+	if err != nil {
+		return notFoundAs(err, apperror.ErrWalletNotFound)
+	}
+	if to.Currency != wallet.Currency {
+		return apperror.ErrCurrencyMismatch
+	}
+	return nil
+}
+
+func GetSchedule(s store.Store, w http.ResponseWriter, r *http.Request) {
+	schedule, ok := fetchSchedule(s, w, r)
+	if !ok {
+		return
+	}
+	runs, err := s.ListScheduleRuns(schedule.ID)
+	if err != nil {
+		respondError(w, r, apperror.Internal("failed while fetching schedule runs", err))
+		return
+	}
+	if runs == nil {
+		runs = []model.ScheduleRun{}
+	}
+	respondSuccess(w, scheduleResponse{Schedule: schedule, History: runs})
+}
+
+// CancelSchedule stops a schedule. Occurrences already run are kept.
+func CancelSchedule(s store.Store, w http.ResponseWriter, r *http.Request) {
+	schedule, ok := fetchSchedule(s, w, r)
+	if !ok {
+		return
+	}
+	err := s.Atomic(func(tx store.Store) error {
+		var err error
+		if schedule, err = tx.LockSchedule(schedule.ID); err != nil {
+			return err
+		}
+		if schedule.Status != constant.SCHEDULE_ACTIVE {
+			return apperror.ErrScheduleNotActive
+		}
+		before := schedule
+		schedule.Status = constant.SCHEDULE_CANCELLED
+		if err := tx.UpdateSchedule(&schedule); err != nil {
+			return err
+		}
+		return recordAudit(tx, r, constant.AUDIT_SCHEDULE_CANCELLED, &schedule.WalletId, before, schedule)
+	})
+	if err != nil {
+		respondError(w, r, apperror.Internal("failed to cancel schedule", err))
+		return
+	}
+	respondSuccess(w, schedule)
+}
+
+// fetchSchedule loads the schedule named in the route and checks the caller
+// may act on its wallet, answering the request itself when not.
+func fetchSchedule(s store.Store, w http.ResponseWriter, r *http.Request) (model.Schedule, bool) {
+	scheduleId, err := strconv.ParseInt(mux.Vars(r)["schedule_id"], 10, 64)
+	if err != nil {
+		respondError(w, r, apperror.BadRequest("invalid schedule id"))
+		return model.Schedule{}, false
+	}
+	schedule, err := s.GetSchedule(uint(scheduleId))
+	if err == store.ErrNotFound {
+		respondError(w, r, apperror.ErrScheduleNotFound)
+		return schedule, false
+	}
+	if err != nil {
+		respondError(w, r, apperror.Internal("failed while fetching schedule information", err))
+		return schedule, false
+	}
+	return schedule, authorizeWallet(s, w, r, schedule.WalletId)
+}
+
+// RunDueSchedules runs the next occurrence of every schedule due by now.
+// Schedules that fell behind catch up one occurrence per call.
+func RunDueSchedules(s store.Store) error {
+	now := time.Now()
+	schedules, err := s.ListDueSchedules(now)
+	if err != nil {
+		return err
+	}
+	for _, schedule := range schedules {
+		if err := runSchedule(s, schedule.ID, now); err != nil {
+			Logger.Error("failed to run schedule", "schedule_id", schedule.ID, logging.WalletID, schedule.WalletId, "error", err)
+		}
+	}
+	return nil
+}
+
+// runSchedule posts the schedule's next occurrence if it is still due once
+// the schedule is locked. The posting, its run and the schedule's progress
+// are committed together, so an occurrence is never posted twice. When the
+// posting fails, the attempt is recorded instead, and after
+// ScheduleMaxAttempts the occurrence is recorded as failed and skipped.
+func runSchedule(s store.Store, scheduleId uint, now time.Time) error {
+	err := s.Atomic(func(tx store.Store) error {
+		schedule, err := tx.LockSchedule(scheduleId)
+		if err != nil {
+			return err
+		}
+		if !isScheduleDue(schedule, now) {
+			return nil
+		}
+		run, err := newScheduleRun(schedule)
+		if err != nil {
+			return err
+		}
+		run.Status = constant.RUN_SUCCEEDED
+		if err := postScheduled(tx, schedule, &run); err != nil {
+			return err
+		}
+		if err := tx.CreateScheduleRun(&run); err != nil {
+			return err
+		}
+		schedule.LastError = ""
+		return advanceSchedule(tx, &schedule)
+	})
+	if err == nil {
+		return nil
+	}
+	return s.Atomic(func(tx store.Store) error {
+		schedule, lockErr := tx.LockSchedule(scheduleId)
+		if lockErr != nil {
+			return lockErr
+		}
+		if !isScheduleDue(schedule, now) {
+			return nil
+		}
+		run, runErr := newScheduleRun(schedule)
+		if runErr != nil {
+			return runErr
+		}
+		schedule.Attempts = run.Attempts
+		schedule.LastError = truncate(err.Error(), 255)
+		if schedule.Attempts < ScheduleMaxAttempts {
+			schedule.NextRunAt = now.Add(ScheduleRetryDelay << uint(schedule.Attempts-1))
+			return tx.UpdateSchedule(&schedule)
+		}
+		run.Status = constant.RUN_FAILED
+		run.Error = schedule.LastError
+		if err := tx.CreateScheduleRun(&run); err != nil {
+			return err
+		}
+		return advanceSchedule(tx, &schedule)
+	})
+}
+
+func isScheduleDue(schedule model.Schedule, now time.Time) bool {
+	return schedule.Status == constant.SCHEDULE_ACTIVE && !schedule.NextRunAt.After(now)
+}
+
+func newScheduleRun(schedule model.Schedule) (model.ScheduleRun, error) {
+	rule, err := recurrence.Parse(schedule.Rule)
+	if err != nil {
+		return model.ScheduleRun{}, err
+	}
+	return model.ScheduleRun{
+		ScheduleId:  schedule.ID,
+		Occurrence:  schedule.Runs,
+		ScheduledAt: rule.Occurrence(schedule.StartAt, schedule.Runs),
+		Attempts:    schedule.Attempts + 1,
+	}, nil
+}
+
+// postScheduled posts an occurrence through the same path as
+// CreateTransaction and CreateTransfer, inside the caller's DB transaction.
+func postScheduled(tx store.Store, schedule model.Schedule, run *model.ScheduleRun) error {
+	description := schedule.Description
+	if description == "" {
+		description = fmt.Sprint("Scheduled :", schedule.ID)
+	}
+	if schedule.Type == constant.TRANSFER {
+		transfer, err := processTransfer(tx, model.Transfer{
+			FromWalletId: schedule.WalletId,
+			ToWalletId:   *schedule.ToWalletId,
+			Amount:       schedule.Amount,
+			Currency:     schedule.Currency,
+			Description:  description,
+		})
+		if err != nil {
+			return err
+		}
+		run.TransferId = &transfer.ID
+		return nil
+	}
+	transaction, err := processTransaction(model.Transaction{
+		WalletId:    schedule.WalletId,
+		Type:        schedule.Type,
+		Amount:      schedule.Amount,
+		Currency:    schedule.Currency,
+		Description: description,
+	}, tx)
+	if err != nil {
+		return err
+	}
+	run.TransactionId = &transaction.ID
+	return nil
+}
+
+// advanceSchedule moves the schedule on to its next occurrence, completing
+// it once MaxRuns or EndAt is reached.
+func advanceSchedule(tx store.Store, schedule *model.Schedule) error {
+	rule, err := recurrence.Parse(schedule.Rule)
+	if err != nil {
+		return err
+	}
+	schedule.Runs++
+	schedule.Attempts = 0
+	schedule.NextRunAt = rule.Occurrence(schedule.StartAt, schedule.Runs)
+	if (schedule.MaxRuns > 0 && schedule.Runs >= schedule.MaxRuns) ||
+		(schedule.EndAt != nil && schedule.NextRunAt.After(*schedule.EndAt)) {
+		schedule.Status = constant.SCHEDULE_COMPLETED
+	}
+	return tx.UpdateSchedule(schedule)
+}
+
+// truncate cuts s to at most n bytes, on a rune boundary.
+func truncate(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	for n > 0 && !utf8.RuneStart(s[n]) {
+		n--
+	}
+	return s[:n]
+}
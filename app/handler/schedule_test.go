@@ -0,0 +1,172 @@
+package handler
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+	"wallet/app/constant"
+	"wallet/app/model"
+	"wallet/app/money"
+	"wallet/app/store"
+	"wallet/testutils"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newSchedule(t *testing.T, s store.Store, schedule model.Schedule) model.Schedule {
+	schedule.NextRunAt = schedule.StartAt
+	schedule.Status = constant.SCHEDULE_ACTIVE
+	assert.NoError(t, scheduleInWalletCurrency(s, &schedule))
+	assert.NoError(t, s.CreateSchedule(&schedule))
+	return schedule
+}
+
+func getSchedule(t *testing.T, s store.Store, id uint) model.Schedule {
+	schedule, err := s.GetSchedule(id)
+	assert.NoError(t, err)
+	return schedule
+}
+
+func TestCreateScheduleStartsActive(t *testing.T) {
+	memoryStore := store.NewMemoryStore()
+	testService := testutils.NewTestServer().RegisterHandler("/schedule", memoryStore, CreateSchedule)
+	defer testService.Server.Close()
+	wallet := newWallet(t, memoryStore, "USD", 0, 0)
+	body := fmt.Sprintf(`{"wallet_id":%d, "type":"CREDIT", "amount":"100", "rule":"FREQ=MONTHLY", "start_at":"2030-01-01T00:00:00Z", "max_runs":12}`, wallet.ID)
+
+	resp, err := http.Post(testService.Server.URL+"/schedule", "application/json", strings.NewReader(body))
+
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	schedule := scheduleResponse{}
+	decodeBody(t, resp, &schedule)
+	assert.Equal(t, constant.SCHEDULE_ACTIVE, schedule.Status)
+	assert.Equal(t, money.New(10000, 2), schedule.Amount)
+	assert.Equal(t, "USD", schedule.Currency)
+	assert.True(t, schedule.NextRunAt.Equal(time.Date(2030, time.January, 1, 0, 0, 0, 0, time.UTC)))
+}
+
+func TestCreateScheduleRefusesServerOwnedFields(t *testing.T) {
+	memoryStore := store.NewMemoryStore()
+	testService := testutils.NewTestServer().RegisterHandler("/schedule", memoryStore, CreateSchedule)
+	defer testService.Server.Close()
+	wallet := newWallet(t, memoryStore, "USD", 0, 0)
+	for _, field := range []string{`"runs":5`, `"status":"COMPLETED"`, `"next_run_at":"2030-01-01T00:00:00Z"`, `"attempts":2`, `"ID":7`} {
+		body := fmt.Sprintf(`{"wallet_id":%d, "type":"CREDIT", "amount":"100", "rule":"FREQ=MONTHLY", %s}`, wallet.ID, field)
+
+		resp, err := http.Post(testService.Server.URL+"/schedule", "application/json", strings.NewReader(body))
+
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode, field)
+	}
+}
+
+func TestCreateScheduleFailsWith400ForStartInThePast(t *testing.T) {
+	memoryStore := store.NewMemoryStore()
+	testService := testutils.NewTestServer().RegisterHandler("/schedule", memoryStore, CreateSchedule)
+	defer testService.Server.Close()
+	wallet := newWallet(t, memoryStore, "USD", 0, 0)
+	body := fmt.Sprintf(`{"wallet_id":%d, "type":"CREDIT", "amount":"100", "rule":"FREQ=DAILY", "start_at":"2020-01-01T00:00:00Z"}`, wallet.ID)
+
+	resp, err := http.Post(testService.Server.URL+"/schedule", "application/json", strings.NewReader(body))
+
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	schedules, err := memoryStore.ListDueSchedules(time.Now())
+	assert.NoError(t, err)
+	assert.Empty(t, schedules)
+}
+
+func TestTruncateCutsOnARuneBoundary(t *testing.T) {
+	assert.Equal(t, "ab", truncate("ab", 3))
+	assert.Equal(t, "a", truncate("aé", 2))
+	assert.Equal(t, "aé", truncate("aéb", 3))
+}
+
+func TestCreateScheduleFailsWith400ForInvalidRule(t *testing.T) {
+	memoryStore := store.NewMemoryStore()
+	testService := testutils.NewTestServer().RegisterHandler("/schedule", memoryStore, CreateSchedule)
+	defer testService.Server.Close()
+	wallet := newWallet(t, memoryStore, "USD", 0, 0)
+	body := fmt.Sprintf(`{"wallet_id":%d, "type":"CREDIT", "amount":"100", "rule":"FREQ=HOURLY"}`, wallet.ID)
+
+	resp, err := http.Post(testService.Server.URL+"/schedule", "application/json", strings.NewReader(body))
+
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+}
+
+func TestRunSchedulePostsEachOccurrenceOnce(t *testing.T) {
+	memoryStore := store.NewMemoryStore()
+	wallet := newWallet(t, memoryStore, "USD", 0, 0)
+	start := time.Now().Add(-time.Hour)
+	schedule := newSchedule(t, memoryStore, model.Schedule{WalletId: wallet.ID, Type: constant.CREDIT, Amount: money.New(10000, 2), Rule: "FREQ=WEEKLY", StartAt: start, MaxRuns: 2})
+
+	assert.NoError(t, RunDueSchedules(memoryStore))
+	assert.NoError(t, RunDueSchedules(memoryStore))
+	assert.NoError(t, runSchedule(memoryStore, schedule.ID, time.Now()))
+
+	assert.Equal(t, money.New(10000, 2), getWallet(t, memoryStore, wallet.ID).Balance)
+	schedule = getSchedule(t, memoryStore, schedule.ID)
+	assert.Equal(t, 1, schedule.Runs)
+	assert.True(t, schedule.NextRunAt.Equal(start.AddDate(0, 0, 7)))
+	runs, err := memoryStore.ListScheduleRuns(schedule.ID)
+	assert.NoError(t, err)
+	assert.Len(t, runs, 1)
+	assert.Equal(t, constant.RUN_SUCCEEDED, runs[0].Status)
+	assert.NotNil(t, runs[0].TransactionId)
+
+	assert.NoError(t, runSchedule(memoryStore, schedule.ID, start.AddDate(0, 0, 7)))
+
+	assert.Equal(t, money.New(20000, 2), getWallet(t, memoryStore, wallet.ID).Balance)
+	assert.Equal(t, constant.SCHEDULE_COMPLETED, getSchedule(t, memoryStore, schedule.ID).Status)
+}
+
+func TestRunScheduleRetriesThenRecordsFailure(t *testing.T) {
+	memoryStore := store.NewMemoryStore()
+	wallet := newWallet(t, memoryStore, "USD", 0, 0)
+	now := time.Now()
+	schedule := newSchedule(t, memoryStore, model.Schedule{WalletId: wallet.ID, Type: constant.DEBIT, Amount: money.New(1000, 2), Rule: "FREQ=DAILY", StartAt: now})
+
+	assert.NoError(t, runSchedule(memoryStore, schedule.ID, now))
+
+	schedule = getSchedule(t, memoryStore, schedule.ID)
+	assert.Equal(t, 0, schedule.Runs)
+	assert.Equal(t, 1, schedule.Attempts)
+	assert.NotEmpty(t, schedule.LastError)
+	assert.True(t, schedule.NextRunAt.Equal(now.Add(ScheduleRetryDelay)))
+
+	for i := 1; i < ScheduleMaxAttempts; i++ {
+		assert.NoError(t, runSchedule(memoryStore, schedule.ID, getSchedule(t, memoryStore, schedule.ID).NextRunAt))
+	}
+
+	schedule = getSchedule(t, memoryStore, schedule.ID)
+	assert.Equal(t, 1, schedule.Runs)
+	assert.Equal(t, 0, schedule.Attempts)
+	assert.True(t, schedule.NextRunAt.Equal(now.AddDate(0, 0, 1)))
+	runs, err := memoryStore.ListScheduleRuns(schedule.ID)
+	assert.NoError(t, err)
+	assert.Len(t, runs, 1)
+	assert.Equal(t, constant.RUN_FAILED, runs[0].Status)
+	assert.Equal(t, ScheduleMaxAttempts, runs[0].Attempts)
+	assert.Nil(t, runs[0].TransactionId)
+}
+
+func TestCancelScheduleStopsRuns(t *testing.T) {
+	memoryStore := store.NewMemoryStore()
+	testService := testutils.NewTestServer().RegisterHandler("/schedule/{schedule_id}", memoryStore, CancelSchedule)
+	defer testService.Server.Close()
+	wallet := newWallet(t, memoryStore, "USD", 0, 0)
+	schedule := newSchedule(t, memoryStore, model.Schedule{WalletId: wallet.ID, Type: constant.CREDIT, Amount: money.New(1000, 2), Rule: "FREQ=DAILY", StartAt: time.Now()})
+	req, _ := http.NewRequest(http.MethodDelete, fmt.Sprintf("%s/schedule/%d", testService.Server.URL, schedule.ID), nil)
+
+	resp, err := http.DefaultClient.Do(req)
+
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.NoError(t, RunDueSchedules(memoryStore))
+	assert.Equal(t, money.New(0, 2), getWallet(t, memoryStore, wallet.ID).Balance)
+	assert.Equal(t, constant.SCHEDULE_CANCELLED, getSchedule(t, memoryStore, schedule.ID).Status)
+}
@@ -1,120 +1,450 @@
 package handler
 
 import (
-	"encoding/json"
 	"fmt"
+	"io/ioutil"
 	"net/http"
+	"sort"
 	"strconv"
+	"wallet/app/apperror"
 	"wallet/app/constant"
+	"wallet/app/logging"
 	"wallet/app/model"
+	"wallet/app/money"
+	"wallet/app/store"
 
 	"github.com/gorilla/mux"
-	"github.com/jinzhu/gorm"
 )
 
-func CreateTransaction(db *gorm.DB, w http.ResponseWriter, r *http.Request) {
-	fmt.Println("GET----------")
-	transaction := model.Transaction{}
-	decoder := json.NewDecoder(r.Body)
-
-	if err := decoder.Decode(&transaction); err != nil {
-		respondError(w, http.StatusBadRequest, err.Error())
+func CreateTransaction(s store.Store, w http.ResponseWriter, r *http.Request) {
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		respondError(w, r, apperror.Invalid(err))
 		return
 	}
-	wallet := getWalletFor(db, transaction.WalletId)
-	fmt.Printf("----------------%#v", transaction)
-
-	if err := db.Error; err != nil {
-		respondError(w, http.StatusInternalServerError, "failed while fetching wallet information")
+	key := r.Header.Get(idempotencyKeyHeader)
+	if len(key) > 255 {
+		respondError(w, r, apperror.BadRequest("idempotency key too long"))
+		return
+	}
+	request := createTransactionRequest{}
+	if err := decodeStrict(body, &request); err != nil {
+		respondError(w, r, err)
+		return
 	}
-	if !isValidTransactionType(transaction) {
-		respondError(w, http.StatusBadRequest, "invalid transaction type")
+	if err := request.validate().err(); err != nil {
+		respondError(w, r, err)
 		return
 	}
-	tran, err := processTransaction(wallet, transaction, db)
+	wallet, ok := authorizedWallet(s, w, r, request.WalletId)
+	if !ok {
+		return
+	}
+	fingerprint := requestFingerprint(r, body)
+	if key != "" && replayIdempotentRequest(s, w, r, key, fingerprint) {
+		return
+	}
+	if request.Currency == "" {
+		errs := fieldErrors{}
+		errs.checkAmount("amount", request.Amount, wallet.Currency)
+		if err := errs.err(); err != nil {
+			respondError(w, r, err)
+			return
+		}
+	}
+	transaction := request.transaction()
+	tran, err := processTransaction(transaction, s, storeIdempotencyKey(r, key, fingerprint), auditTransaction(r, constant.AUDIT_TRANSACTION_CREATED))
 	if err != nil {
-		respondError(w, http.StatusInternalServerError, "failed to process transaction, "+err.Error())
+		// a concurrent request with the same key may have committed first
+		if key != "" && replayIdempotentRequest(s, w, r, key, fingerprint) {
+			return
+		}
+		respondError(w, r, apperror.Internal("failed to process transaction", err))
 		return
 	}
-	fmt.Printf("====> %#v", tran)
+	loggerFor(r).Info("transaction created", logging.WalletID, tran.WalletId, logging.TransactionID, tran.ID, "type", tran.Type)
 	respondSuccess(w, *tran)
 }
 
-func RevertTransaction(db *gorm.DB, w http.ResponseWriter, r *http.Request) {
-	fmt.Println("helooooooooooooooooooooo")
+func RevertTransaction(s store.Store, w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	tranId, err := strconv.ParseInt(vars["tran_id"], 10, 64)
-	transaction := model.Transaction{}
-	transaction.ID = uint(tranId)
-	db.Find(&transaction)
+	if err != nil {
+		respondError(w, r, apperror.BadRequest("invalid transaction id"))
+		return
+	}
+	transaction, err := s.GetTransaction(uint(tranId))
+	if err != nil {
+		respondError(w, r, apperror.Internal("failed while fetching transaction information", notFoundAs(err, apperror.ErrTransactionNotFound)))
+		return
+	}
+	if !authorizeTransaction(s, w, r, transaction) {
+		return
+	}
+	reverseFee := r.URL.Query().Get("reverse_fee") == "true"
+	if transaction.TransferId != nil {
+		var transfer *model.Transfer
+		err := s.Atomic(func(tx store.Store) error {
+			var err error
+			if transfer, err = revertTransfer(tx, *transaction.TransferId, reverseFee); err != nil {
+				return err
+			}
+			return recordAudit(tx, r, constant.AUDIT_TRANSACTION_REVERTED, &transaction.WalletId, transaction, transfer)
+		})
+		if err != nil {
+			respondError(w, r, apperror.Internal("failed to revert transfer", err))
+			return
+		}
+		respondSuccess(w, *transfer)
+		return
+	}
+	var tran *model.Transaction
+	err = s.Atomic(func(tx store.Store) error {
+		var err error
+		if tran, err = processReversal(tx, transaction.ID, nil, reverseFee); err != nil {
+			return err
+		}
+		return recordAudit(tx, r, constant.AUDIT_TRANSACTION_REVERTED, &transaction.WalletId, transaction, tran)
+	})
+	if err != nil {
+		respondError(w, r, apperror.Internal("failed to process transaction", err))
+		return
+	}
+	respondSuccess(w, *tran)
+}
+
+// createTransactionRequest is the body of POST /walletapi/transaction. The
+// rest of a transaction, its ID, balances and links, is only ever set by the
+// server.
+type createTransactionRequest struct {
+	WalletId    uint        `json:"wallet_id"`
+	Type        string      `json:"type"`
+	Amount      money.Money `json:"amount"`
+	Currency    string      `json:"currency"`
+	Description string      `json:"description"`
+}
 
-	if err := db.Error; err != nil {
-		respondError(w, http.StatusInternalServerError, "failed while transaction information")
+func (request createTransactionRequest) validate() fieldErrors {
+	errs := fieldErrors{}
+	if request.WalletId == 0 {
+		errs.add("wallet_id", "is required")
 	}
-	wallet := getWalletFor(db, transaction.WalletId)
-	if err := db.Error; err != nil {
-		respondError(w, http.StatusInternalServerError, "failed while fetching wallet information")
+	if !isValidTransactionType(model.Transaction{Type: request.Type}) {
+		errs.add("type", "must be CREDIT or DEBIT")
 	}
-	transaction = createRevertTransaction(transaction)
-	tran, err := processTransaction(wallet, transaction, db)
+	errs.checkCurrency("currency", request.Currency)
+	errs.checkAmount("amount", request.Amount, request.Currency)
+	errs.checkDescription("description", request.Description)
+	return errs
+}
+
+func (request createTransactionRequest) transaction() model.Transaction {
+	return model.Transaction{
+		WalletId:    request.WalletId,
+		Type:        request.Type,
+		Amount:      request.Amount,
+		Currency:    request.Currency,
+		Description: request.Description,
+	}
+}
+
+type refundRequest struct {
+	Amount money.Money `json:"amount"`
+}
+
+// RefundTransaction reverses part of a transaction. Several refunds may be
+// made against one transaction as long as their total stays within its
+// amount.
+func RefundTransaction(s store.Store, w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	tranId, err := strconv.ParseInt(vars["tran_id"], 10, 64)
+	if err != nil {
+		respondError(w, r, apperror.BadRequest("invalid transaction id"))
+		return
+	}
+	body, err := ioutil.ReadAll(r.Body)
 	if err != nil {
-		respondError(w, http.StatusInternalServerError, "failed to process transaction, "+err.Error())
+		respondError(w, r, apperror.Invalid(err))
+		return
+	}
+	request := refundRequest{}
+	if err := decodeStrict(body, &request); err != nil {
+		respondError(w, r, err)
+		return
+	}
+	if request.Amount.IsNegative() || request.Amount.IsZero() {
+		respondError(w, r, apperror.ErrInvalidAmount)
+		return
+	}
+	transaction, err := s.GetTransaction(uint(tranId))
+	if err != nil {
+		respondError(w, r, apperror.Internal("failed while fetching transaction information", notFoundAs(err, apperror.ErrTransactionNotFound)))
+		return
+	}
+	if !authorizeTransaction(s, w, r, transaction) {
+		return
+	}
+	var tran *model.Transaction
+	err = s.Atomic(func(tx store.Store) error {
+		var err error
+		if tran, err = processReversal(tx, uint(tranId), &request.Amount, false); err != nil {
+			return err
+		}
+		return recordAudit(tx, r, constant.AUDIT_TRANSACTION_REFUNDED, &transaction.WalletId, transaction, tran)
+	})
+	if err != nil {
+		respondError(w, r, apperror.Internal("failed to process refund", err))
 		return
 	}
 	respondSuccess(w, *tran)
 }
-func rollbackOnError(tx *gorm.DB) {
-	if r := recover(); r != nil {
-		tx.Rollback()
+
+// authorizeTransaction checks the caller may reverse transaction. Reverting a
+// transfer moves money out of the receiving wallet, so the caller must be
+// able to act on both of its wallets.
+func authorizeTransaction(s store.Store, w http.ResponseWriter, r *http.Request, transaction model.Transaction) bool {
+	if transaction.TransferId == nil || isAdmin(r) {
+		return authorizeWallet(s, w, r, transaction.WalletId)
 	}
+	transfer, err := s.GetTransfer(*transaction.TransferId)
+	if err != nil {
+		respondError(w, r, apperror.Internal("failed while fetching transfer information", err))
+		return false
+	}
+	return authorizeWallet(s, w, r, transfer.FromWalletId) && authorizeWallet(s, w, r, transfer.ToWalletId)
 }
-func processTransaction(wallet model.Wallet, transaction model.Transaction, db *gorm.DB) (*model.Transaction, error) {
-	if !canProcessTransaction(transaction, wallet) {
-		return nil, fmt.Errorf("cannot process transaction, check your balance")
+
+func checkReversible(transaction model.Transaction) error {
+	if transaction.ReversedTransactionId != nil {
+		return apperror.ErrReversalOfReversal
 	}
-	tx := db.Begin()
-	defer rollbackOnError(tx)
-	if err := tx.Error; err != nil {
-		return nil, err
+	if transaction.ReversalState == constant.REVERSED {
+		return apperror.ErrAlreadyReversed
 	}
-	wallet.Balance = getUpdatedWalletBalance(wallet, transaction)
-	if err := tx.Save(&wallet).Error; err != nil {
+	return nil
+}
+
+// processReversal reverses amount of a transaction, or whatever is left to
+// refund when amount is nil. The original is locked before its reversal state
+// is checked, so concurrent refunds cannot exceed the original amount. With
+// reverseFee, the fees charged on a fully reversed transaction are reversed
+// as well; otherwise they are kept.
+func processReversal(s store.Store, tranId uint, amount *money.Money, reverseFee bool) (*model.Transaction, error) {
+	var reversal model.Transaction
+	err := s.Atomic(func(tx store.Store) error {
+		original, err := tx.LockTransaction(tranId)
+		if err != nil {
+			return notFoundAs(err, apperror.ErrTransactionNotFound)
+		}
+		if original.FeeOfTransactionId != nil {
+			return apperror.ErrFeeReversal
+		}
+		if err := checkReversible(original); err != nil {
+			return err
+		}
+		reversal = createRevertTransaction(original)
+		reversal.Amount = original.RefundableAmount
+		if amount != nil {
+			if original.TransferId != nil {
+				return apperror.ErrPartialTransferRefund
+			}
+			if reverseFee {
+				return apperror.ErrPartialFeeReversal
+			}
+			refund, err := amountInCurrency("amount", *amount, original.Currency)
+			if err != nil {
+				return err
+			}
+			if refund.Cmp(original.RefundableAmount) > 0 {
+				return apperror.ErrRefundExceedsAmount
+			}
+			reversal.Amount = refund
+			reversal.Description = fmt.Sprint("Refund of :", original.ID)
+		}
+		var fees []model.Transaction
+		reversals := []*model.Transaction{&reversal}
+		if reverseFee {
+			var feeReversed []*model.Transaction
+			if fees, feeReversed, err = feeReversals(tx, original.ID); err != nil {
+				return err
+			}
+			reversals = append(reversals, feeReversed...)
+		}
+		if err := postTransactions(tx, reversals...); err != nil {
+			return err
+		}
+		if err := markFeesReversed(tx, &reversal, fees, reversals[1:]); err != nil {
+			return err
+		}
+		return markRefunded(tx, &original, reversal.Amount)
+	})
+	if err != nil {
 		return nil, err
 	}
-	transaction.ClosingBalance = wallet.Balance
-	if err := tx.Save(&transaction).Error; err != nil {
-		return nil, err
+	return &reversal, nil
+}
+
+func markRefunded(tx store.Store, original *model.Transaction, amount money.Money) error {
+	original.RefundedAmount = original.RefundedAmount.Add(amount)
+	original.ReversalState = constant.PARTIALLY_REVERSED
+	if original.RefundedAmount.Cmp(original.Amount) >= 0 {
+		original.ReversalState = constant.REVERSED
 	}
+	return tx.UpdateRefund(original)
+}
 
-	if err := tx.Save(&wallet).Error; err != nil {
+// transactionHook runs inside the DB transaction after the transaction row
+// is saved; an error rolls everything back.
+type transactionHook func(tx store.Store, transaction *model.Transaction) error
+
+// processTransaction locks the wallet row for the duration of the DB
+// transaction so that the balance check and update see the latest balance
+// and concurrent requests against the same wallet are serialised. A debit
+// priced by a fee schedule is charged its fee in the same DB transaction.
+func processTransaction(transaction model.Transaction, s store.Store, hooks ...transactionHook) (*model.Transaction, error) {
+	err := s.Atomic(func(tx store.Store) error {
+		var schedule *model.FeeSchedule
+		if transaction.Type == constant.DEBIT {
+			var err error
+			if schedule, err = feeScheduleFor(tx, constant.DEBIT, transaction.WalletId); err != nil {
+				return err
+			}
+		}
+		transactions := []*model.Transaction{&transaction}
+		var fee, revenue model.Transaction
+		if schedule != nil {
+			fee, revenue = newFeeLegs(*schedule, transaction.WalletId)
+			transactions = append(transactions, &fee, &revenue)
+		}
+		wallets, err := lockWallets(tx, transactions)
+		if err != nil {
+			return err
+		}
+		if err := applyTransactions(tx, wallets, &transaction); err != nil {
+			return err
+		}
+		if schedule != nil {
+			if err := chargeFee(tx, wallets, *schedule, &transaction, &fee, &revenue); err != nil {
+				return err
+			}
+		}
+		for _, hook := range hooks {
+			if err := hook(tx, &transaction); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
 		return nil, err
 	}
-	return &transaction, tx.Commit().Error
+	return &transaction, nil
+}
+
+// postTransactions applies each transaction to its wallet and saves it. All
+// wallets involved are locked up front in ascending ID order, so concurrent
+// operations over several wallets cannot deadlock each other.
+func postTransactions(tx store.Store, transactions ...*model.Transaction) error {
+	wallets, err := lockWallets(tx, transactions)
+	if err != nil {
+		return err
+	}
+	return applyTransactions(tx, wallets, transactions...)
 }
 
-func getUpdatedWalletBalance(wallet model.Wallet, transaction model.Transaction) float32 {
+// applyTransactions posts transactions against wallets already locked by
+// lockWallets, in each wallet's currency, and records them in the journal,
+// the wallets' hash chains and the event outbox. Wallet.Balance is kept as a
+// projection of the journal.
+func applyTransactions(tx store.Store, wallets map[uint]*model.Wallet, transactions ...*model.Transaction) error {
+	for _, transaction := range transactions {
+		wallet := wallets[transaction.WalletId]
+		if err := inWalletCurrency(transaction, *wallet); err != nil {
+			return err
+		}
+		if err := checkWalletStatus(transaction.Type, *wallet); err != nil {
+			return err
+		}
+		if !canProcessTransaction(*transaction, *wallet) {
+			return apperror.ErrInsufficientFunds
+		}
+		if err := checkLimits(tx, *wallet, *transaction); err != nil {
+			return err
+		}
+		wallet.Balance = getUpdatedWalletBalance(*wallet, *transaction)
+		transaction.ClosingBalance = wallet.Balance
+	}
+	for _, walletId := range sortedWalletIds(transactions) {
+		wallet := wallets[walletId]
+		if err := tx.UpdateWalletBalances(wallet); err != nil {
+			return err
+		}
+	}
+	if err := journalTransactions(tx, transactions...); err != nil {
+		return err
+	}
+	for _, transaction := range transactions {
+		if err := tx.CreateTransaction(transaction); err != nil {
+			return err
+		}
+		if err := chainTransaction(tx, wallets[transaction.WalletId], transaction); err != nil {
+			return err
+		}
+		if err := recordTransactionEvent(tx, *transaction); err != nil {
+			return err
+		}
+	}
+	for _, walletId := range sortedWalletIds(transactions) {
+		if err := tx.UpdateWalletChainHash(wallets[walletId]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func lockWallets(tx store.Store, transactions []*model.Transaction) (map[uint]*model.Wallet, error) {
+	wallets := make(map[uint]*model.Wallet)
+	for _, walletId := range sortedWalletIds(transactions) {
+		wallet, err := tx.LockWallet(walletId)
+		if err != nil {
+			return nil, notFoundAs(err, apperror.ErrWalletNotFound)
+		}
+		wallets[walletId] = &wallet
+	}
+	return wallets, nil
+}
+
+func sortedWalletIds(transactions []*model.Transaction) []uint {
+	seen := make(map[uint]bool)
+	var walletIds []uint
+	for _, transaction := range transactions {
+		if !seen[transaction.WalletId] {
+			seen[transaction.WalletId] = true
+			walletIds = append(walletIds, transaction.WalletId)
+		}
+	}
+	sort.Slice(walletIds, func(i, j int) bool { return walletIds[i] < walletIds[j] })
+	return walletIds
+}
+
+func getUpdatedWalletBalance(wallet model.Wallet, transaction model.Transaction) money.Money {
 	if transaction.Type == constant.CREDIT {
-		return wallet.Balance + transaction.Amount
+		return wallet.Balance.Add(transaction.Amount)
 	}
-	return wallet.Balance - transaction.Amount
+	return wallet.Balance.Sub(transaction.Amount)
 }
 
+// canProcessTransaction only lets debits use the available balance, i.e.
+// funds not reserved by holds.
 func canProcessTransaction(transaction model.Transaction, wallet model.Wallet) bool {
 	return constant.CREDIT == transaction.Type ||
-		wallet.Balance-transaction.Amount >= 0
+		!wallet.Balance.Sub(wallet.HeldBalance).Sub(transaction.Amount).IsNegative()
 }
 
 func isValidTransactionType(transaction model.Transaction) bool {
 	return constant.CREDIT == transaction.Type || constant.DEBIT == transaction.Type
 }
 
-func getWalletFor(db *gorm.DB, walletId uint) model.Wallet {
-	wallet := model.Wallet{}
-	wallet.ID = walletId
-	db.First(&wallet)
-	return wallet
-}
-
 func createRevertTransaction(transaction model.Transaction) model.Transaction {
 	updatedTran := model.Transaction{}
 	if transaction.Type == constant.CREDIT {
@@ -123,7 +453,9 @@ func createRevertTransaction(transaction model.Transaction) model.Transaction {
 		updatedTran.Type = constant.CREDIT
 	}
 	updatedTran.Amount = transaction.Amount
+	updatedTran.Currency = transaction.Currency
 	updatedTran.Description = fmt.Sprint("Revert of :", transaction.ID)
 	updatedTran.WalletId = transaction.WalletId
+	updatedTran.ReversedTransactionId = &transaction.ID
 	return updatedTran
 }
@@ -0,0 +1,184 @@
+package handler
+
+import (
+	"encoding/base64"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+	"wallet/app/apperror"
+	"wallet/app/model"
+	"wallet/app/money"
+	"wallet/app/store"
+)
+
+const (
+	defaultPageSize = 50
+	maxPageSize     = 500
+)
+
+// transactionQuery holds the filters and page position parsed from the query
+// string of GET /walletapi/wallet/{wallet_id}/transactions.
+type transactionQuery struct {
+	Limit       int
+	Cursor      *transactionCursor
+	Type        string
+	MinAmount   *money.Money
+	MaxAmount   *money.Money
+	From        *time.Time
+	To          *time.Time
+	Description string
+}
+
+// transactionCursor is the (created_at, id) position of the last transaction
+// on a page. Pages are ordered newest first.
+type transactionCursor struct {
+	CreatedAt time.Time
+	ID        uint
+}
+
+type transactionPage struct {
+	Transactions []model.Transaction `json:"transactions"`
+	NextCursor   string              `json:"next_cursor,omitempty"`
+}
+
+func (c transactionCursor) encode() string {
+	raw := fmt.Sprintf("%d:%d", c.CreatedAt.UnixNano(), c.ID)
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+func decodeCursor(value string) (*transactionCursor, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return nil, fmt.Errorf("invalid cursor")
+	}
+	parts := strings.Split(string(raw), ":")
+	if len(parts) != 2 {
+		return nil, fmt.Errorf("invalid cursor")
+	}
+	nanos, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid cursor")
+	}
+	id, err := strconv.ParseUint(parts[1], 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid cursor")
+	}
+	return &transactionCursor{CreatedAt: time.Unix(0, nanos).UTC(), ID: uint(id)}, nil
+}
+
+func parseTransactionQuery(values url.Values) (transactionQuery, error) {
+	query := transactionQuery{Limit: defaultPageSize}
+	if limit := values.Get("limit"); limit != "" {
+		parsed, err := strconv.Atoi(limit)
+		if err != nil || parsed < 1 || parsed > maxPageSize {
+			return query, fmt.Errorf("limit must be between 1 and %d", maxPageSize)
+		}
+		query.Limit = parsed
+	}
+	if cursor := values.Get("cursor"); cursor != "" {
+		parsed, err := decodeCursor(cursor)
+		if err != nil {
+			return query, err
+		}
+		query.Cursor = parsed
+	}
+	if tranType := values.Get("type"); tranType != "" {
+		if !isValidTransactionType(model.Transaction{Type: tranType}) {
+			return query, apperror.ErrInvalidType
+		}
+		query.Type = tranType
+	}
+	var err error
+	if query.MinAmount, err = parseAmountParam(values, "min_amount"); err != nil {
+		return query, err
+	}
+	if query.MaxAmount, err = parseAmountParam(values, "max_amount"); err != nil {
+		return query, err
+	}
+	if query.From, err = parseTimeParam(values, "from"); err != nil {
+		return query, err
+	}
+	if query.To, err = parseTimeParam(values, "to"); err != nil {
+		return query, err
+	}
+	query.Description = values.Get("description")
+	return query, nil
+}
+
+func parseAmountParam(values url.Values, name string) (*money.Money, error) {
+	value := values.Get(name)
+	if value == "" {
+		return nil, nil
+	}
+	amount, err := money.Parse(value)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s, %s", name, err.Error())
+	}
+	return &amount, nil
+}
+
+// inCurrency rescales the amount filters to the minor unit of the wallet's
+// currency, in which amounts are stored.
+func (q *transactionQuery) inCurrency(currency string) error {
+	exponent, err := money.CurrencyExponent(currency)
+	if err != nil {
+		return err
+	}
+	for _, amount := range []*money.Money{q.MinAmount, q.MaxAmount} {
+		if amount == nil {
+			continue
+		}
+		if *amount, err = amount.Rescale(exponent); err != nil {
+			return fmt.Errorf("invalid amount filter, %s", err.Error())
+		}
+	}
+	return nil
+}
+
+// parseTimeParam accepts an RFC 3339 timestamp or a plain date.
+func parseTimeParam(values url.Values, name string) (*time.Time, error) {
+	value := values.Get(name)
+	if value == "" {
+		return nil, nil
+	}
+	for _, layout := range []string{time.RFC3339Nano, "2006-01-02"} {
+		if parsed, err := time.Parse(layout, value); err == nil {
+			return &parsed, nil
+		}
+	}
+	return nil, fmt.Errorf("invalid %s, expected RFC 3339 timestamp or YYYY-MM-DD", name)
+}
+
+// filter fetches one transaction more than the page holds so page can tell
+// whether another page follows.
+func (q transactionQuery) filter() store.TransactionFilter {
+	filter := store.TransactionFilter{
+		Limit:       q.Limit + 1,
+		Type:        q.Type,
+		MinAmount:   q.MinAmount,
+		MaxAmount:   q.MaxAmount,
+		From:        q.From,
+		To:          q.To,
+		Description: q.Description,
+	}
+	if q.Cursor != nil {
+		filter.After = &store.TransactionCursor{CreatedAt: q.Cursor.CreatedAt, ID: q.Cursor.ID}
+	}
+	return filter
+}
+
+// page trims the extra row fetched by filter and turns it into a cursor.
+func (q transactionQuery) page(transactions []model.Transaction) transactionPage {
+	page := transactionPage{Transactions: transactions}
+	if page.Transactions == nil {
+		page.Transactions = []model.Transaction{}
+	}
+	if len(transactions) > q.Limit {
+		page.Transactions = transactions[:q.Limit]
+		last := page.Transactions[q.Limit-1]
+		page.NextCursor = transactionCursor{CreatedAt: last.CreatedAt, ID: last.ID}.encode()
+	}
+	return page
+}
@@ -1,21 +1,26 @@
 package handler
 
 import (
+	"fmt"
 	"net/http"
 	"strings"
 
 	// "fmt"
 	// "net/http"
 	"testing"
+	"wallet/app/apperror"
+	"wallet/app/constant"
+	"wallet/app/model"
+	"wallet/app/money"
+	"wallet/app/store"
 	"wallet/testutils"
 
-	sqlmock "github.com/DATA-DOG/go-sqlmock"
 	"github.com/stretchr/testify/assert"
 )
 
 func TestCreateTransactionFailsWith400ForWrongData(t *testing.T) {
-	mock := testutils.NewMockDb(t)
-	testServer := testutils.NewTestServer().RegisterHandler("/transaction", mock.Database, CreateTransaction)
+	memoryStore := store.NewMemoryStore()
+	testServer := testutils.NewTestServer().RegisterHandler("/transaction", memoryStore, CreateTransaction)
 	defer testServer.Server.Close()
 	url := testServer.Server.URL + "/transaction"
 	body := strings.NewReader(`{some random data}`)
@@ -25,8 +30,8 @@ func TestCreateTransactionFailsWith400ForWrongData(t *testing.T) {
 }
 
 func TestCreateTransactionErrorForInvalid(t *testing.T) {
-	mock := testutils.NewMockDb(t)
-	testService := testutils.NewTestServer().RegisterHandler("/transaction", mock.Database, CreateTransaction)
+	memoryStore := store.NewMemoryStore()
+	testService := testutils.NewTestServer().RegisterHandler("/transaction", memoryStore, CreateTransaction)
 	defer testService.Server.Close()
 	url := testService.Server.URL + "/transaction"
 	body := strings.NewReader(`{"wallet_id":123, "amount":500, "type":"Invalid"}`)
@@ -36,8 +41,8 @@ func TestCreateTransactionErrorForInvalid(t *testing.T) {
 }
 
 func TestCreateTransactionFailsForInvalidTransactionType(t *testing.T) {
-	mockService := testutils.NewMockDb(t)
-	testService := testutils.NewTestServer().RegisterHandler("/transaction", mockService.Database, CreateTransaction)
+	memoryStore := store.NewMemoryStore()
+	testService := testutils.NewTestServer().RegisterHandler("/transaction", memoryStore, CreateTransaction)
 	defer testService.Server.Close()
 	url := testService.Server.URL + "/transaction"
 	body := strings.NewReader(`{"wallet_id":123, "amount":500, "type":"DUMMY"}`)
@@ -46,17 +51,17 @@ func TestCreateTransactionFailsForInvalidTransactionType(t *testing.T) {
 	assert.NoError(t, err)
 }
 
-func TestCreateTransactionFailsWith400ForInsufficientFund(t *testing.T) {
-	mockDatabase := testutils.NewMockDb(t)
-	testService := testutils.NewTestServer().RegisterHandler("/transaction", mockDatabase.Database, CreateTransaction)
+func TestCreateTransactionFailsWith422ForInsufficientFund(t *testing.T) {
+	memoryStore := store.NewMemoryStore()
+	testService := testutils.NewTestServer().RegisterHandler("/transaction", memoryStore, CreateTransaction)
 	defer testService.Server.Close()
 	url := testService.Server.URL + "/transaction"
-	columns := []string{"ID", "Balance"}
-	mockDatabase.Mock.ExpectQuery("SELECT").WillReturnRows(sqlmock.NewRows(columns).AddRow(1, 200.0))
-	body := strings.NewReader(`{"wallet_id":123, "amount":500, "type":"DEBIT"}`)
+	wallet := newWallet(t, memoryStore, "USD", 200, 0)
+	body := strings.NewReader(fmt.Sprintf(`{"wallet_id":%d, "amount":500, "type":"DEBIT"}`, wallet.ID))
 	resp, err := http.Post(url, "application/json", body)
-	assert.Equal(t, http.StatusInternalServerError, resp.StatusCode)
+	assert.Equal(t, http.StatusUnprocessableEntity, resp.StatusCode)
 	assert.NoError(t, err)
+	assert.Equal(t, money.New(200, 2), getWallet(t, memoryStore, wallet.ID).Balance)
 }
 
 // func TestCreateTransactionFailsForWithQueryFailsTOUpdateWallet(t *testing.T) {
@@ -103,76 +108,117 @@ func TestCreateTransactionFailsWith400ForInsufficientFund(t *testing.T) {
 // }
 
 func TestCreateTransactionSuccessForCREDIT(t *testing.T) {
-	mockDatabase := testutils.NewMockDb(t)
-	testService := testutils.NewTestServer().RegisterHandler("/transaction", mockDatabase.Database, CreateTransaction)
+	memoryStore := store.NewMemoryStore()
+	testService := testutils.NewTestServer().RegisterHandler("/transaction", memoryStore, CreateTransaction)
 	defer testService.Server.Close()
 	url := testService.Server.URL + "/transaction"
-	columns := []string{"ID", "balance"}
-	mockDatabase.Mock.ExpectQuery("SELECT").
-		WillReturnRows(sqlmock.NewRows(columns).AddRow(1, 200.0))
-	mockDatabase.Mock.ExpectBegin()
-	mockDatabase.Mock.ExpectExec("UPDATE").WillReturnResult(sqlmock.NewResult(1, 1))
-	mockDatabase.Mock.ExpectExec("INSERT").WillReturnResult(sqlmock.NewResult(1, 1))
-	mockDatabase.Mock.ExpectExec("UPDATE").WillReturnResult(sqlmock.NewResult(1, 1))
-	mockDatabase.Mock.ExpectCommit()
-	body := strings.NewReader(`{"wallet_id":123, "amount":500, "type":"CREDIT"}`)
+	wallet := newWallet(t, memoryStore, "USD", 20000, 0)
+	body := strings.NewReader(fmt.Sprintf(`{"wallet_id":%d, "amount":500, "type":"CREDIT"}`, wallet.ID))
 	resp, err := http.Post(url, "application/json", body)
+	transaction := model.Transaction{}
+	decodeBody(t, resp, &transaction)
 	assert.Equal(t, http.StatusOK, resp.StatusCode)
 	assert.NoError(t, err)
+	assert.Equal(t, money.New(70000, 2), transaction.ClosingBalance)
+	assert.Equal(t, money.New(70000, 2), getWallet(t, memoryStore, wallet.ID).Balance)
+	assert.Equal(t, transaction.Amount, getTransaction(t, memoryStore, transaction.ID).Amount)
 }
 
 func TestCreateTransactionSuccessForDEBIT(t *testing.T) {
-	mockDatabase := testutils.NewMockDb(t)
-	testService := testutils.NewTestServer().RegisterHandler("/transaction", mockDatabase.Database, CreateTransaction)
+	memoryStore := store.NewMemoryStore()
+	testService := testutils.NewTestServer().RegisterHandler("/transaction", memoryStore, CreateTransaction)
 	defer testService.Server.Close()
 	url := testService.Server.URL + "/transaction"
-	columns := []string{"ID", "balance"}
-	mockDatabase.Mock.ExpectQuery("SELECT").
-		WillReturnRows(sqlmock.NewRows(columns).AddRow(1, 200))
-	mockDatabase.Mock.ExpectBegin()
-	mockDatabase.Mock.ExpectExec("UPDATE").WillReturnResult(sqlmock.NewResult(1, 1))
-	mockDatabase.Mock.ExpectExec("INSERT").WillReturnResult(sqlmock.NewResult(1, 1))
-	mockDatabase.Mock.ExpectExec("UPDATE").WillReturnResult(sqlmock.NewResult(1, 1))
-	mockDatabase.Mock.ExpectCommit()
-	body := strings.NewReader(`{"wallet_id":123, "amount":120, "type":"DEBIT"}`)
+	wallet := newWallet(t, memoryStore, "USD", 20000, 0)
+	body := strings.NewReader(fmt.Sprintf(`{"wallet_id":%d, "amount":120, "type":"DEBIT"}`, wallet.ID))
 	resp, err := http.Post(url, "application/json", body)
 	assert.Equal(t, http.StatusOK, resp.StatusCode)
 	assert.NoError(t, err)
+	assert.Equal(t, money.New(8000, 2), getWallet(t, memoryStore, wallet.ID).Balance)
 }
 
 func TestRevertTransactionSuccessForDEBIT(t *testing.T) {
-	mockDatabase := testutils.NewMockDb(t)
-	testService := testutils.NewTestServer().RegisterHandler("/transaction/{tran_id}", mockDatabase.Database, RevertTransaction)
-	defer testService.Server.Close()
-	url := testService.Server.URL + "/transaction/123"
-	transactionColumns := []string{"id", "amount", "type"}
-	walletColumns := []string{"id", "balance"}
-	mockDatabase.Mock.ExpectQuery("SELECT").
-		WillReturnRows(sqlmock.NewRows(transactionColumns).AddRow(1, 200.0, "DEBIT"))
-
-	mockDatabase.Mock.ExpectQuery("SELECT").
-		WillReturnRows(sqlmock.NewRows(walletColumns).AddRow(1, 200.0))
-
-	mockDatabase.Mock.ExpectBegin()
-	mockDatabase.Mock.ExpectExec("UPDATE").WillReturnResult(sqlmock.NewResult(1, 1))
-	mockDatabase.Mock.ExpectExec("INSERT").WillReturnResult(sqlmock.NewResult(1, 1))
-	mockDatabase.Mock.ExpectExec("UPDATE").WillReturnResult(sqlmock.NewResult(1, 1))
-	mockDatabase.Mock.ExpectCommit()
+	memoryStore := store.NewMemoryStore()
+	testService := testutils.NewTestServer().RegisterHandler("/transaction/{tran_id}", memoryStore, RevertTransaction)
+	defer testService.Server.Close()
+	wallet := newWallet(t, memoryStore, "USD", 20000, 0)
+	original := newTransaction(t, memoryStore, model.Transaction{Amount: money.New(20000, 2), Type: "DEBIT", WalletId: wallet.ID})
+	url := fmt.Sprintf("%s/transaction/%d", testService.Server.URL, original.ID)
 	body := strings.NewReader(`{"wallet_id":123, "amount":500, "type":"DEBIT"}`)
 	resp, err := http.Post(url, "application/json", body)
+	reversal := model.Transaction{}
+	decodeBody(t, resp, &reversal)
 	assert.Equal(t, http.StatusOK, resp.StatusCode)
 	assert.NoError(t, err)
+	assert.Equal(t, "CREDIT", reversal.Type)
+	assert.EqualValues(t, original.ID, *reversal.ReversedTransactionId)
+	assert.Equal(t, money.New(40000, 2), getWallet(t, memoryStore, wallet.ID).Balance)
+	original = getTransaction(t, memoryStore, original.ID)
+	assert.Equal(t, constant.REVERSED, original.ReversalState)
+	assert.Equal(t, money.New(20000, 2), original.RefundedAmount)
+}
+
+func TestRevertTransactionFailsWith409WhenAlreadyReversed(t *testing.T) {
+	memoryStore := store.NewMemoryStore()
+	testService := testutils.NewTestServer().RegisterHandler("/transaction/{tran_id}", memoryStore, RevertTransaction)
+	defer testService.Server.Close()
+	wallet := newWallet(t, memoryStore, "USD", 20000, 0)
+	original := newTransaction(t, memoryStore, model.Transaction{Amount: money.New(20000, 2), Type: "DEBIT", WalletId: wallet.ID, ReversalState: constant.REVERSED})
+	req, _ := http.NewRequest("DELETE", fmt.Sprintf("%s/transaction/%d", testService.Server.URL, original.ID), nil)
+	resp, err := http.DefaultClient.Do(req)
+	assert.Equal(t, http.StatusConflict, resp.StatusCode)
+	assert.NoError(t, err)
+	assert.Equal(t, money.New(20000, 2), getWallet(t, memoryStore, wallet.ID).Balance)
+}
+
+func TestRevertTransactionFailsWith400ForReversal(t *testing.T) {
+	memoryStore := store.NewMemoryStore()
+	testService := testutils.NewTestServer().RegisterHandler("/transaction/{tran_id}", memoryStore, RevertTransaction)
+	defer testService.Server.Close()
+	wallet := newWallet(t, memoryStore, "USD", 20000, 0)
+	original := newTransaction(t, memoryStore, model.Transaction{Amount: money.New(20000, 2), Type: "DEBIT", WalletId: wallet.ID})
+	reversal := newTransaction(t, memoryStore, model.Transaction{Amount: money.New(20000, 2), Type: "CREDIT", WalletId: wallet.ID, ReversedTransactionId: &original.ID})
+	req, _ := http.NewRequest("DELETE", fmt.Sprintf("%s/transaction/%d", testService.Server.URL, reversal.ID), nil)
+	resp, err := http.DefaultClient.Do(req)
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	assert.NoError(t, err)
 }
 
 func TestCreateDebitTransaction(t *testing.T) {
-	mock := testutils.NewMockDb(t)
-	testService := testutils.NewTestServer().RegisterHandler("/transaction", mock.Database, CreateTransaction)
+	memoryStore := store.NewMemoryStore()
+	testService := testutils.NewTestServer().RegisterHandler("/transaction", memoryStore, CreateTransaction)
 	defer testService.Server.Close()
 	url := testService.Server.URL + "/transaction"
 	body := strings.NewReader(`{"wallet_id":123, "amount":500, "type":"DEBIT"}`)
 	resp, err := http.Post(url, "application/json", body)
-	assert.Equal(t, http.StatusInternalServerError, resp.StatusCode)
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+	assert.NoError(t, err)
+}
+
+func TestRevertTransactionFailsWith404ForUnknownTransaction(t *testing.T) {
+	memoryStore := store.NewMemoryStore()
+	testService := testutils.NewTestServer().RegisterHandler("/transaction/{tran_id}", memoryStore, RevertTransaction)
+	defer testService.Server.Close()
+	req, _ := http.NewRequest("DELETE", testService.Server.URL+"/transaction/123", nil)
+
+	resp, err := http.DefaultClient.Do(req)
+
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+	body := errorResponse{}
+	decodeBody(t, resp, &body)
+	assert.Equal(t, "TRANSACTION_NOT_FOUND", body.Code)
+}
+
+func TestProcessTransactionDoesNotPostToUnknownWallets(t *testing.T) {
+	memoryStore := store.NewMemoryStore()
+
+	_, err := processTransaction(model.Transaction{WalletId: 123, Type: constant.CREDIT, Amount: money.New(500, 2)}, memoryStore)
+
+	assert.Equal(t, apperror.ErrWalletNotFound, err)
+	transactions, err := memoryStore.ListTransactions(123, store.TransactionFilter{})
 	assert.NoError(t, err)
+	assert.Empty(t, transactions)
 }
 
 // func TestRevertTransactionSuccess(t *testing.T) {
@@ -207,3 +253,170 @@ func TestCreateDebitTransaction(t *testing.T) {
 // 	// assert.EqualValues(t, 1500, transaction.ClosingBalance)
 // 	// assert.NoError(t, err)
 // }
+
+func TestCreateTransactionRejectsAmountsFinerThanWalletCurrency(t *testing.T) {
+	memoryStore := store.NewMemoryStore()
+	testService := testutils.NewTestServer().RegisterHandler("/transaction", memoryStore, CreateTransaction)
+	defer testService.Server.Close()
+	wallet := newWallet(t, memoryStore, "USD", 0, 0)
+	url := testService.Server.URL + "/transaction"
+	body := strings.NewReader(fmt.Sprintf(`{"wallet_id":%d, "amount":"10.005", "type":"CREDIT"}`, wallet.ID))
+	resp, err := http.Post(url, "application/json", body)
+	response := errorResponse{}
+	decodeBody(t, resp, &response)
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	assert.NoError(t, err)
+	assert.Equal(t, []interface{}{"has more decimal places than USD allows"}, response.Details["amount"])
+	assert.True(t, getWallet(t, memoryStore, wallet.ID).Balance.IsZero())
+}
+
+func TestCreateTransactionReportsEveryInvalidField(t *testing.T) {
+	memoryStore := store.NewMemoryStore()
+	testService := testutils.NewTestServer().RegisterHandler("/transaction", memoryStore, CreateTransaction)
+	defer testService.Server.Close()
+	body := strings.NewReader(`{"amount":"-1", "type":"CREDIT", "currency":"XXX", "description":"` + strings.Repeat("a", 256) + `\u0007"}`)
+
+	resp, err := http.Post(testService.Server.URL+"/transaction", "application/json", body)
+
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	response := errorResponse{}
+	decodeBody(t, resp, &response)
+	assert.Equal(t, "VALIDATION_FAILED", response.Code)
+	assert.Equal(t, map[string]interface{}{
+		"wallet_id":   []interface{}{"is required"},
+		"amount":      []interface{}{"must be positive"},
+		"currency":    []interface{}{"must be a supported ISO 4217 currency code"},
+		"description": []interface{}{"must be at most 255 characters", "must contain printable characters only"},
+	}, response.Details)
+}
+
+func TestCreateTransactionRejectsServerSetFields(t *testing.T) {
+	memoryStore := store.NewMemoryStore()
+	testService := testutils.NewTestServer().RegisterHandler("/transaction", memoryStore, CreateTransaction)
+	defer testService.Server.Close()
+	wallet := newWallet(t, memoryStore, "USD", 0, 0)
+	body := strings.NewReader(fmt.Sprintf(`{"wallet_id":%d, "amount":"10.00", "type":"CREDIT", "ClosingBalance":"1000000.00"}`, wallet.ID))
+
+	resp, err := http.Post(testService.Server.URL+"/transaction", "application/json", body)
+
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	response := errorResponse{}
+	decodeBody(t, resp, &response)
+	assert.Equal(t, []interface{}{"is not allowed"}, response.Details["ClosingBalance"])
+	assert.True(t, getWallet(t, memoryStore, wallet.ID).Balance.IsZero())
+}
+
+func TestCreateTransactionFailsWith400ForCurrencyMismatch(t *testing.T) {
+	memoryStore := store.NewMemoryStore()
+	testService := testutils.NewTestServer().RegisterHandler("/transaction", memoryStore, CreateTransaction)
+	defer testService.Server.Close()
+	wallet := newWallet(t, memoryStore, "JPY", 0, 0)
+	url := testService.Server.URL + "/transaction"
+	body := strings.NewReader(fmt.Sprintf(`{"wallet_id":%d, "amount":"10.00", "currency":"USD", "type":"CREDIT"}`, wallet.ID))
+	resp, err := http.Post(url, "application/json", body)
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	assert.NoError(t, err)
+	assert.True(t, getWallet(t, memoryStore, wallet.ID).Balance.IsZero())
+}
+
+func TestRefundTransactionPartiallyRefundsDebit(t *testing.T) {
+	memoryStore := store.NewMemoryStore()
+	testService := testutils.NewTestServer().RegisterHandler("/transaction/{tran_id}/refund", memoryStore, RefundTransaction)
+	defer testService.Server.Close()
+	wallet := newWallet(t, memoryStore, "USD", 500, 0)
+	original := newTransaction(t, memoryStore, model.Transaction{
+		Amount:         money.New(10000, 2),
+		Type:           "DEBIT",
+		WalletId:       wallet.ID,
+		RefundedAmount: money.New(2000, 2),
+		ReversalState:  constant.PARTIALLY_REVERSED,
+	})
+
+	resp, err := http.Post(fmt.Sprintf("%s/transaction/%d/refund", testService.Server.URL, original.ID), "application/json", strings.NewReader(`{"amount":"30.00"}`))
+
+	refund := model.Transaction{}
+	decodeBody(t, resp, &refund)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.NoError(t, err)
+	assert.Equal(t, "CREDIT", refund.Type)
+	assert.Equal(t, fmt.Sprint("Refund of :", original.ID), refund.Description)
+	assert.EqualValues(t, original.ID, *refund.ReversedTransactionId)
+	assert.Equal(t, money.New(3500, 2), getWallet(t, memoryStore, wallet.ID).Balance)
+	original = getTransaction(t, memoryStore, original.ID)
+	assert.Equal(t, money.New(5000, 2), original.RefundedAmount)
+	assert.Equal(t, constant.PARTIALLY_REVERSED, original.ReversalState)
+}
+
+func TestRefundTransactionFailsWith400WhenExceedingRefundableAmount(t *testing.T) {
+	memoryStore := store.NewMemoryStore()
+	testService := testutils.NewTestServer().RegisterHandler("/transaction/{tran_id}/refund", memoryStore, RefundTransaction)
+	defer testService.Server.Close()
+	wallet := newWallet(t, memoryStore, "USD", 0, 0)
+	original := newTransaction(t, memoryStore, model.Transaction{
+		Amount:         money.New(10000, 2),
+		Type:           "DEBIT",
+		WalletId:       wallet.ID,
+		RefundedAmount: money.New(7000, 2),
+		ReversalState:  constant.PARTIALLY_REVERSED,
+	})
+
+	resp, err := http.Post(fmt.Sprintf("%s/transaction/%d/refund", testService.Server.URL, original.ID), "application/json", strings.NewReader(`{"amount":"30.01"}`))
+
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	assert.NoError(t, err)
+	assert.Equal(t, money.New(7000, 2), getTransaction(t, memoryStore, original.ID).RefundedAmount)
+}
+
+func TestRefundTransactionFailsWith400ForNonPositiveAmount(t *testing.T) {
+	memoryStore := store.NewMemoryStore()
+	testService := testutils.NewTestServer().RegisterHandler("/transaction/{tran_id}/refund", memoryStore, RefundTransaction)
+	defer testService.Server.Close()
+
+	resp, err := http.Post(testService.Server.URL+"/transaction/4/refund", "application/json", strings.NewReader(`{"amount":"0"}`))
+
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	assert.NoError(t, err)
+}
+
+func TestRefundTransactionFailsWith400ForUnknownFields(t *testing.T) {
+	memoryStore := store.NewMemoryStore()
+	testService := testutils.NewTestServer().RegisterHandler("/transaction/{tran_id}/refund", memoryStore, RefundTransaction)
+	defer testService.Server.Close()
+	wallet := newWallet(t, memoryStore, "USD", 0, 0)
+	original := newTransaction(t, memoryStore, model.Transaction{Amount: money.New(10000, 2), Type: "DEBIT", WalletId: wallet.ID})
+
+	resp, err := http.Post(fmt.Sprintf("%s/transaction/%d/refund", testService.Server.URL, original.ID), "application/json", strings.NewReader(`{"amount":"10.00", "currency":"EUR"}`))
+
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	response := errorResponse{}
+	decodeBody(t, resp, &response)
+	assert.Equal(t, []interface{}{"is not allowed"}, response.Details["currency"])
+	assert.True(t, getTransaction(t, memoryStore, original.ID).RefundedAmount.IsZero())
+}
+
+func TestRevertTransactionRevertsRemainingAmountAfterPartialRefund(t *testing.T) {
+	memoryStore := store.NewMemoryStore()
+	testService := testutils.NewTestServer().RegisterHandler("/transaction/{tran_id}", memoryStore, RevertTransaction)
+	defer testService.Server.Close()
+	wallet := newWallet(t, memoryStore, "USD", 0, 0)
+	original := newTransaction(t, memoryStore, model.Transaction{
+		Amount:         money.New(10000, 2),
+		Type:           "DEBIT",
+		WalletId:       wallet.ID,
+		RefundedAmount: money.New(3000, 2),
+		ReversalState:  constant.PARTIALLY_REVERSED,
+	})
+	req, _ := http.NewRequest("DELETE", fmt.Sprintf("%s/transaction/%d", testService.Server.URL, original.ID), nil)
+
+	resp, err := http.DefaultClient.Do(req)
+
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.NoError(t, err)
+	assert.Equal(t, money.New(7000, 2), getWallet(t, memoryStore, wallet.ID).Balance)
+	original = getTransaction(t, memoryStore, original.ID)
+	assert.Equal(t, money.New(10000, 2), original.RefundedAmount)
+	assert.Equal(t, constant.REVERSED, original.ReversalState)
+}
@@ -0,0 +1,272 @@
+package handler
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"wallet/app/apperror"
+	"wallet/app/constant"
+	"wallet/app/model"
+	"wallet/app/money"
+	"wallet/app/store"
+)
+
+func CreateTransfer(s store.Store, w http.ResponseWriter, r *http.Request) {
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		respondError(w, r, apperror.Invalid(err))
+		return
+	}
+	request := createTransferRequest{}
+	if err := decodeStrict(body, &request); err != nil {
+		respondError(w, r, err)
+		return
+	}
+	transfer := request.transfer()
+	if err := validateTransfer(transfer); err != nil {
+		respondError(w, r, apperror.Invalid(err))
+		return
+	}
+	if !authorizeWallet(s, w, r, transfer.FromWalletId) {
+		return
+	}
+	var result *model.Transfer
+	err = s.Atomic(func(tx store.Store) error {
+		var err error
+		if result, err = processTransfer(tx, transfer); err != nil {
+			return err
+		}
+		return recordAudit(tx, r, constant.AUDIT_TRANSFER_CREATED, &result.FromWalletId, nil, result)
+	})
+	if err != nil {
+		respondError(w, r, apperror.Internal("failed to process transfer", err))
+		return
+	}
+	respondSuccess(w, *result)
+}
+
+// createTransferRequest is the body of POST /walletapi/transfer. Its legs,
+// links to reversals and timestamps are only ever set by the server.
+type createTransferRequest struct {
+	FromWalletId uint         `json:"from_wallet_id"`
+	ToWalletId   uint         `json:"to_wallet_id"`
+	Amount       money.Money  `json:"amount"`
+	Currency     string       `json:"currency"`
+	FxRate       *money.Money `json:"fx_rate"`
+	Description  string       `json:"description"`
+}
+
+func (request createTransferRequest) transfer() model.Transfer {
+	return model.Transfer{
+		FromWalletId: request.FromWalletId,
+		ToWalletId:   request.ToWalletId,
+		Amount:       request.Amount,
+		Currency:     request.Currency,
+		FxRate:       request.FxRate,
+		Description:  request.Description,
+	}
+}
+
+func validateTransfer(transfer model.Transfer) error {
+	if transfer.FromWalletId == 0 || transfer.ToWalletId == 0 {
+		return fmt.Errorf("from_wallet_id and to_wallet_id are required")
+	}
+	if transfer.FromWalletId == transfer.ToWalletId {
+		return fmt.Errorf("cannot transfer to the same wallet")
+	}
+	if transfer.Amount.IsNegative() || transfer.Amount.IsZero() {
+		return apperror.ErrInvalidAmount
+	}
+	if transfer.FxRate != nil && (transfer.FxRate.IsNegative() || transfer.FxRate.IsZero()) {
+		return fmt.Errorf("fx_rate must be positive")
+	}
+	return nil
+}
+
+// processTransfer records the transfer and posts its DEBIT and CREDIT legs in
+// a single DB transaction. A fee priced by the TRANSFER schedule of the
+// source wallet's currency is charged to the source wallet on the DEBIT leg.
+func processTransfer(s store.Store, transfer model.Transfer) (*model.Transfer, error) {
+	err := s.Atomic(func(tx store.Store) error {
+		schedule, err := feeScheduleFor(tx, constant.TRANSFER, transfer.FromWalletId)
+		if err != nil {
+			return err
+		}
+		debit, credit := newTransferLegs(transfer)
+		transactions := []*model.Transaction{&debit, &credit}
+		var fee, revenue model.Transaction
+		if schedule != nil {
+			fee, revenue = newFeeLegs(*schedule, transfer.FromWalletId)
+			transactions = append(transactions, &fee, &revenue)
+		}
+		wallets, err := lockWallets(tx, transactions)
+		if err != nil {
+			return err
+		}
+		if err := convertTransfer(&transfer, &credit, *wallets[transfer.FromWalletId], *wallets[transfer.ToWalletId]); err != nil {
+			return err
+		}
+		if err := postTransfer(tx, &transfer, wallets, &debit, &credit); err != nil {
+			return err
+		}
+		if schedule == nil {
+			return nil
+		}
+		if err := chargeFee(tx, wallets, *schedule, &debit, &fee, &revenue); err != nil {
+			return err
+		}
+		transfer.Transactions[0] = debit
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &transfer, nil
+}
+
+func newTransferLegs(transfer model.Transfer) (model.Transaction, model.Transaction) {
+	debit := model.Transaction{
+		Type:        constant.DEBIT,
+		Amount:      transfer.Amount,
+		Description: transfer.Description,
+		WalletId:    transfer.FromWalletId,
+	}
+	credit := model.Transaction{
+		Type:        constant.CREDIT,
+		Amount:      transfer.Amount,
+		Description: transfer.Description,
+		WalletId:    transfer.ToWalletId,
+	}
+	return debit, credit
+}
+
+// convertTransfer settles the transfer in the source wallet's currency and
+// sets the amount credited to the destination wallet, converted with FxRate
+// when the two wallets use different currencies.
+func convertTransfer(transfer *model.Transfer, credit *model.Transaction, from, to model.Wallet) error {
+	if transfer.Currency != "" && transfer.Currency != from.Currency {
+		return apperror.ErrCurrencyMismatch
+	}
+	amount, err := amountInCurrency("amount", transfer.Amount, from.Currency)
+	if err != nil {
+		return err
+	}
+	transfer.Currency = from.Currency
+	transfer.Amount = amount
+	credit.Amount = amount
+	if from.Currency == to.Currency {
+		if transfer.FxRate != nil {
+			return apperror.ErrUnexpectedFxRate
+		}
+		return nil
+	}
+	if transfer.FxRate == nil {
+		return apperror.ErrFxRateRequired
+	}
+	exponent, err := money.CurrencyExponent(to.Currency)
+	if err != nil {
+		return moneyError(err)
+	}
+	converted, err := amount.Convert(*transfer.FxRate, exponent)
+	if err != nil {
+		return moneyError(err)
+	}
+	if converted.IsZero() {
+		return apperror.ErrAmountTooSmall
+	}
+	credit.Amount = converted
+	return nil
+}
+
+// postTransfer records the transfer and posts its legs against wallets
+// already locked by lockWallets.
+func postTransfer(tx store.Store, transfer *model.Transfer, wallets map[uint]*model.Wallet, debit, credit *model.Transaction) error {
+	if err := tx.CreateTransfer(transfer); err != nil {
+		return err
+	}
+	debit.TransferId = &transfer.ID
+	credit.TransferId = &transfer.ID
+	if err := applyTransactions(tx, wallets, debit, credit); err != nil {
+		return err
+	}
+	transfer.Transactions = []model.Transaction{*debit, *credit}
+	return nil
+}
+
+// revertTransfer reverses both legs of a transfer by transferring the amount
+// back as a new transfer. Each leg is reversed for its own amount, so a
+// transfer between currencies is undone at its original rate. The original
+// legs are locked and marked reversed in the same DB transaction, together
+// with the fee charged on the transfer when reverseFee is set.
+func revertTransfer(s store.Store, transferId uint, reverseFee bool) (*model.Transfer, error) {
+	var reversal model.Transfer
+	err := s.Atomic(func(tx store.Store) error {
+		transfer, err := tx.GetTransfer(transferId)
+		if err != nil {
+			return err
+		}
+		legs, err := tx.LockTransferLegs(transferId)
+		if err != nil {
+			return err
+		}
+		if transfer.ReversedTransferId != nil {
+			return apperror.ErrReversalOfReversal
+		}
+		for _, leg := range legs {
+			if err := checkReversible(leg); err != nil {
+				return err
+			}
+		}
+		reversal = model.Transfer{
+			FromWalletId:       transfer.ToWalletId,
+			ToWalletId:         transfer.FromWalletId,
+			Description:        fmt.Sprint("Revert of transfer :", transfer.ID),
+			ReversedTransferId: &transfer.ID,
+		}
+		var debit, credit model.Transaction
+		var fees []model.Transaction
+		var feeReversed []*model.Transaction
+		for i := range legs {
+			if legs[i].Type == constant.CREDIT {
+				debit = createRevertTransaction(legs[i])
+				debit.Description = reversal.Description
+				reversal.Amount = debit.Amount
+				reversal.Currency = debit.Currency
+			} else {
+				credit = createRevertTransaction(legs[i])
+				credit.Description = reversal.Description
+				if reverseFee {
+					if fees, feeReversed, err = feeReversals(tx, legs[i].ID); err != nil {
+						return err
+					}
+				}
+			}
+		}
+		wallets, err := lockWallets(tx, append([]*model.Transaction{&debit, &credit}, feeReversed...))
+		if err != nil {
+			return err
+		}
+		if err := postTransfer(tx, &reversal, wallets, &debit, &credit); err != nil {
+			return err
+		}
+		if len(feeReversed) > 0 {
+			if err := applyTransactions(tx, wallets, feeReversed...); err != nil {
+				return err
+			}
+			if err := markFeesReversed(tx, &credit, fees, feeReversed); err != nil {
+				return err
+			}
+			reversal.Transactions[1] = credit
+		}
+		for i := range legs {
+			if err := markRefunded(tx, &legs[i], legs[i].RefundableAmount); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &reversal, nil
+}
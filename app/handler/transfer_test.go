@@ -0,0 +1,219 @@
+package handler
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+	"wallet/app/constant"
+	"wallet/app/model"
+	"wallet/app/money"
+	"wallet/app/store"
+	"wallet/testutils"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// lockRecorder records the order in which wallets are locked.
+type lockRecorder struct {
+	store.Store
+	locked *[]uint
+}
+
+func (l lockRecorder) LockWallet(id uint) (model.Wallet, error) {
+	*l.locked = append(*l.locked, id)
+	return l.Store.LockWallet(id)
+}
+
+func (l lockRecorder) Atomic(fn func(tx store.Store) error) error {
+	return l.Store.Atomic(func(tx store.Store) error {
+		return fn(lockRecorder{tx, l.locked})
+	})
+}
+
+func TestCreateTransferFailsWith400ForSameWallet(t *testing.T) {
+	memoryStore := store.NewMemoryStore()
+	testService := testutils.NewTestServer().RegisterHandler("/transfer", memoryStore, CreateTransfer)
+	defer testService.Server.Close()
+	body := strings.NewReader(`{"from_wallet_id":3, "to_wallet_id":3, "amount":"10.00"}`)
+	resp, err := http.Post(testService.Server.URL+"/transfer", "application/json", body)
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	assert.NoError(t, err)
+}
+
+func TestCreateTransferFailsWith400ForNonPositiveAmount(t *testing.T) {
+	memoryStore := store.NewMemoryStore()
+	testService := testutils.NewTestServer().RegisterHandler("/transfer", memoryStore, CreateTransfer)
+	defer testService.Server.Close()
+	body := strings.NewReader(`{"from_wallet_id":3, "to_wallet_id":9, "amount":"-10.00"}`)
+	resp, err := http.Post(testService.Server.URL+"/transfer", "application/json", body)
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	assert.NoError(t, err)
+}
+
+func TestCreateTransferRejectsAmountsFinerThanSourceCurrency(t *testing.T) {
+	memoryStore := store.NewMemoryStore()
+	testService := testutils.NewTestServer().RegisterHandler("/transfer", memoryStore, CreateTransfer)
+	defer testService.Server.Close()
+	from := newWallet(t, memoryStore, "USD", 5000, 0)
+	to := newWallet(t, memoryStore, "USD", 0, 0)
+	body := strings.NewReader(fmt.Sprintf(`{"from_wallet_id":%d, "to_wallet_id":%d, "amount":"10.005"}`, from.ID, to.ID))
+
+	resp, err := http.Post(testService.Server.URL+"/transfer", "application/json", body)
+
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	response := errorResponse{}
+	decodeBody(t, resp, &response)
+	assert.Equal(t, "VALIDATION_FAILED", response.Code)
+	assert.Equal(t, []interface{}{"has more decimal places than USD allows"}, response.Details["amount"])
+	assert.Equal(t, money.New(5000, 2), getWallet(t, memoryStore, from.ID).Balance)
+}
+
+func TestCreateTransferRefusesServerOwnedFields(t *testing.T) {
+	memoryStore := store.NewMemoryStore()
+	testService := testutils.NewTestServer().RegisterHandler("/transfer", memoryStore, CreateTransfer)
+	defer testService.Server.Close()
+	from := newWallet(t, memoryStore, "USD", 5000, 0)
+	to := newWallet(t, memoryStore, "USD", 0, 0)
+	for _, field := range []string{`"reversed_transfer_id":1`, `"transactions":[]`, `"ID":7`, `"CreatedAt":"2020-01-01T00:00:00Z"`} {
+		body := strings.NewReader(fmt.Sprintf(`{"from_wallet_id":%d, "to_wallet_id":%d, "amount":"10.00", %s}`, from.ID, to.ID, field))
+
+		resp, err := http.Post(testService.Server.URL+"/transfer", "application/json", body)
+
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode, field)
+	}
+	assert.Equal(t, money.New(5000, 2), getWallet(t, memoryStore, from.ID).Balance)
+}
+
+func TestCreateTransferLocksWalletsInIdOrder(t *testing.T) {
+	memoryStore := store.NewMemoryStore()
+	var locked []uint
+	testService := testutils.NewTestServer().RegisterHandler("/transfer", lockRecorder{memoryStore, &locked}, CreateTransfer)
+	defer testService.Server.Close()
+	to := newWallet(t, memoryStore, "USD", 1000, 0)
+	from := newWallet(t, memoryStore, "USD", 5000, 0)
+	body := strings.NewReader(fmt.Sprintf(`{"from_wallet_id":%d, "to_wallet_id":%d, "amount":"10.00"}`, from.ID, to.ID))
+
+	resp, err := http.Post(testService.Server.URL+"/transfer", "application/json", body)
+
+	transfer := model.Transfer{}
+	decodeBody(t, resp, &transfer)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.NoError(t, err)
+	assert.Equal(t, []uint{to.ID, from.ID}, locked)
+	assert.Len(t, transfer.Transactions, 2)
+	assert.Equal(t, "DEBIT", transfer.Transactions[0].Type)
+	assert.EqualValues(t, from.ID, transfer.Transactions[0].WalletId)
+	assert.Equal(t, money.New(4000, 2), transfer.Transactions[0].ClosingBalance)
+	assert.EqualValues(t, transfer.ID, *transfer.Transactions[0].TransferId)
+	assert.Equal(t, "CREDIT", transfer.Transactions[1].Type)
+	assert.Equal(t, money.New(2000, 2), transfer.Transactions[1].ClosingBalance)
+	assert.EqualValues(t, transfer.ID, *transfer.Transactions[1].TransferId)
+	assert.Equal(t, money.New(4000, 2), getWallet(t, memoryStore, from.ID).Balance)
+	assert.Equal(t, money.New(2000, 2), getWallet(t, memoryStore, to.ID).Balance)
+}
+
+func TestCreateTransferRollsBackOnInsufficientFunds(t *testing.T) {
+	memoryStore := store.NewMemoryStore()
+	testService := testutils.NewTestServer().RegisterHandler("/transfer", memoryStore, CreateTransfer)
+	defer testService.Server.Close()
+	from := newWallet(t, memoryStore, "USD", 500, 0)
+	to := newWallet(t, memoryStore, "USD", 0, 0)
+	body := strings.NewReader(fmt.Sprintf(`{"from_wallet_id":%d, "to_wallet_id":%d, "amount":"10.00"}`, from.ID, to.ID))
+
+	resp, err := http.Post(testService.Server.URL+"/transfer", "application/json", body)
+
+	assert.Equal(t, http.StatusUnprocessableEntity, resp.StatusCode)
+	assert.NoError(t, err)
+	assert.Equal(t, money.New(500, 2), getWallet(t, memoryStore, from.ID).Balance)
+	assert.True(t, getWallet(t, memoryStore, to.ID).Balance.IsZero())
+	transactions, err := memoryStore.ListTransactions(from.ID, store.TransactionFilter{})
+	assert.NoError(t, err)
+	assert.Empty(t, transactions)
+}
+
+func TestCreateTransferConvertsBetweenCurrencies(t *testing.T) {
+	memoryStore := store.NewMemoryStore()
+	testService := testutils.NewTestServer().RegisterHandler("/transfer", memoryStore, CreateTransfer)
+	defer testService.Server.Close()
+	from := newWallet(t, memoryStore, "USD", 5000, 0)
+	to := newWallet(t, memoryStore, "JPY", 100, 0)
+	body := strings.NewReader(fmt.Sprintf(`{"from_wallet_id":%d, "to_wallet_id":%d, "amount":"10.00", "fx_rate":"157.25"}`, from.ID, to.ID))
+
+	resp, err := http.Post(testService.Server.URL+"/transfer", "application/json", body)
+
+	transfer := model.Transfer{}
+	decodeBody(t, resp, &transfer)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.NoError(t, err)
+	assert.Equal(t, "USD", transfer.Currency)
+	assert.Equal(t, "JPY", transfer.Transactions[1].Currency)
+	assert.Equal(t, money.New(1573, 0), transfer.Transactions[1].Amount)
+	assert.Equal(t, money.New(4000, 2), getWallet(t, memoryStore, from.ID).Balance)
+	assert.Equal(t, money.New(1673, 0), getWallet(t, memoryStore, to.ID).Balance)
+}
+
+func TestCreateTransferFailsWith400WithoutFxRateBetweenCurrencies(t *testing.T) {
+	memoryStore := store.NewMemoryStore()
+	testService := testutils.NewTestServer().RegisterHandler("/transfer", memoryStore, CreateTransfer)
+	defer testService.Server.Close()
+	from := newWallet(t, memoryStore, "USD", 5000, 0)
+	to := newWallet(t, memoryStore, "EUR", 100, 0)
+	body := strings.NewReader(fmt.Sprintf(`{"from_wallet_id":%d, "to_wallet_id":%d, "amount":"10.00"}`, from.ID, to.ID))
+
+	resp, err := http.Post(testService.Server.URL+"/transfer", "application/json", body)
+
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	assert.NoError(t, err)
+	assert.Equal(t, money.New(5000, 2), getWallet(t, memoryStore, from.ID).Balance)
+}
+
+func TestRevertTransactionRevertsWholeTransfer(t *testing.T) {
+	memoryStore := store.NewMemoryStore()
+	testService := testutils.NewTestServer().RegisterHandler("/transaction/{tran_id}", memoryStore, RevertTransaction)
+	defer testService.Server.Close()
+	from := newWallet(t, memoryStore, "USD", 5000, 0)
+	to := newWallet(t, memoryStore, "USD", 1000, 0)
+	original, err := processTransfer(memoryStore, model.Transfer{FromWalletId: from.ID, ToWalletId: to.ID, Amount: money.New(1000, 2)})
+	assert.NoError(t, err)
+	debit, credit := original.Transactions[0], original.Transactions[1]
+	req, _ := http.NewRequest("DELETE", fmt.Sprintf("%s/transaction/%d", testService.Server.URL, credit.ID), nil)
+
+	resp, err := http.DefaultClient.Do(req)
+
+	transfer := model.Transfer{}
+	decodeBody(t, resp, &transfer)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.NoError(t, err)
+	assert.EqualValues(t, to.ID, transfer.FromWalletId)
+	assert.EqualValues(t, from.ID, transfer.ToWalletId)
+	assert.Equal(t, fmt.Sprint("Revert of transfer :", original.ID), transfer.Description)
+	assert.EqualValues(t, original.ID, *transfer.ReversedTransferId)
+	assert.EqualValues(t, credit.ID, *transfer.Transactions[0].ReversedTransactionId)
+	assert.EqualValues(t, debit.ID, *transfer.Transactions[1].ReversedTransactionId)
+	assert.Equal(t, money.New(5000, 2), getWallet(t, memoryStore, from.ID).Balance)
+	assert.Equal(t, money.New(1000, 2), getWallet(t, memoryStore, to.ID).Balance)
+	assert.Equal(t, constant.REVERSED, getTransaction(t, memoryStore, debit.ID).ReversalState)
+	assert.Equal(t, constant.REVERSED, getTransaction(t, memoryStore, credit.ID).ReversalState)
+}
+
+func TestRevertTransactionFailsWith409ForReversedTransfer(t *testing.T) {
+	memoryStore := store.NewMemoryStore()
+	testService := testutils.NewTestServer().RegisterHandler("/transaction/{tran_id}", memoryStore, RevertTransaction)
+	defer testService.Server.Close()
+	from := newWallet(t, memoryStore, "USD", 5000, 0)
+	to := newWallet(t, memoryStore, "USD", 1000, 0)
+	original, err := processTransfer(memoryStore, model.Transfer{FromWalletId: from.ID, ToWalletId: to.ID, Amount: money.New(1000, 2)})
+	assert.NoError(t, err)
+	_, err = revertTransfer(memoryStore, original.ID, false)
+	assert.NoError(t, err)
+	req, _ := http.NewRequest("DELETE", fmt.Sprintf("%s/transaction/%d", testService.Server.URL, original.Transactions[1].ID), nil)
+
+	resp, err := http.DefaultClient.Do(req)
+
+	assert.Equal(t, http.StatusConflict, resp.StatusCode)
+	assert.NoError(t, err)
+	assert.Equal(t, money.New(1000, 2), getWallet(t, memoryStore, to.ID).Balance)
+}
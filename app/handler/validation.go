@@ -0,0 +1,102 @@
+package handler
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+	"wallet/app/apperror"
+	"wallet/app/money"
+)
+
+// maxDescriptionLength is the size of the description column.
+const maxDescriptionLength = 255
+
+// fieldErrors collects the rules each field of a request breaks, so clients
+// learn about all of them at once rather than one per request.
+type fieldErrors map[string][]string
+
+func (f fieldErrors) add(field, message string) {
+	f[field] = append(f[field], message)
+}
+
+// err is a VALIDATION_FAILED error whose details list the messages of each
+// field, or nil when every rule held.
+func (f fieldErrors) err() error {
+	if len(f) == 0 {
+		return nil
+	}
+	details := make(map[string]interface{}, len(f))
+	for field, messages := range f {
+		details[field] = messages
+	}
+	return apperror.ErrValidation.WithDetails(details)
+}
+
+// decodeStrict decodes a JSON request body into v, refusing fields v does not
+// have. Unknown fields and values of the wrong type are reported against
+// their field.
+func decodeStrict(body []byte, v interface{}) error {
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	decoder.DisallowUnknownFields()
+	err := decoder.Decode(v)
+	if err == nil {
+		return nil
+	}
+	var typeErr *json.UnmarshalTypeError
+	if errors.As(err, &typeErr) && typeErr.Field != "" {
+		return fieldErrors{typeErr.Field: {"must be a " + typeErr.Type.String()}}.err()
+	}
+	if field := strings.TrimPrefix(err.Error(), "json: unknown field "); field != err.Error() {
+		return fieldErrors{strings.Trim(field, `"`): {"is not allowed"}}.err()
+	}
+	return apperror.BadRequest(err.Error())
+}
+
+// checkAmount requires a positive amount with no more decimal places than
+// currency allows. The precision is only checked once currency is known.
+func (f fieldErrors) checkAmount(field string, amount money.Money, currency string) {
+	if amount.IsNegative() || amount.IsZero() {
+		f.add(field, "must be positive")
+		return
+	}
+	if currency == "" {
+		return
+	}
+	exponent, err := money.CurrencyExponent(currency)
+	if err != nil {
+		return
+	}
+	if _, err := amount.Rescale(exponent); err == money.ErrPrecision {
+		f.add(field, precisionMessage(currency))
+	}
+}
+
+func (f fieldErrors) checkCurrency(field, currency string) {
+	if currency == "" {
+		return
+	}
+	if _, err := money.CurrencyExponent(currency); err != nil {
+		f.add(field, "must be a supported ISO 4217 currency code")
+	}
+}
+
+// checkDescription limits descriptions to the column size and to printable
+// characters, keeping control characters out of statements and logs.
+func (f fieldErrors) checkDescription(field, description string) {
+	if !utf8.ValidString(description) {
+		f.add(field, "must be valid UTF-8")
+		return
+	}
+	if utf8.RuneCountInString(description) > maxDescriptionLength {
+		f.add(field, "must be at most 255 characters")
+	}
+	for _, c := range description {
+		if !unicode.IsPrint(c) {
+			f.add(field, "must contain printable characters only")
+			return
+		}
+	}
+}
@@ -1,41 +1,122 @@
 package handler
 
 import (
+	"encoding/json"
+	"io"
 	"net/http"
 	"strconv"
+	"wallet/app/apperror"
+	"wallet/app/constant"
 	"wallet/app/model"
+	"wallet/app/money"
+	"wallet/app/store"
 
 	"github.com/gorilla/mux"
-	"github.com/jinzhu/gorm"
 )
 
-func CreateWallet(db *gorm.DB, w http.ResponseWriter) {
-	wallet := model.Wallet{}
-	db.Create(&wallet)
+type createWalletRequest struct {
+	Currency string `json:"currency"`
+	OwnerId  *uint  `json:"owner_id"`
+}
+
+// CreateWallet opens a wallet in the ISO 4217 currency given in the body, or
+// in constant.DEFAULT_CURRENCY when there is no body. The currency cannot be
+// changed later. The wallet belongs to the caller; admins open wallets for
+// the owner given as owner_id.
+func CreateWallet(s store.Store, w http.ResponseWriter, r *http.Request) {
+	request := createWalletRequest{}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil && err != io.EOF {
+		respondError(w, r, apperror.Invalid(err))
+		return
+	}
+	ownerId, ok := callerOwnerId(r)
+	if request.OwnerId != nil {
+		if !isAdmin(r) && (!ok || *request.OwnerId != ownerId) {
+			respondError(w, r, apperror.ErrForbidden.WithMessage("cannot open a wallet for another owner"))
+			return
+		}
+		ownerId, ok = *request.OwnerId, true
+	}
+	if !ok {
+		respondError(w, r, apperror.BadRequest("owner_id is required"))
+		return
+	}
+	if _, err := s.GetOwner(ownerId); err != nil {
+		respondError(w, r, apperror.Internal("failed while fetching owner information", notFoundAs(err, apperror.ErrOwnerNotFound)))
+		return
+	}
+	if request.Currency == "" {
+		request.Currency = constant.DEFAULT_CURRENCY
+	}
+	exponent, err := money.CurrencyExponent(request.Currency)
+	if err != nil {
+		respondError(w, r, apperror.ErrUnknownCurrency.WithMessage("unsupported currency "+request.Currency))
+		return
+	}
+	wallet := model.Wallet{
+		OwnerId:     &ownerId,
+		Currency:    request.Currency,
+		Balance:     money.New(0, exponent),
+		HeldBalance: money.New(0, exponent),
+	}
+	err = s.Atomic(func(tx store.Store) error {
+		if err := openWallet(tx, &wallet); err != nil {
+			return err
+		}
+		return recordAudit(tx, r, constant.AUDIT_WALLET_CREATED, &wallet.ID, nil, wallet)
+	})
+	if err != nil {
+		respondError(w, r, apperror.Internal("failed to create wallet", err))
+		return
+	}
 	respondSuccess(w, wallet)
 }
 
-func GetWallet(db *gorm.DB, w http.ResponseWriter, r *http.Request) {
+func GetWallet(s store.Store, w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	walletId, err := strconv.ParseInt(vars["wallet_id"], 10, 64)
 	if err != nil {
-		respondError(w, http.StatusBadRequest, "invalid wallet id")
+		respondError(w, r, apperror.BadRequest("invalid wallet id"))
+		return
+	}
+	wallet, err := s.GetWallet(uint(walletId))
+	if err != nil {
+		respondError(w, r, apperror.Internal("failed while fetching wallet information", notFoundAs(err, apperror.ErrWalletNotFound)))
+		return
+	}
+	if !canAccessWallet(r, wallet) {
+		respondError(w, r, apperror.ErrForbidden.WithMessage("access to wallet denied"))
 		return
 	}
-	wallet := model.Wallet{}
-	wallet.ID = uint(walletId)
-	db.First(&wallet)
 	respondSuccess(w, wallet)
 }
 
-func GetWalletTransactions(db *gorm.DB, w http.ResponseWriter, r *http.Request) {
+func GetWalletTransactions(s store.Store, w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	walletId, err := strconv.ParseInt(vars["wallet_id"], 10, 64)
 	if err != nil {
-		respondError(w, http.StatusBadRequest, "invalid wallet id")
+		respondError(w, r, apperror.BadRequest("invalid wallet id"))
+		return
+	}
+	wallet, ok := authorizedWallet(s, w, r, uint(walletId))
+	if !ok {
+		return
+	}
+	query, err := parseTransactionQuery(r.URL.Query())
+	if err != nil {
+		respondError(w, r, apperror.Invalid(err))
+		return
+	}
+	if query.MinAmount != nil || query.MaxAmount != nil {
+		if err := query.inCurrency(wallet.Currency); err != nil {
+			respondError(w, r, apperror.Invalid(err))
+			return
+		}
+	}
+	transactions, err := s.ListTransactions(wallet.ID, query.filter())
+	if err != nil {
+		respondError(w, r, apperror.Internal("failed while fetching transactions", err))
 		return
 	}
-	var transactions []model.Transaction
-	db.Order("created_at desc").Where("wallet_id=?", walletId).Find(&transactions)
-	respondSuccess(w, transactions)
+	respondSuccess(w, query.page(transactions))
 }
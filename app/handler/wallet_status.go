@@ -0,0 +1,191 @@
+package handler
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"wallet/app/apperror"
+	"wallet/app/constant"
+	"wallet/app/model"
+	"wallet/app/store"
+
+	"github.com/gorilla/mux"
+)
+
+// walletStatusAudits names the audit action of each wallet status change.
+var walletStatusAudits = map[string]string{
+	constant.WALLET_ACTIVE: constant.AUDIT_WALLET_UNFROZEN,
+	constant.WALLET_FROZEN: constant.AUDIT_WALLET_FROZEN,
+	constant.WALLET_CLOSED: constant.AUDIT_WALLET_CLOSED,
+}
+
+// walletTransitions lists the statuses each status may move to. CLOSED is
+// final.
+var walletTransitions = map[string][]string{
+	constant.WALLET_ACTIVE: {constant.WALLET_FROZEN, constant.WALLET_CLOSED},
+	constant.WALLET_FROZEN: {constant.WALLET_ACTIVE, constant.WALLET_CLOSED},
+}
+
+// checkWalletStatus refuses everything on closed wallets and debits on
+// frozen ones.
+func checkWalletStatus(transactionType string, wallet model.Wallet) error {
+	switch wallet.Status {
+	case constant.WALLET_CLOSED:
+		return apperror.ErrWalletClosed
+	case constant.WALLET_FROZEN:
+		if transactionType == constant.DEBIT {
+			return apperror.ErrWalletFrozen
+		}
+	}
+	return nil
+}
+
+func checkStatusTransition(from, to string) error {
+	for _, status := range walletTransitions[from] {
+		if status == to {
+			return nil
+		}
+	}
+	return apperror.ErrInvalidStatusTransition
+}
+
+// walletStatusRequest carries the reason code for a change of status. Only
+// closing accepts TransferToWalletId, the wallet to receive the remaining
+// balance.
+type walletStatusRequest struct {
+	Reason             string `json:"reason"`
+	TransferToWalletId *uint  `json:"transfer_to_wallet_id"`
+}
+
+func FreezeWallet(s store.Store, w http.ResponseWriter, r *http.Request) {
+	changeWalletStatus(s, w, r, constant.WALLET_FROZEN)
+}
+
+func UnfreezeWallet(s store.Store, w http.ResponseWriter, r *http.Request) {
+	changeWalletStatus(s, w, r, constant.WALLET_ACTIVE)
+}
+
+// CloseWallet closes a wallet for good. Its balance must be zero unless
+// transfer_to_wallet_id nominates a wallet of the same currency to receive
+// it, and it must have no active holds.
+func CloseWallet(s store.Store, w http.ResponseWriter, r *http.Request) {
+	changeWalletStatus(s, w, r, constant.WALLET_CLOSED)
+}
+
+// changeWalletStatus serves the status endpoints. Status changes are
+// compliance actions and need the admin scope.
+func changeWalletStatus(s store.Store, w http.ResponseWriter, r *http.Request, status string) {
+	if !requireAdmin(w, r) {
+		return
+	}
+	walletId, err := strconv.ParseInt(mux.Vars(r)["wallet_id"], 10, 64)
+	if err != nil {
+		respondError(w, r, apperror.BadRequest("invalid wallet id"))
+		return
+	}
+	request := walletStatusRequest{}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		respondError(w, r, apperror.Invalid(err))
+		return
+	}
+	if err := validateStatusRequest(request, uint(walletId), status); err != nil {
+		respondError(w, r, apperror.Invalid(err))
+		return
+	}
+	var wallet *model.Wallet
+	err = s.Atomic(func(tx store.Store) error {
+		before, err := tx.GetWallet(uint(walletId))
+		if err != nil {
+			return notFoundAs(err, apperror.ErrWalletNotFound)
+		}
+		if wallet, err = processStatusChange(tx, uint(walletId), status, request); err != nil {
+			return err
+		}
+		return recordAudit(tx, r, walletStatusAudits[status], &wallet.ID, before, wallet)
+	})
+	if err != nil {
+		respondError(w, r, apperror.Internal("failed to change wallet status", err))
+		return
+	}
+	respondSuccess(w, *wallet)
+}
+
+func validateStatusRequest(request walletStatusRequest, walletId uint, status string) error {
+	if request.Reason == "" {
+		return fmt.Errorf("reason is required")
+	}
+	if len(request.Reason) > 64 {
+		return fmt.Errorf("reason must be at most 64 characters")
+	}
+	if request.TransferToWalletId != nil {
+		if status != constant.WALLET_CLOSED {
+			return fmt.Errorf("transfer_to_wallet_id is only allowed when closing a wallet")
+		}
+		if *request.TransferToWalletId == walletId {
+			return fmt.Errorf("cannot transfer to the same wallet")
+		}
+	}
+	return nil
+}
+
+// processStatusChange moves the wallet to status. When closing with a
+// nominated wallet, both wallets are locked in ID order and the remaining
+// balance is transferred before the wallet is closed, in the same DB
+// transaction. A frozen wallet refuses that debit, so it can only be closed
+// at a zero balance.
+func processStatusChange(s store.Store, walletId uint, status string, request walletStatusRequest) (*model.Wallet, error) {
+	var wallet *model.Wallet
+	err := s.Atomic(func(tx store.Store) error {
+		var transfer *model.Transfer
+		debit := model.Transaction{WalletId: walletId}
+		var credit model.Transaction
+		locks := []*model.Transaction{&debit}
+		if status == constant.WALLET_CLOSED && request.TransferToWalletId != nil {
+			transfer = &model.Transfer{
+				FromWalletId: walletId,
+				ToWalletId:   *request.TransferToWalletId,
+				Description:  fmt.Sprint("Closing balance of wallet :", walletId),
+			}
+			debit, credit = newTransferLegs(*transfer)
+			locks = append(locks, &credit)
+		}
+		wallets, err := lockWallets(tx, locks)
+		if err != nil {
+			return err
+		}
+		wallet = wallets[walletId]
+		if err := checkStatusTransition(wallet.Status, status); err != nil {
+			return err
+		}
+		if status == constant.WALLET_CLOSED {
+			if !wallet.HeldBalance.IsZero() {
+				return apperror.ErrActiveHolds
+			}
+			if transfer != nil && !wallet.Balance.IsZero() && !wallet.Balance.IsNegative() {
+				transfer.Amount = wallet.Balance
+				debit.Amount = wallet.Balance
+				if err := convertTransfer(transfer, &credit, *wallet, *wallets[transfer.ToWalletId]); err != nil {
+					return err
+				}
+				if err := postTransfer(tx, transfer, wallets, &debit, &credit); err != nil {
+					return err
+				}
+				wallet.AvailableBalance = wallet.Balance.Sub(wallet.HeldBalance)
+			}
+			if !wallet.Balance.IsZero() {
+				return apperror.ErrBalanceNotZero
+			}
+		}
+		wallet.Status = status
+		wallet.StatusReason = request.Reason
+		if err := tx.UpdateWalletStatus(wallet); err != nil {
+			return err
+		}
+		return recordEvent(tx, walletStatusEvents[status], wallet.ID, wallet)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return wallet, nil
+}
@@ -0,0 +1,133 @@
+package handler
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+	"wallet/app/apperror"
+	"wallet/app/constant"
+	"wallet/app/model"
+	"wallet/app/money"
+	"wallet/app/store"
+	"wallet/testutils"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func setWalletStatus(t *testing.T, s store.Store, id uint, status string) {
+	wallet := getWallet(t, s, id)
+	wallet.Status = status
+	assert.NoError(t, s.UpdateWalletStatus(&wallet))
+}
+
+func TestFreezeWalletRecordsReason(t *testing.T) {
+	memoryStore := store.NewMemoryStore()
+	testService := testutils.NewTestServer().RegisterHandler("/wallet/{wallet_id}/freeze", memoryStore, FreezeWallet)
+	defer testService.Server.Close()
+	wallet := newWallet(t, memoryStore, "USD", 1000, 0)
+
+	resp, err := http.Post(fmt.Sprintf("%s/wallet/%d/freeze", testService.Server.URL, wallet.ID), "application/json", strings.NewReader(`{"reason":"AML_REVIEW"}`))
+
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	stored := getWallet(t, memoryStore, wallet.ID)
+	assert.Equal(t, constant.WALLET_FROZEN, stored.Status)
+	assert.Equal(t, "AML_REVIEW", stored.StatusReason)
+}
+
+func TestFreezeWalletFailsWith400WithoutReason(t *testing.T) {
+	memoryStore := store.NewMemoryStore()
+	testService := testutils.NewTestServer().RegisterHandler("/wallet/{wallet_id}/freeze", memoryStore, FreezeWallet)
+	defer testService.Server.Close()
+	wallet := newWallet(t, memoryStore, "USD", 1000, 0)
+
+	resp, err := http.Post(fmt.Sprintf("%s/wallet/%d/freeze", testService.Server.URL, wallet.ID), "application/json", strings.NewReader(`{}`))
+
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	assert.Equal(t, constant.WALLET_ACTIVE, getWallet(t, memoryStore, wallet.ID).Status)
+}
+
+func TestUnfreezeWalletFailsWith409ForActiveWallet(t *testing.T) {
+	memoryStore := store.NewMemoryStore()
+	testService := testutils.NewTestServer().RegisterHandler("/wallet/{wallet_id}/unfreeze", memoryStore, UnfreezeWallet)
+	defer testService.Server.Close()
+	wallet := newWallet(t, memoryStore, "USD", 1000, 0)
+
+	resp, err := http.Post(fmt.Sprintf("%s/wallet/%d/unfreeze", testService.Server.URL, wallet.ID), "application/json", strings.NewReader(`{"reason":"REVIEW_CLEARED"}`))
+
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusConflict, resp.StatusCode)
+}
+
+func TestFrozenWalletRefusesDebitsButAcceptsCredits(t *testing.T) {
+	memoryStore := store.NewMemoryStore()
+	wallet := newWallet(t, memoryStore, "USD", 1000, 0)
+	setWalletStatus(t, memoryStore, wallet.ID, constant.WALLET_FROZEN)
+
+	_, err := processTransaction(model.Transaction{WalletId: wallet.ID, Type: constant.DEBIT, Amount: money.New(100, 2)}, memoryStore)
+	assert.Equal(t, apperror.ErrWalletFrozen, err)
+	_, err = processTransaction(model.Transaction{WalletId: wallet.ID, Type: constant.CREDIT, Amount: money.New(100, 2)}, memoryStore)
+	assert.NoError(t, err)
+	_, err = processAuthorization(memoryStore, model.Hold{WalletId: wallet.ID, Amount: money.New(100, 2)})
+	assert.Equal(t, apperror.ErrWalletFrozen, err)
+}
+
+func TestClosedWalletRefusesTransactions(t *testing.T) {
+	memoryStore := store.NewMemoryStore()
+	testService := testutils.NewTestServer().RegisterHandler("/transaction", memoryStore, CreateTransaction)
+	defer testService.Server.Close()
+	wallet := newWallet(t, memoryStore, "USD", 0, 0)
+	setWalletStatus(t, memoryStore, wallet.ID, constant.WALLET_CLOSED)
+
+	resp, err := http.Post(testService.Server.URL+"/transaction", "application/json", strings.NewReader(fmt.Sprintf(`{"wallet_id":%d, "type":"CREDIT", "amount":"10.00"}`, wallet.ID)))
+
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusConflict, resp.StatusCode)
+	assert.Equal(t, money.New(0, 2), getWallet(t, memoryStore, wallet.ID).Balance)
+}
+
+func TestCloseWalletFailsWith409WithRemainingBalance(t *testing.T) {
+	memoryStore := store.NewMemoryStore()
+	testService := testutils.NewTestServer().RegisterHandler("/wallet/{wallet_id}/close", memoryStore, CloseWallet)
+	defer testService.Server.Close()
+	wallet := newWallet(t, memoryStore, "USD", 1000, 0)
+
+	resp, err := http.Post(fmt.Sprintf("%s/wallet/%d/close", testService.Server.URL, wallet.ID), "application/json", strings.NewReader(`{"reason":"CUSTOMER_REQUEST"}`))
+
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusConflict, resp.StatusCode)
+	assert.Equal(t, constant.WALLET_ACTIVE, getWallet(t, memoryStore, wallet.ID).Status)
+}
+
+func TestCloseWalletMovesRemainingBalanceToNominatedWallet(t *testing.T) {
+	memoryStore := store.NewMemoryStore()
+	testService := testutils.NewTestServer().RegisterHandler("/wallet/{wallet_id}/close", memoryStore, CloseWallet)
+	defer testService.Server.Close()
+	wallet := newWallet(t, memoryStore, "USD", 1000, 0)
+	nominated := newWallet(t, memoryStore, "USD", 500, 0)
+
+	resp, err := http.Post(fmt.Sprintf("%s/wallet/%d/close", testService.Server.URL, wallet.ID), "application/json",
+		strings.NewReader(fmt.Sprintf(`{"reason":"CUSTOMER_REQUEST", "transfer_to_wallet_id":%d}`, nominated.ID)))
+
+	assert.NoError(t, err)
+	closed := model.Wallet{}
+	decodeBody(t, resp, &closed)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, constant.WALLET_CLOSED, closed.Status)
+	assert.Equal(t, money.New(0, 2), closed.Balance)
+	assert.Equal(t, money.New(0, 2), getWallet(t, memoryStore, wallet.ID).Balance)
+	assert.Equal(t, money.New(1500, 2), getWallet(t, memoryStore, nominated.ID).Balance)
+}
+
+func TestCloseWalletFailsWith409WithActiveHolds(t *testing.T) {
+	memoryStore := store.NewMemoryStore()
+	wallet := newWallet(t, memoryStore, "USD", 1000, 200)
+	nominated := newWallet(t, memoryStore, "USD", 0, 0)
+
+	_, err := processStatusChange(memoryStore, wallet.ID, constant.WALLET_CLOSED, walletStatusRequest{Reason: "CUSTOMER_REQUEST", TransferToWalletId: &nominated.ID})
+
+	assert.Equal(t, apperror.ErrActiveHolds, err)
+	assert.Equal(t, money.New(0, 2), getWallet(t, memoryStore, nominated.ID).Balance)
+}
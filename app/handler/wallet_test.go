@@ -2,21 +2,24 @@ package handler
 
 import (
 	"encoding/json"
+	"fmt"
 	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
+	"wallet/app/constant"
 	"wallet/app/model"
+	"wallet/app/money"
+	"wallet/app/store"
 	"wallet/testutils"
 
-	"github.com/DATA-DOG/go-sqlmock"
-	"github.com/jinzhu/gorm"
 	"github.com/stretchr/testify/assert"
 )
 
 func TestGetWalletFailsForNonNumericWalletId(t *testing.T) {
-	mockService := testutils.NewMockDb(t)
-	testService := testutils.NewTestServer().RegisterHandler("/wallet/{wallet_id}", mockService.Database, GetWallet)
+	memoryStore := store.NewMemoryStore()
+	testService := testutils.NewTestServer().RegisterHandler("/wallet/{wallet_id}", memoryStore, GetWallet)
 	defer testService.Server.Close()
 	url := testService.Server.URL + "/wallet/abc"
 	resp, err := 	http.Get(url)
@@ -25,29 +28,40 @@ func TestGetWalletFailsForNonNumericWalletId(t *testing.T) {
 }
 
 func TestGetWalletFailsForInvalidWalletId(t *testing.T) {
+	memoryStore := store.NewMemoryStore()
+	testService := testutils.NewTestServer().RegisterHandler("/wallet/{wallet_id}", memoryStore, GetWallet)
+	defer testService.Server.Close()
+
+	resp, err := http.Get(testService.Server.URL + "/wallet/123")
+
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+	body := errorResponse{}
+	decodeBody(t, resp, &body)
+	assert.Equal(t, "WALLET_NOT_FOUND", body.Code)
 }
 
 func TestGetWalletSuccess(t *testing.T) {
-	mockService := testutils.NewMockDb(t)
-	testService := testutils.NewTestServer().RegisterHandler("/wallet/{wallet_id}", mockService.Database, GetWallet)
+	memoryStore := store.NewMemoryStore()
+	testService := testutils.NewTestServer().RegisterHandler("/wallet/{wallet_id}", memoryStore, GetWallet)
 	defer testService.Server.Close()
-	url := testService.Server.URL + "/wallet/123"
-	columns := []string{"ID", "balance"}
-	mockService.Mock.ExpectQuery("SELECT").WillReturnRows(sqlmock.NewRows(columns).AddRow(123, 400))
+	seeded := newWallet(t, memoryStore, "USD", 40000, 0)
+	url := fmt.Sprintf("%s/wallet/%d", testService.Server.URL, seeded.ID)
 
 	resp, err := http.Get(url)
 	wallet := model.Wallet{}
 	body, _ := ioutil.ReadAll(resp.Body)
 	json.Unmarshal(body, &wallet)
 	assert.Equal(t, http.StatusOK, resp.StatusCode)
-	assert.EqualValues(t, 123, wallet.ID)
-	assert.EqualValues(t, 400, wallet.Balance)
+	assert.EqualValues(t, seeded.ID, wallet.ID)
+	assert.Equal(t, money.New(40000, 2), wallet.Balance)
+	assert.Equal(t, money.New(40000, 2), wallet.AvailableBalance)
 	assert.NoError(t, err)
 }
 
 func TestGetWalletTransactionsFailsForInvalidTransaction(t *testing.T) {
-	mockService := testutils.NewMockDb(t)
-	testService := testutils.NewTestServer().RegisterHandler("/wallet/{wallet_id}/transactions", mockService.Database, GetWalletTransactions)
+	memoryStore := store.NewMemoryStore()
+	testService := testutils.NewTestServer().RegisterHandler("/wallet/{wallet_id}/transactions", memoryStore, GetWalletTransactions)
 	defer testService.Server.Close()
 	url := testService.Server.URL + "/wallet/abc/transactions"
 	resp, err := http.Get(url)
@@ -56,29 +70,125 @@ func TestGetWalletTransactionsFailsForInvalidTransaction(t *testing.T) {
 }
 
 func TestGetWalletTransactionsTransaction(t *testing.T) {
-	mockService := testutils.NewMockDb(t)
-	testService := testutils.NewTestServer().RegisterHandler("/wallet/{wallet_id}/transactions", mockService.Database, GetWalletTransactions)
+	memoryStore := store.NewMemoryStore()
+	testService := testutils.NewTestServer().RegisterHandler("/wallet/{wallet_id}/transactions", memoryStore, GetWalletTransactions)
 	defer testService.Server.Close()
-	url := testService.Server.URL + "/wallet/1/transactions"
-	columns := []string{"ID", "amount", "type", "closingbalance", "description"}
-	mockService.Mock.ExpectQuery("SELECT").WillReturnRows(sqlmock.NewRows(columns).AddRow(1, 200.0, "CREDIT", 200.0, "credit test"))
+	wallet := newWallet(t, memoryStore, "USD", 20000, 0)
+	newTransaction(t, memoryStore, model.Transaction{Amount: money.New(20000, 2), Type: "CREDIT", ClosingBalance: money.New(20000, 2), Description: "credit test", WalletId: wallet.ID})
+	url := fmt.Sprintf("%s/wallet/%d/transactions", testService.Server.URL, wallet.ID)
 	resp, _ := http.Get(url)
 	assert.Equal(t, http.StatusOK, resp.StatusCode)
-	transactions := []model.Transaction{}
+	page := transactionPage{}
 	defer resp.Body.Close()
 	body, _ := ioutil.ReadAll(resp.Body)
-	json.Unmarshal(body, &transactions)
+	json.Unmarshal(body, &page)
+	transactions := page.Transactions
 	assert.EqualValues(t, "CREDIT", transactions[0].Type)
-	assert.EqualValues(t, 200, transactions[0].Amount)
+	assert.Equal(t, money.New(20000, 2), transactions[0].Amount)
 	assert.EqualValues(t, "credit test", transactions[0].Description)
+	assert.Empty(t, page.NextCursor)
+}
+
+func TestGetWalletTransactionsReturnsNextCursor(t *testing.T) {
+	memoryStore := store.NewMemoryStore()
+	testService := testutils.NewTestServer().RegisterHandler("/wallet/{wallet_id}/transactions", memoryStore, GetWalletTransactions)
+	defer testService.Server.Close()
+	wallet := newWallet(t, memoryStore, "USD", 0, 0)
+	other := newWallet(t, memoryStore, "USD", 0, 0)
+	for _, amount := range []int64{500, 1000, 1500, 2000} {
+		newTransaction(t, memoryStore, model.Transaction{Amount: money.New(amount, 2), Type: constant.DEBIT, WalletId: wallet.ID})
+	}
+	newTransaction(t, memoryStore, model.Transaction{Amount: money.New(3000, 2), Type: constant.CREDIT, WalletId: wallet.ID})
+	newTransaction(t, memoryStore, model.Transaction{Amount: money.New(3000, 2), Type: constant.DEBIT, WalletId: other.ID})
+	resp, err := http.Get(fmt.Sprintf("%s/wallet/%d/transactions?limit=2&type=DEBIT&min_amount=10", testService.Server.URL, wallet.ID))
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	page := transactionPage{}
+	defer resp.Body.Close()
+	body, _ := ioutil.ReadAll(resp.Body)
+	json.Unmarshal(body, &page)
+	assert.Len(t, page.Transactions, 2)
+	assert.Equal(t, money.New(2000, 2), page.Transactions[0].Amount)
+	assert.Equal(t, money.New(1500, 2), page.Transactions[1].Amount)
+	last := page.Transactions[1]
+	assert.Equal(t, transactionCursor{CreatedAt: last.CreatedAt, ID: last.ID}.encode(), page.NextCursor)
+}
+
+// walletLoadCounter counts the wallets loaded through it.
+type walletLoadCounter struct {
+	store.Store
+	loads *int
+}
+
+func (c walletLoadCounter) GetWallet(id uint) (model.Wallet, error) {
+	*c.loads++
+	return c.Store.GetWallet(id)
+}
+
+func TestGetWalletTransactionsLoadsTheWalletOnce(t *testing.T) {
+	memoryStore := store.NewMemoryStore()
+	loads := 0
+	testService := testutils.NewTestServer().RegisterHandler("/wallet/{wallet_id}/transactions", walletLoadCounter{memoryStore, &loads}, GetWalletTransactions)
+	defer testService.Server.Close()
+	wallet := newWallet(t, memoryStore, "JPY", 0, 0)
+	newTransaction(t, memoryStore, model.Transaction{Amount: money.New(500, 0), Type: constant.DEBIT, WalletId: wallet.ID})
+
+	resp, err := http.Get(fmt.Sprintf("%s/wallet/%d/transactions?min_amount=100&max_amount=1000", testService.Server.URL, wallet.ID))
+
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	page := transactionPage{}
+	decodeBody(t, resp, &page)
+	assert.Len(t, page.Transactions, 1)
+	assert.Equal(t, 1, loads)
+}
+
+func TestGetWalletTransactionsUsesCursor(t *testing.T) {
+	memoryStore := store.NewMemoryStore()
+	testService := testutils.NewTestServer().RegisterHandler("/wallet/{wallet_id}/transactions", memoryStore, GetWalletTransactions)
+	defer testService.Server.Close()
+	wallet := newWallet(t, memoryStore, "USD", 0, 0)
+	oldest := newTransaction(t, memoryStore, model.Transaction{Amount: money.New(500, 2), Type: constant.DEBIT, WalletId: wallet.ID})
+	newest := newTransaction(t, memoryStore, model.Transaction{Amount: money.New(1000, 2), Type: constant.DEBIT, WalletId: wallet.ID})
+	url := fmt.Sprintf("%s/wallet/%d/transactions", testService.Server.URL, wallet.ID)
+
+	cursor := transactionCursor{CreatedAt: newest.CreatedAt, ID: newest.ID}.encode()
+	resp, err := http.Get(url + "?cursor=" + cursor)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	page := transactionPage{}
+	decodeBody(t, resp, &page)
+	assert.Len(t, page.Transactions, 1)
+	assert.Equal(t, oldest.ID, page.Transactions[0].ID)
+
+	cursor = transactionCursor{CreatedAt: oldest.CreatedAt, ID: oldest.ID}.encode()
+	resp, err = http.Get(url + "?cursor=" + cursor)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	body, _ := ioutil.ReadAll(resp.Body)
+	assert.JSONEq(t, `{"transactions":[]}`, string(body))
+}
+
+func TestGetWalletTransactionsRejectsInvalidQuery(t *testing.T) {
+	memoryStore := store.NewMemoryStore()
+	testService := testutils.NewTestServer().RegisterHandler("/wallet/{wallet_id}/transactions", memoryStore, GetWalletTransactions)
+	defer testService.Server.Close()
+	wallet := newWallet(t, memoryStore, "JPY", 0, 0)
+	for _, query := range []string{"limit=0", "limit=501", "cursor=bogus", "type=OTHER", "min_amount=abc", "max_amount=0.5", "from=yesterday"} {
+		resp, err := http.Get(fmt.Sprintf("%s/wallet/%d/transactions?%s", testService.Server.URL, wallet.ID, query))
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode, query)
+	}
 }
 
 func TestCreateWallet(t *testing.T) {
-	mockparams := testutils.NewMockDb(t)
+	memoryStore := store.NewMemoryStore()
+	owner := newOwner(t, memoryStore, "owner@example.com")
 	writer := httptest.NewRecorder()
 	type args struct {
-		db *gorm.DB
+		s  store.Store
 		w  httptest.ResponseRecorder
+		r  *http.Request
 	}
 	tests := []struct {
 		name string
@@ -87,14 +197,15 @@ func TestCreateWallet(t *testing.T) {
 		{
 			"create_wallet_success",
 			args{
-				mockparams.Database,
+				memoryStore,
 				*writer,
+				asOwner(httptest.NewRequest(http.MethodPost, "/wallet", nil), owner.ID),
 			},
 		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			CreateWallet(tt.args.db, &tt.args.w)
+			CreateWallet(tt.args.s, &tt.args.w, tt.args.r)
 			if !isEqual(tt.args.w) {
 				t.Errorf("Error while creating wallet")
 			}
@@ -102,6 +213,30 @@ func TestCreateWallet(t *testing.T) {
 	}
 }
 
+func TestCreateWalletWithCurrency(t *testing.T) {
+	memoryStore := store.NewMemoryStore()
+	owner := newOwner(t, memoryStore, "owner@example.com")
+	writer := httptest.NewRecorder()
+	CreateWallet(memoryStore, writer, asOwner(httptest.NewRequest(http.MethodPost, "/wallet", strings.NewReader(`{"currency": "JPY"}`)), owner.ID))
+	assert.Equal(t, http.StatusOK, writer.Code)
+	wallet := model.Wallet{}
+	json.Unmarshal(writer.Body.Bytes(), &wallet)
+	assert.Equal(t, "JPY", wallet.Currency)
+	assert.Equal(t, owner.ID, *wallet.OwnerId)
+	assert.Equal(t, money.New(0, 0), wallet.Balance)
+	assert.Equal(t, "JPY", getWallet(t, memoryStore, wallet.ID).Currency)
+}
+
+func TestCreateWalletRejectsUnknownCurrency(t *testing.T) {
+	memoryStore := store.NewMemoryStore()
+	owner := newOwner(t, memoryStore, "owner@example.com")
+	writer := httptest.NewRecorder()
+	CreateWallet(memoryStore, writer, asOwner(httptest.NewRequest(http.MethodPost, "/wallet", strings.NewReader(`{"currency": "XYZ"}`)), owner.ID))
+	assert.Equal(t, http.StatusBadRequest, writer.Code)
+	_, err := memoryStore.GetWallet(owner.ID + 1)
+	assert.Equal(t, store.ErrNotFound, err)
+}
+
 func isEqual(res httptest.ResponseRecorder) bool {
 	wallet := model.Wallet{}
 	body, err := ioutil.ReadAll(res.Body)
@@ -112,7 +247,7 @@ func isEqual(res httptest.ResponseRecorder) bool {
 	if res.Result().StatusCode != 200 {
 		return false
 	}
-	if wallet.Balance != 0 {
+	if !wallet.Balance.IsZero() {
 		return false
 	}
 	return true
@@ -0,0 +1,234 @@
+package handler
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+	"wallet/app/apperror"
+	"wallet/app/constant"
+	"wallet/app/model"
+	"wallet/app/store"
+
+	"github.com/gorilla/mux"
+)
+
+var eventTypes = map[string]bool{
+	"*":                                 true,
+	constant.EVENT_WALLET_CREATED:       true,
+	constant.EVENT_WALLET_FROZEN:        true,
+	constant.EVENT_WALLET_UNFROZEN:      true,
+	constant.EVENT_WALLET_CLOSED:        true,
+	constant.EVENT_TRANSACTION_CREATED:  true,
+	constant.EVENT_TRANSACTION_REVERTED: true,
+}
+
+// CreateWebhookSubscription registers a URL for events. A secret is
+// generated unless one is given; either way it is only returned here.
+func CreateWebhookSubscription(s store.Store, w http.ResponseWriter, r *http.Request) {
+	if !requireAdmin(w, r) {
+		return
+	}
+	subscription := model.WebhookSubscription{}
+	if err := json.NewDecoder(r.Body).Decode(&subscription); err != nil {
+		respondError(w, r, apperror.Invalid(err))
+		return
+	}
+	if err := validateWebhookSubscription(subscription); err != nil {
+		respondError(w, r, apperror.Invalid(err))
+		return
+	}
+	if subscription.Secret == "" {
+		secret := make([]byte, 32)
+		if _, err := rand.Read(secret); err != nil {
+			respondError(w, r, apperror.Internal("failed to generate secret", err))
+			return
+		}
+		subscription.Secret = hex.EncodeToString(secret)
+	}
+	subscription.Active = true
+	err := s.Atomic(func(tx store.Store) error {
+		if err := tx.CreateWebhookSubscription(&subscription); err != nil {
+			return err
+		}
+		return recordAudit(tx, r, constant.AUDIT_WEBHOOK_CREATED, nil, nil, withoutSecret(subscription))
+	})
+	if err != nil {
+		respondError(w, r, apperror.Internal("failed to create webhook subscription", err))
+		return
+	}
+	respondSuccess(w, subscription)
+}
+
+func validateWebhookSubscription(subscription model.WebhookSubscription) error {
+	target, err := url.Parse(subscription.URL)
+	if err != nil || (target.Scheme != "http" && target.Scheme != "https") || target.Host == "" {
+		return fmt.Errorf("url must be an absolute http or https URL")
+	}
+	if len(subscription.URL) > 2048 {
+		return fmt.Errorf("url must be at most 2048 characters")
+	}
+	if len(subscription.EventTypes) == 0 {
+		return fmt.Errorf("event_types is required")
+	}
+	for _, eventType := range subscription.EventTypes {
+		if !eventTypes[eventType] {
+			return fmt.Errorf("unknown event type %s", eventType)
+		}
+	}
+	if len(subscription.Secret) > 255 {
+		return fmt.Errorf("secret must be at most 255 characters")
+	}
+	return nil
+}
+
+func ListWebhookSubscriptions(s store.Store, w http.ResponseWriter, r *http.Request) {
+	if !requireAdmin(w, r) {
+		return
+	}
+	subscriptions, err := s.ListWebhookSubscriptions()
+	if err != nil {
+		respondError(w, r, apperror.Internal("failed while fetching webhook subscriptions", err))
+		return
+	}
+	if subscriptions == nil {
+		subscriptions = []model.WebhookSubscription{}
+	}
+	for i := range subscriptions {
+		subscriptions[i] = withoutSecret(subscriptions[i])
+	}
+	respondSuccess(w, subscriptions)
+}
+
+// DeleteWebhookSubscription deactivates a subscription. Its pending
+// deliveries are dead-lettered on their next attempt.
+func DeleteWebhookSubscription(s store.Store, w http.ResponseWriter, r *http.Request) {
+	if !requireAdmin(w, r) {
+		return
+	}
+	subscriptionId, err := strconv.ParseInt(mux.Vars(r)["subscription_id"], 10, 64)
+	if err != nil {
+		respondError(w, r, apperror.BadRequest("invalid subscription id"))
+		return
+	}
+	subscription, err := s.GetWebhookSubscription(uint(subscriptionId))
+	if err == store.ErrNotFound {
+		respondError(w, r, apperror.ErrSubscriptionNotFound)
+		return
+	}
+	if err != nil {
+		respondError(w, r, apperror.Internal("failed while fetching webhook subscription", err))
+		return
+	}
+	subscription.Secret = ""
+	before := subscription
+	subscription.Active = false
+	err = s.Atomic(func(tx store.Store) error {
+		if err := tx.UpdateWebhookSubscription(&subscription); err != nil {
+			return err
+		}
+		return recordAudit(tx, r, constant.AUDIT_WEBHOOK_DELETED, nil, before, subscription)
+	})
+	if err != nil {
+		respondError(w, r, apperror.Internal("failed to delete webhook subscription", err))
+		return
+	}
+	respondSuccess(w, subscription)
+}
+
+// withoutSecret keeps subscription secrets out of responses and the audit
+// log.
+func withoutSecret(subscription model.WebhookSubscription) model.WebhookSubscription {
+	subscription.Secret = ""
+	return subscription
+}
+
+// ListWebhookDeliveries lists deliveries by status, the dead-letter list by
+// default.
+func ListWebhookDeliveries(s store.Store, w http.ResponseWriter, r *http.Request) {
+	if !requireAdmin(w, r) {
+		return
+	}
+	status := r.URL.Query().Get("status")
+	if status == "" {
+		status = constant.DELIVERY_DEAD
+	}
+	if status != constant.DELIVERY_PENDING && status != constant.DELIVERY_DELIVERED && status != constant.DELIVERY_DEAD {
+		respondError(w, r, apperror.BadRequest("status must be PENDING, DELIVERED or DEAD"))
+		return
+	}
+	deliveries, err := s.ListWebhookDeliveries(status)
+	if err != nil {
+		respondError(w, r, apperror.Internal("failed while fetching webhook deliveries", err))
+		return
+	}
+	if deliveries == nil {
+		deliveries = []model.WebhookDelivery{}
+	}
+	respondSuccess(w, deliveries)
+}
+
+// ReplayEvent delivers an event again to every active subscription wanting
+// it, or only to the one given as ?subscription_id=, including deliveries
+// that were dead-lettered or already made.
+func ReplayEvent(s store.Store, w http.ResponseWriter, r *http.Request) {
+	if !requireAdmin(w, r) {
+		return
+	}
+	eventId, err := strconv.ParseInt(mux.Vars(r)["event_id"], 10, 64)
+	if err != nil {
+		respondError(w, r, apperror.BadRequest("invalid event id"))
+		return
+	}
+	var only *uint
+	if value := r.URL.Query().Get("subscription_id"); value != "" {
+		subscriptionId, err := strconv.ParseUint(value, 10, 64)
+		if err != nil {
+			respondError(w, r, apperror.BadRequest("invalid subscription id"))
+			return
+		}
+		id := uint(subscriptionId)
+		only = &id
+	}
+	event, err := s.GetEvent(uint(eventId))
+	if err == store.ErrNotFound {
+		respondError(w, r, apperror.ErrEventNotFound)
+		return
+	}
+	if err != nil {
+		respondError(w, r, apperror.Internal("failed while fetching event", err))
+		return
+	}
+	subscriptions, err := s.ListWebhookSubscriptions()
+	if err != nil {
+		respondError(w, r, apperror.Internal("failed while fetching webhook subscriptions", err))
+		return
+	}
+	deliveries := []model.WebhookDelivery{}
+	err = s.Atomic(func(tx store.Store) error {
+		now := time.Now()
+		for _, subscription := range subscriptions {
+			if !subscription.Active || !subscription.Subscribes(event.Type) || (only != nil && *only != subscription.ID) {
+				continue
+			}
+			if err := queueDelivery(tx, event.ID, subscription.ID, now); err != nil {
+				return err
+			}
+			delivery, err := tx.GetWebhookDelivery(event.ID, subscription.ID)
+			if err != nil {
+				return err
+			}
+			deliveries = append(deliveries, delivery)
+		}
+		return recordAudit(tx, r, constant.AUDIT_EVENT_REPLAYED, nil, nil, deliveries)
+	})
+	if err != nil {
+		respondError(w, r, apperror.Internal("failed to replay event", err))
+		return
+	}
+	respondSuccess(w, deliveries)
+}
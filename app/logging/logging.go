@@ -0,0 +1,89 @@
+// Package logging builds the structured, leveled logger the API writes its
+// logs with.
+package logging
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+	"time"
+)
+
+// Field names shared by every log record that concerns a request, wallet or
+// transaction, so their records can be searched for together.
+const (
+	RequestID     = "request_id"
+	WalletID      = "wallet_id"
+	TransactionID = "transaction_id"
+)
+
+// masked replaces the values of sensitive fields.
+const masked = "[MASKED]"
+
+// sensitiveFields are the field names, or name suffixes, whose values are
+// never written.
+var sensitiveFields = []string{"authorization", "password", "secret", "signature", "token"}
+
+// New returns a logger writing records of level and above to w, as JSON or,
+// when format is "text", as key=value pairs. Unknown levels mean info.
+func New(w io.Writer, level, format string) *slog.Logger {
+	options := &slog.HandlerOptions{Level: ParseLevel(level), ReplaceAttr: mask}
+	if strings.EqualFold(format, "text") {
+		return slog.New(slog.NewTextHandler(w, options))
+	}
+	return slog.New(slog.NewJSONHandler(w, options))
+}
+
+// ParseLevel reads debug, info, warn or error, defaulting to info.
+func ParseLevel(level string) slog.Level {
+	var parsed slog.Level
+	if err := parsed.UnmarshalText([]byte(level)); err != nil {
+		return slog.LevelInfo
+	}
+	return parsed
+}
+
+func mask(groups []string, attr slog.Attr) slog.Attr {
+	key := strings.ToLower(attr.Key)
+	for _, field := range sensitiveFields {
+		if strings.HasSuffix(key, field) {
+			return slog.String(attr.Key, masked)
+		}
+	}
+	return attr
+}
+
+type contextKey struct{}
+
+// NewContext returns a copy of ctx carrying logger.
+func NewContext(ctx context.Context, logger *slog.Logger) context.Context {
+	return context.WithValue(ctx, contextKey{}, logger)
+}
+
+// FromContext returns the logger of a request, which carries its fields.
+func FromContext(ctx context.Context) (*slog.Logger, bool) {
+	logger, ok := ctx.Value(contextKey{}).(*slog.Logger)
+	return logger, ok
+}
+
+// GormLogger writes the SQL gorm logs through a logger. Statements are
+// logged with their placeholders only: bound values may be sensitive.
+type GormLogger struct {
+	Logger *slog.Logger
+}
+
+func (g GormLogger) Print(values ...interface{}) {
+	if len(values) < 2 {
+		return
+	}
+	source := slog.Any("source", values[1])
+	if values[0] == "sql" && len(values) >= 6 {
+		duration, _ := values[2].(time.Duration)
+		g.Logger.Info("sql", source, slog.Any("statement", values[3]), slog.Float64("duration_ms", float64(duration.Microseconds())/1000), slog.Any("rows", values[5]))
+		return
+	}
+	// gorm logs nothing else but errors
+	g.Logger.Error("sql error", source, slog.String("error", fmt.Sprint(values[2:]...)))
+}
@@ -0,0 +1,78 @@
+package logging
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewWritesJSONRecordsAtOrAboveLevel(t *testing.T) {
+	var buf bytes.Buffer
+	logger := New(&buf, "warn", "json")
+
+	logger.Info("skipped")
+	logger.Warn("kept", WalletID, 7)
+
+	record := map[string]interface{}{}
+	assert.NoError(t, json.Unmarshal(buf.Bytes(), &record))
+	assert.Equal(t, "kept", record["msg"])
+	assert.Equal(t, "WARN", record["level"])
+	assert.Equal(t, float64(7), record[WalletID])
+}
+
+func TestNewWritesTextRecords(t *testing.T) {
+	var buf bytes.Buffer
+	logger := New(&buf, "debug", "text")
+
+	logger.Debug("served", RequestID, "abc")
+
+	assert.Contains(t, buf.String(), "level=DEBUG msg=served request_id=abc")
+}
+
+func TestParseLevelDefaultsToInfo(t *testing.T) {
+	assert.Equal(t, slog.LevelDebug, ParseLevel("debug"))
+	assert.Equal(t, slog.LevelError, ParseLevel("ERROR"))
+	assert.Equal(t, slog.LevelInfo, ParseLevel(""))
+	assert.Equal(t, slog.LevelInfo, ParseLevel("verbose"))
+}
+
+func TestNewMasksSensitiveFields(t *testing.T) {
+	var buf bytes.Buffer
+	logger := New(&buf, "info", "json")
+
+	logger.Info("delivering", "Authorization", "Bearer abc", "webhook_secret", "s3cret", "password", "hunter2", WalletID, 7)
+
+	assert.NotContains(t, buf.String(), "Bearer abc")
+	assert.NotContains(t, buf.String(), "s3cret")
+	assert.NotContains(t, buf.String(), "hunter2")
+	assert.Equal(t, 3, strings.Count(buf.String(), masked))
+	assert.Contains(t, buf.String(), `"wallet_id":7`)
+}
+
+func TestContextCarriesLogger(t *testing.T) {
+	_, ok := FromContext(context.Background())
+	assert.False(t, ok)
+
+	logger := New(&bytes.Buffer{}, "info", "json")
+	fromContext, ok := FromContext(NewContext(context.Background(), logger))
+
+	assert.True(t, ok)
+	assert.Equal(t, logger, fromContext)
+}
+
+func TestGormLoggerLeavesOutBoundValues(t *testing.T) {
+	var buf bytes.Buffer
+	gormLogger := GormLogger{Logger: New(&buf, "info", "json")}
+
+	gormLogger.Print("sql", "store/gorm.go:42", 1500*time.Microsecond, "UPDATE webhook_subscriptions SET secret = ?", []interface{}{"s3cret"}, int64(1))
+
+	assert.Contains(t, buf.String(), `"statement":"UPDATE webhook_subscriptions SET secret = ?"`)
+	assert.Contains(t, buf.String(), `"duration_ms":1.5`)
+	assert.NotContains(t, buf.String(), "s3cret")
+}
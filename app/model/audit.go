@@ -0,0 +1,38 @@
+package model
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// AuditRecord is an entry of the append-only audit log, written in the same
+// DB transaction as the change it records. The store never updates or
+// deletes records, so they have no UpdatedAt or DeletedAt; the table itself
+// does not prevent it, see store.AuditStore.
+type AuditRecord struct {
+	ID        uint            `gorm:"primary_key" json:"id"`
+	CreatedAt time.Time       `gorm:"index" json:"created_at"`
+	Actor     string          `gorm:"size:255;not null;index" json:"actor"`
+	Action    string          `gorm:"size:64;not null;index" json:"action"`
+	WalletId  *uint           `gorm:"index" json:"wallet_id,omitempty"`
+	RequestId string          `gorm:"size:64" json:"request_id,omitempty"`
+	SourceIP  string          `gorm:"size:64" json:"source_ip"`
+	Before    json.RawMessage `gorm:"-" json:"before"`
+	After     json.RawMessage `gorm:"-" json:"after"`
+	// BeforeText and AfterText keep the JSON of the resource before and after
+	// the change, null when it did not exist.
+	BeforeText string `gorm:"column:before_state;type:TEXT" json:"-"`
+	AfterText  string `gorm:"column:after_state;type:TEXT" json:"-"`
+}
+
+func (a *AuditRecord) BeforeSave() error {
+	a.BeforeText = string(a.Before)
+	a.AfterText = string(a.After)
+	return nil
+}
+
+func (a *AuditRecord) AfterFind() error {
+	a.Before = json.RawMessage(a.BeforeText)
+	a.After = json.RawMessage(a.AfterText)
+	return nil
+}
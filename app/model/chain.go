@@ -0,0 +1,53 @@
+package model
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"wallet/app/money"
+)
+
+// ComputeHash is the hex SHA-256 of the transaction's canonical contents:
+// the fields fixed when it is created, including PrevHash, as JSON. The
+// refund fields change as the transaction is refunded and are left out.
+// CreatedAt counts in whole seconds, as the database keeps it.
+func (t Transaction) ComputeHash() (string, error) {
+	exponent, err := money.CurrencyExponent(t.Currency)
+	if err != nil {
+		return "", err
+	}
+	content, err := json.Marshal(struct {
+		ID                    uint        `json:"id"`
+		CreatedAt             int64       `json:"created_at"`
+		WalletId              uint        `json:"wallet_id"`
+		Type                  string      `json:"type"`
+		Amount                money.Money `json:"amount"`
+		Currency              string      `json:"currency"`
+		ClosingBalance        money.Money `json:"closing_balance"`
+		Description           string      `json:"description"`
+		TransferId            *uint       `json:"transfer_id"`
+		ReversedTransactionId *uint       `json:"reversed_transaction_id"`
+		JournalEntryId        *uint       `json:"journal_entry_id"`
+		FeeOfTransactionId    *uint       `json:"fee_of_transaction_id"`
+		PrevHash              string      `json:"prev_hash"`
+	}{
+		ID:                    t.ID,
+		CreatedAt:             t.CreatedAt.Unix(),
+		WalletId:              t.WalletId,
+		Type:                  t.Type,
+		Amount:                inCurrency(t.Amount, exponent),
+		Currency:              t.Currency,
+		ClosingBalance:        inCurrency(t.ClosingBalance, exponent),
+		Description:           t.Description,
+		TransferId:            t.TransferId,
+		ReversedTransactionId: t.ReversedTransactionId,
+		JournalEntryId:        t.JournalEntryId,
+		FeeOfTransactionId:    t.FeeOfTransactionId,
+		PrevHash:              t.PrevHash,
+	})
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(content)
+	return hex.EncodeToString(sum[:]), nil
+}
@@ -0,0 +1,78 @@
+package model
+
+import (
+	"encoding/json"
+	"strings"
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+// Event is a domain event written to the outbox in the same DB transaction
+// as the change it describes. Dispatched is set once deliveries have been
+// queued for the subscriptions it matches.
+type Event struct {
+	gorm.Model
+	Type       string          `gorm:"size:64;not null;index" json:"type"`
+	WalletId   uint            `gorm:"index" json:"wallet_id"`
+	Data       json.RawMessage `gorm:"-" json:"data"`
+	Dispatched bool            `gorm:"not null;index" json:"-"`
+	// DataText keeps Data, the JSON of the wallet or transaction concerned.
+	DataText string `gorm:"column:data;type:TEXT" json:"-"`
+}
+
+func (e *Event) BeforeSave() error {
+	e.DataText = string(e.Data)
+	return nil
+}
+
+func (e *Event) AfterFind() error {
+	e.Data = json.RawMessage(e.DataText)
+	return nil
+}
+
+// WebhookSubscription delivers events of EventTypes to URL. Secret signs
+// every delivery and is only shown when the subscription is created.
+type WebhookSubscription struct {
+	gorm.Model
+	URL        string   `gorm:"size:2048;not null" json:"url"`
+	EventTypes []string `gorm:"-" json:"event_types"`
+	Secret     string   `gorm:"size:255;not null" json:"secret,omitempty"`
+	Active     bool     `gorm:"not null" json:"active"`
+	// EventTypesText keeps EventTypes as a comma separated list.
+	EventTypesText string `gorm:"column:event_types;size:255;not null" json:"-"`
+}
+
+func (w *WebhookSubscription) BeforeSave() error {
+	w.EventTypesText = strings.Join(w.EventTypes, ",")
+	return nil
+}
+
+func (w *WebhookSubscription) AfterFind() error {
+	w.EventTypes = strings.Split(w.EventTypesText, ",")
+	return nil
+}
+
+// Subscribes reports whether events of eventType go to the subscription.
+func (w WebhookSubscription) Subscribes(eventType string) bool {
+	for _, subscribed := range w.EventTypes {
+		if subscribed == eventType || subscribed == "*" {
+			return true
+		}
+	}
+	return false
+}
+
+// WebhookDelivery is one event on its way to one subscription. A delivery
+// still failing after the last attempt is DEAD, which puts it on the
+// dead-letter list until the event is replayed.
+type WebhookDelivery struct {
+	gorm.Model
+	EventId        uint      `gorm:"not null;unique_index:idx_webhook_deliveries_event_subscription" json:"event_id"`
+	SubscriptionId uint      `gorm:"not null;unique_index:idx_webhook_deliveries_event_subscription" json:"subscription_id"`
+	Status         string    `gorm:"size:16;not null;index" json:"status"`
+	Attempts       int       `gorm:"not null" json:"attempts"`
+	NextAttemptAt  time.Time `gorm:"index" json:"next_attempt_at"`
+	ResponseCode   int       `json:"response_code,omitempty"`
+	LastError      string    `gorm:"size:255" json:"last_error,omitempty"`
+}
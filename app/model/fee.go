@@ -0,0 +1,73 @@
+package model
+
+import (
+	"encoding/json"
+	"wallet/app/money"
+
+	"github.com/jinzhu/gorm"
+)
+
+// FeeSchedule prices the fee charged on transactions of TransactionType in
+// Currency: DEBIT for withdrawals and TRANSFER for transfers, charged to the
+// source wallet. The first tier covering the amount gives a flat fee plus a
+// percentage in basis points, and Min and Max cap the result. Fees are
+// credited to RevenueWalletId, a wallet in the same currency.
+type FeeSchedule struct {
+	gorm.Model
+	TransactionType string       `gorm:"size:16;not null;unique_index:idx_fee_schedules_type_currency" json:"transaction_type"`
+	Currency        string       `gorm:"type:CHAR(3);not null;unique_index:idx_fee_schedules_type_currency" json:"currency"`
+	Min             *money.Money `gorm:"type:BIGINT" json:"min"`
+	Max             *money.Money `gorm:"type:BIGINT" json:"max"`
+	RevenueWalletId uint         `gorm:"not null" json:"revenue_wallet_id"`
+	Tiers           []FeeTier    `gorm:"-" json:"tiers"`
+	// TiersText keeps Tiers as JSON, in which amounts keep their exponent.
+	TiersText string `gorm:"column:tiers;type:TEXT" json:"-"`
+}
+
+// FeeTier applies to amounts up to UpTo. Tiers are in ascending order and
+// the last may leave UpTo unset to cover every larger amount.
+type FeeTier struct {
+	UpTo        *money.Money `json:"up_to,omitempty"`
+	Flat        money.Money  `json:"flat"`
+	BasisPoints int64        `json:"basis_points"`
+}
+
+func (f *FeeSchedule) BeforeSave() error {
+	if err := toCurrency(f.Currency, set(f.Min, f.Max)...); err != nil {
+		return err
+	}
+	tiers, err := json.Marshal(f.Tiers)
+	if err != nil {
+		return err
+	}
+	f.TiersText = string(tiers)
+	return nil
+}
+
+func (f *FeeSchedule) AfterFind() error {
+	exponent, err := money.CurrencyExponent(f.Currency)
+	if err != nil {
+		return err
+	}
+	for _, limit := range []**money.Money{&f.Min, &f.Max} {
+		if *limit != nil {
+			amount := inCurrency(**limit, exponent)
+			*limit = &amount
+		}
+	}
+	f.Tiers = nil
+	if f.TiersText == "" {
+		return nil
+	}
+	return json.Unmarshal([]byte(f.TiersText), &f.Tiers)
+}
+
+// Tier is the first tier covering amount, or the last tier when none does.
+func (f FeeSchedule) Tier(amount money.Money) FeeTier {
+	for _, tier := range f.Tiers {
+		if tier.UpTo == nil || amount.Cmp(*tier.UpTo) <= 0 {
+			return tier
+		}
+	}
+	return f.Tiers[len(f.Tiers)-1]
+}
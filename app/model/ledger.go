@@ -0,0 +1,78 @@
+package model
+
+import (
+	"fmt"
+	"wallet/app/constant"
+	"wallet/app/money"
+
+	"github.com/jinzhu/gorm"
+)
+
+// Account is a double-entry ledger account in a single currency. Every wallet
+// has one, linked through WalletId; system accounts such as
+// constant.EXTERNAL_FUNDING_ACCOUNT exist once per currency.
+type Account struct {
+	gorm.Model
+	Code     string `gorm:"size:64;not null;unique_index:idx_accounts_code_currency" json:"code"`
+	Currency string `gorm:"type:CHAR(3);not null;unique_index:idx_accounts_code_currency" json:"currency"`
+	WalletId *uint  `gorm:"index" json:"wallet_id,omitempty"`
+}
+
+// WalletAccountCode is the Code of the ledger account of a wallet.
+func WalletAccountCode(walletId uint) string {
+	return fmt.Sprintf("WALLET:%d", walletId)
+}
+
+// JournalEntry records one balanced movement of money: in every currency its
+// DEBIT postings add up to its CREDIT postings.
+type JournalEntry struct {
+	gorm.Model
+	Description string    `json:"description"`
+	Postings    []Posting `gorm:"-" json:"postings,omitempty"`
+}
+
+// Balanced reports whether debits equal credits in every currency.
+func (e *JournalEntry) Balanced() bool {
+	net := make(map[string]money.Money)
+	for _, posting := range e.Postings {
+		net[posting.Currency] = net[posting.Currency].Add(posting.Signed())
+	}
+	for _, amount := range net {
+		if !amount.IsZero() {
+			return false
+		}
+	}
+	return len(e.Postings) > 0
+}
+
+// Posting moves Amount into (CREDIT) or out of (DEBIT) an account. Amount is
+// never negative. A wallet account's balance is its credits minus its debits.
+type Posting struct {
+	gorm.Model
+	JournalEntryId uint        `gorm:"index;not null" json:"journal_entry_id"`
+	AccountId      uint        `gorm:"index;not null" json:"account_id"`
+	Direction      string      `gorm:"type:ENUM('CREDIT','DEBIT');not null" json:"direction"`
+	Amount         money.Money `gorm:"type:BIGINT;not null" json:"amount"`
+	Currency       string      `gorm:"type:CHAR(3);not null" json:"currency"`
+}
+
+// Signed is Amount for a CREDIT and -Amount for a DEBIT.
+func (p Posting) Signed() money.Money {
+	if p.Direction == constant.DEBIT {
+		return money.New(0, p.Amount.Exponent).Sub(p.Amount)
+	}
+	return p.Amount
+}
+
+func (p *Posting) BeforeSave() error {
+	return toCurrency(p.Currency, &p.Amount)
+}
+
+func (p *Posting) AfterFind() error {
+	exponent, err := money.CurrencyExponent(p.Currency)
+	if err != nil {
+		return err
+	}
+	p.Amount = inCurrency(p.Amount, exponent)
+	return nil
+}
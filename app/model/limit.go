@@ -0,0 +1,89 @@
+package model
+
+import (
+	"wallet/app/money"
+
+	"github.com/jinzhu/gorm"
+)
+
+// Limits caps what a wallet may do on top of its balance. Amounts are in the
+// wallet currency; a nil limit does not apply. Daily and monthly totals are
+// counted from the start of the UTC day and month.
+type Limits struct {
+	MaxTransactionAmount *money.Money `gorm:"type:BIGINT" json:"max_transaction_amount"`
+	MaxBalance           *money.Money `gorm:"type:BIGINT" json:"max_balance"`
+	DailyDebit           *money.Money `gorm:"type:BIGINT" json:"daily_debit"`
+	DailyCredit          *money.Money `gorm:"type:BIGINT" json:"daily_credit"`
+	MonthlyDebit         *money.Money `gorm:"type:BIGINT" json:"monthly_debit"`
+	MonthlyCredit        *money.Money `gorm:"type:BIGINT" json:"monthly_credit"`
+}
+
+// Fields points at every limit.
+func (l *Limits) Fields() []**money.Money {
+	return []**money.Money{&l.MaxTransactionAmount, &l.MaxBalance, &l.DailyDebit, &l.DailyCredit, &l.MonthlyDebit, &l.MonthlyCredit}
+}
+
+// Override returns l with every limit set in override replaced.
+func (l Limits) Override(override Limits) Limits {
+	limits := l.Fields()
+	for i, limit := range override.Fields() {
+		if *limit != nil {
+			*limits[i] = *limit
+		}
+	}
+	return l
+}
+
+func (l *Limits) toCurrency(currency string) error {
+	limits := []*money.Money{}
+	for _, limit := range l.Fields() {
+		limits = append(limits, *limit)
+	}
+	return toCurrency(currency, set(limits...)...)
+}
+
+func (l *Limits) inCurrency(currency string) error {
+	exponent, err := money.CurrencyExponent(currency)
+	if err != nil {
+		return err
+	}
+	for _, limit := range l.Fields() {
+		if *limit != nil {
+			amount := inCurrency(**limit, exponent)
+			*limit = &amount
+		}
+	}
+	return nil
+}
+
+// LimitProfile holds the default limits of wallets in Currency.
+type LimitProfile struct {
+	gorm.Model
+	Currency string `gorm:"type:CHAR(3);not null;unique_index" json:"currency"`
+	Limits
+}
+
+func (p *LimitProfile) BeforeSave() error {
+	return p.toCurrency(p.Currency)
+}
+
+func (p *LimitProfile) AfterFind() error {
+	return p.inCurrency(p.Currency)
+}
+
+// WalletLimit overrides, limit by limit, the profile of one wallet's
+// currency.
+type WalletLimit struct {
+	gorm.Model
+	WalletId uint   `gorm:"not null;unique_index" json:"wallet_id"`
+	Currency string `gorm:"type:CHAR(3);not null" json:"currency"`
+	Limits
+}
+
+func (l *WalletLimit) BeforeSave() error {
+	return l.toCurrency(l.Currency)
+}
+
+func (l *WalletLimit) AfterFind() error {
+	return l.inCurrency(l.Currency)
+}
@@ -0,0 +1,220 @@
+package model
+
+import (
+	"fmt"
+	"math"
+	"time"
+	"wallet/app/constant"
+	"wallet/app/money"
+
+	"github.com/jinzhu/gorm"
+)
+
+// SchemaMigration records a data migration that has already been applied.
+type SchemaMigration struct {
+	ID        string `gorm:"primary_key;size:64"`
+	AppliedAt time.Time
+}
+
+type migration struct {
+	id  string
+	run func(db *gorm.DB) error
+}
+
+// migrations run once each, in order, after AutoMigrate.
+var migrations = []migration{
+	{"0001_money_minor_units", migrateMoneyToMinorUnits},
+	{"0002_link_reversals", linkReversals},
+	{"0003_backfill_refunded_amount", backfillRefundedAmount},
+	{"0004_open_ledger_accounts", openLedgerAccounts},
+	{"0005_chain_transactions", chainTransactions},
+	{"0006_scope_idempotency_keys", scopeIdempotencyKeys},
+}
+
+// MigrationError reports the data migration that failed to apply.
+type MigrationError struct {
+	ID  string
+	Err error
+}
+
+func (e *MigrationError) Error() string {
+	return fmt.Sprintf("migration %s: %s", e.ID, e.Err)
+}
+
+func (e *MigrationError) Unwrap() error {
+	return e.Err
+}
+
+func runMigrations(db *gorm.DB) error {
+	for _, m := range migrations {
+		applied := SchemaMigration{}
+		err := db.Where("id = ?", m.id).First(&applied).Error
+		if err == nil {
+			continue
+		}
+		if !gorm.IsRecordNotFoundError(err) {
+			return &MigrationError{ID: m.id, Err: fmt.Errorf("checking whether it ran: %w", err)}
+		}
+		tx := db.Begin()
+		if err := m.run(tx); err != nil {
+			tx.Rollback()
+			return &MigrationError{ID: m.id, Err: err}
+		}
+		if err := tx.Create(&SchemaMigration{ID: m.id, AppliedAt: time.Now()}).Error; err != nil {
+			tx.Rollback()
+			return &MigrationError{ID: m.id, Err: fmt.Errorf("recording it: %w", err)}
+		}
+		if err := tx.Commit().Error; err != nil {
+			return &MigrationError{ID: m.id, Err: fmt.Errorf("committing it: %w", err)}
+		}
+	}
+	return nil
+}
+
+// migrateMoneyToMinorUnits converts the float columns used before money.Money
+// into integer minor units. Values go through DECIMAL first so the float to
+// integer conversion rounds to the nearest minor unit. MySQL commits DDL
+// implicitly, so only columns still typed as float are touched and a rerun
+// after a partial failure is safe.
+func migrateMoneyToMinorUnits(db *gorm.DB) error {
+	scale := int64(math.Pow10(money.DefaultExponent))
+	columns := []struct{ table, column string }{
+		{"wallets", "balance"},
+		{"transactions", "amount"},
+		{"transactions", "closing_balance"},
+	}
+	for _, c := range columns {
+		var column struct{ DataType string }
+		err := db.Raw("SELECT DATA_TYPE AS data_type FROM information_schema.COLUMNS WHERE TABLE_SCHEMA = DATABASE() AND TABLE_NAME = ? AND COLUMN_NAME = ?", c.table, c.column).
+			Scan(&column).Error
+		if err != nil && !gorm.IsRecordNotFoundError(err) {
+			return err
+		}
+		if column.DataType != "float" && column.DataType != "double" {
+			continue
+		}
+		statements := []string{
+			fmt.Sprintf("UPDATE %s SET %s = 0 WHERE %s IS NULL", c.table, c.column, c.column),
+			fmt.Sprintf("ALTER TABLE %s MODIFY %s DECIMAL(24,6) NOT NULL DEFAULT 0", c.table, c.column),
+			fmt.Sprintf("UPDATE %s SET %s = ROUND(%s * %d)", c.table, c.column, c.column, scale),
+			fmt.Sprintf("ALTER TABLE %s MODIFY %s BIGINT NOT NULL DEFAULT 0", c.table, c.column),
+		}
+		for _, statement := range statements {
+			if err := db.Exec(statement).Error; err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// linkReversals fills in ReversedTransactionId and ReversalState for reversals
+// made before they were tracked, which only recorded "Revert of :<id>" in the
+// description.
+func linkReversals(db *gorm.DB) error {
+	return db.Exec(`UPDATE transactions reversal
+		JOIN transactions original ON reversal.description = CONCAT('Revert of :', original.id)
+		SET reversal.reversed_transaction_id = original.id, original.reversal_state = ?
+		WHERE reversal.reversed_transaction_id IS NULL`, constant.REVERSED).Error
+}
+
+// backfillRefundedAmount widens reversal_state for PARTIALLY_REVERSED and
+// marks fully reversed transactions as having no refundable amount left.
+func backfillRefundedAmount(db *gorm.DB) error {
+	err := db.Exec("ALTER TABLE transactions MODIFY reversal_state VARCHAR(20) NOT NULL DEFAULT ?", constant.NOT_REVERSED).Error
+	if err != nil {
+		return err
+	}
+	return db.Exec("UPDATE transactions SET refunded_amount = amount WHERE reversal_state = ?", constant.REVERSED).Error
+}
+
+// openLedgerAccounts gives wallets created before the journal their ledger
+// and system accounts, and posts each non-zero balance as an opening entry
+// against external funding.
+func openLedgerAccounts(db *gorm.DB) error {
+	var wallets []Wallet
+	if err := db.Order("id").Find(&wallets).Error; err != nil {
+		return err
+	}
+	for _, wallet := range wallets {
+		funding := Account{Code: constant.EXTERNAL_FUNDING_ACCOUNT, Currency: wallet.Currency}
+		fx := Account{Code: constant.FX_CONVERSION_ACCOUNT, Currency: wallet.Currency}
+		walletAccount := Account{Code: WalletAccountCode(wallet.ID), Currency: wallet.Currency, WalletId: &wallet.ID}
+		for _, account := range []*Account{&funding, &fx, &walletAccount} {
+			if err := db.FirstOrCreate(account, Account{Code: account.Code, Currency: account.Currency}).Error; err != nil {
+				return err
+			}
+		}
+		if wallet.Balance.IsZero() {
+			continue
+		}
+		entry := JournalEntry{Description: "Opening balance"}
+		if err := db.Create(&entry).Error; err != nil {
+			return err
+		}
+		credit, debit := walletAccount, funding
+		balance := wallet.Balance
+		if balance.IsNegative() {
+			credit, debit = funding, walletAccount
+			balance = money.New(0, balance.Exponent).Sub(balance)
+		}
+		postings := []Posting{
+			{JournalEntryId: entry.ID, AccountId: credit.ID, Direction: constant.CREDIT, Amount: balance, Currency: wallet.Currency},
+			{JournalEntryId: entry.ID, AccountId: debit.ID, Direction: constant.DEBIT, Amount: balance, Currency: wallet.Currency},
+		}
+		for i := range postings {
+			if err := db.Create(&postings[i]).Error; err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// chainTransactions hashes the transactions made before they were chained,
+// linking each wallet's in ID order, and sets the wallets' chain heads.
+func chainTransactions(db *gorm.DB) error {
+	var wallets []Wallet
+	if err := db.Order("id").Find(&wallets).Error; err != nil {
+		return err
+	}
+	for _, wallet := range wallets {
+		var transactions []Transaction
+		if err := db.Where("wallet_id = ?", wallet.ID).Order("id").Find(&transactions).Error; err != nil {
+			return err
+		}
+		head := ""
+		for _, transaction := range transactions {
+			transaction.PrevHash = head
+			hash, err := transaction.ComputeHash()
+			if err != nil {
+				return err
+			}
+			err = db.Model(&transaction).UpdateColumns(map[string]interface{}{"prev_hash": head, "hash": hash}).Error
+			if err != nil {
+				return err
+			}
+			head = hash
+		}
+		if err := db.Model(&wallet).UpdateColumn("chain_hash", head).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// scopeIdempotencyKeys makes the caller's subject part of the primary key of
+// idempotency keys. Keys stored before have no subject and are never replayed
+// again; they are purged once they expire.
+func scopeIdempotencyKeys(db *gorm.DB) error {
+	statements := []string{
+		"UPDATE idempotency_keys SET subject = '' WHERE subject IS NULL",
+		"ALTER TABLE idempotency_keys MODIFY subject VARCHAR(255) NOT NULL DEFAULT '', DROP PRIMARY KEY, ADD PRIMARY KEY (subject, idempotency_key)",
+	}
+	for _, statement := range statements {
+		if err := db.Exec(statement).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,29 @@
+package model
+
+import (
+	"errors"
+	"testing"
+
+	sqlmock "github.com/DATA-DOG/go-sqlmock"
+	"github.com/jinzhu/gorm"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRunMigrationsReportsFailedChecks(t *testing.T) {
+	sqlDB, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	db, err := gorm.Open("mysql", sqlDB)
+	assert.NoError(t, err)
+	db.LogMode(false)
+	lost := errors.New("connection lost")
+	mock.ExpectQuery("SELECT (.+) FROM `schema_migrations`").WillReturnError(lost)
+
+	err = runMigrations(db)
+
+	var failed *MigrationError
+	if assert.True(t, errors.As(err, &failed)) {
+		assert.Equal(t, "0001_money_minor_units", failed.ID)
+	}
+	assert.True(t, errors.Is(err, lost))
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
@@ -1,26 +1,279 @@
 package model
 
 import (
+	"time"
+	"wallet/app/constant"
+	"wallet/app/money"
+
 	"github.com/jinzhu/gorm"
 	_ "github.com/jinzhu/gorm/dialects/mysql"
 )
 
+// Owner is the customer wallets belong to. Bearer tokens name the owner in
+// their subject.
+type Owner struct {
+	gorm.Model
+	Name  string `gorm:"size:255" json:"name"`
+	Email string `gorm:"size:255;unique_index" json:"email"`
+}
+
+// Wallet.Balance is the ledger balance. HeldBalance is reserved by active
+// holds, leaving AvailableBalance for new debits. Currency is the ISO 4217
+// code fixed at creation; wallets created before currencies default to USD.
+// Status is ACTIVE, FROZEN or CLOSED, and StatusReason is the reason code
+// given for the last change of status. Wallets created before owners have no
+// OwnerId and are only reachable with the admin scope.
 type Wallet struct {
 	gorm.Model
-	Balance float32
+	OwnerId          *uint       `gorm:"index" json:"owner_id,omitempty"`
+	Currency         string      `gorm:"type:CHAR(3);not null;default:'USD'" json:"currency"`
+	Balance          money.Money `gorm:"type:BIGINT;not null;default:0"`
+	HeldBalance      money.Money `gorm:"type:BIGINT;not null" json:"held_balance"`
+	AvailableBalance money.Money `gorm:"-" json:"available_balance"`
+	Status           string      `gorm:"size:16;not null;default:'ACTIVE'" json:"status"`
+	StatusReason     string      `gorm:"size:64" json:"status_reason,omitempty"`
+	// ChainHash is the Hash of the wallet's latest transaction, the head of
+	// its hash chain.
+	ChainHash string `gorm:"size:64" json:"-"`
+}
+
+func (w *Wallet) BeforeSave() error {
+	return toCurrency(w.Currency, &w.Balance, &w.HeldBalance)
 }
+
+func (w *Wallet) AfterFind() error {
+	exponent, err := money.CurrencyExponent(w.Currency)
+	if err != nil {
+		return err
+	}
+	w.Balance = inCurrency(w.Balance, exponent)
+	w.HeldBalance = inCurrency(w.HeldBalance, exponent)
+	w.AvailableBalance = w.Balance.Sub(w.HeldBalance)
+	return nil
+}
+
+func (w *Wallet) AfterCreate() error {
+	return w.AfterFind()
+}
+
 type Transaction struct {
 	gorm.Model
-	Amount         float32 `json:"amount"`
-	Type           string  `gorm:"type:ENUM('CREDIT','DEBIT');" json:"type"`
-	ClosingBalance float32
+	Amount         money.Money `gorm:"type:BIGINT;not null;default:0" json:"amount"`
+	Currency       string      `gorm:"type:CHAR(3);not null;default:'USD'" json:"currency"`
+	Type           string      `gorm:"type:ENUM('CREDIT','DEBIT');" json:"type"`
+	ClosingBalance money.Money `gorm:"type:BIGINT;not null;default:0"`
 	Description    string
-	WalletId       uint `json:"wallet_id"`
+	WalletId       uint  `json:"wallet_id"`
+	TransferId     *uint `gorm:"index" json:"transfer_id,omitempty"`
+	// ReversedTransactionId is set on a reversal and points at the original.
+	ReversedTransactionId *uint  `gorm:"index" json:"reversed_transaction_id,omitempty"`
+	ReversalState         string `gorm:"size:20;not null;default:'NONE'" json:"reversal_state"`
+	// RefundedAmount is the total reversed so far through refunds and reverts.
+	RefundedAmount   money.Money `gorm:"type:BIGINT;not null" json:"refunded_amount"`
+	RefundableAmount money.Money `gorm:"-" json:"refundable_amount"`
+	// JournalEntryId is the ledger entry that moved the wallet's money.
+	JournalEntryId *uint `gorm:"index" json:"journal_entry_id,omitempty"`
+	// FeeOfTransactionId is set on both legs of a fee and points at the
+	// transaction charged. Fee is the leg debited from the payer.
+	FeeOfTransactionId *uint        `gorm:"index" json:"fee_of_transaction_id,omitempty"`
+	Fee                *Transaction `gorm:"-" json:"fee,omitempty"`
+	// PrevHash is the Hash of the wallet's transaction before this one, empty
+	// for its first, see ComputeHash.
+	PrevHash string `gorm:"size:64" json:"prev_hash,omitempty"`
+	Hash     string `gorm:"size:64" json:"hash,omitempty"`
+}
+
+func (t *Transaction) BeforeSave() error {
+	return toCurrency(t.Currency, &t.Amount, &t.ClosingBalance, &t.RefundedAmount)
+}
+
+// BeforeCreate goes through SetColumn so that gorm sees ReversalState as set
+// and does not reload it after the insert.
+func (t *Transaction) BeforeCreate(scope *gorm.Scope) error {
+	if t.ReversalState == "" {
+		if err := scope.SetColumn("ReversalState", constant.NOT_REVERSED); err != nil {
+			return err
+		}
+	}
+	t.RefundableAmount = t.Amount.Sub(t.RefundedAmount)
+	return nil
+}
+
+func (t *Transaction) AfterFind() error {
+	exponent, err := money.CurrencyExponent(t.Currency)
+	if err != nil {
+		return err
+	}
+	t.Amount = inCurrency(t.Amount, exponent)
+	t.ClosingBalance = inCurrency(t.ClosingBalance, exponent)
+	t.RefundedAmount = inCurrency(t.RefundedAmount, exponent)
+	t.RefundableAmount = t.Amount.Sub(t.RefundedAmount)
+	return nil
+}
+
+// Transfer moves money between two wallets. It owns one DEBIT and one CREDIT
+// Transaction, both carrying its ID as TransferId. Amount is in Currency, the
+// source wallet's currency; FxRate converts it when the destination wallet
+// uses another currency.
+type Transfer struct {
+	gorm.Model
+	FromWalletId uint          `json:"from_wallet_id"`
+	ToWalletId   uint          `json:"to_wallet_id"`
+	Amount       money.Money   `gorm:"type:BIGINT;not null;default:0" json:"amount"`
+	Currency     string        `gorm:"type:CHAR(3);not null;default:'USD'" json:"currency"`
+	FxRate       *money.Money  `gorm:"-" json:"fx_rate,omitempty"`
+	Description  string        `json:"description"`
+	Transactions []Transaction `gorm:"-" json:"transactions,omitempty"`
+	// ReversedTransferId is set on a reversal and points at the original.
+	ReversedTransferId *uint `gorm:"index" json:"reversed_transfer_id,omitempty"`
+	// FxRateText keeps FxRate exactly, as the rate has no fixed exponent.
+	FxRateText string `gorm:"column:fx_rate;size:32" json:"-"`
+}
+
+func (t *Transfer) BeforeSave() error {
+	if err := toCurrency(t.Currency, &t.Amount); err != nil {
+		return err
+	}
+	t.FxRateText = ""
+	if t.FxRate != nil {
+		t.FxRateText = t.FxRate.String()
+	}
+	return nil
+}
+
+func (t *Transfer) AfterFind() error {
+	exponent, err := money.CurrencyExponent(t.Currency)
+	if err != nil {
+		return err
+	}
+	t.Amount = inCurrency(t.Amount, exponent)
+	t.FxRate = nil
+	if t.FxRateText != "" {
+		rate, err := money.Parse(t.FxRateText)
+		if err != nil {
+			return err
+		}
+		t.FxRate = &rate
+	}
+	return nil
+}
+
+// Hold reserves Amount on a wallet until it is captured, voided or expires.
+// TransactionId points at the DEBIT made by the capture.
+type Hold struct {
+	gorm.Model
+	WalletId       uint        `json:"wallet_id"`
+	Amount         money.Money `gorm:"type:BIGINT;not null" json:"amount"`
+	Currency       string      `gorm:"type:CHAR(3);not null;default:'USD'" json:"currency"`
+	CapturedAmount money.Money `gorm:"type:BIGINT;not null" json:"captured_amount"`
+	Status         string      `gorm:"size:16;not null;index" json:"status"`
+	Description    string      `json:"description"`
+	ExpiresAt      time.Time   `gorm:"index" json:"expires_at"`
+	TransactionId  *uint       `json:"transaction_id,omitempty"`
+}
+
+func (h *Hold) BeforeSave() error {
+	return toCurrency(h.Currency, &h.Amount, &h.CapturedAmount)
+}
+
+func (h *Hold) AfterFind() error {
+	exponent, err := money.CurrencyExponent(h.Currency)
+	if err != nil {
+		return err
+	}
+	h.Amount = inCurrency(h.Amount, exponent)
+	h.CapturedAmount = inCurrency(h.CapturedAmount, exponent)
+	return nil
+}
+
+// toCurrency rescales amounts about to be saved to the exponent of currency,
+// as columns hold minor units of the row's currency. It refuses amounts that
+// would lose digits rather than store them off by a power of ten. Rows
+// without a currency get the column default.
+func toCurrency(currency string, amounts ...*money.Money) error {
+	if currency == "" {
+		currency = constant.DEFAULT_CURRENCY
+	}
+	exponent, err := money.CurrencyExponent(currency)
+	if err != nil {
+		return err
+	}
+	for _, amount := range amounts {
+		rescaled, err := amount.Rescale(exponent)
+		if err != nil {
+			return err
+		}
+		*amount = rescaled
+	}
+	return nil
+}
+
+// set leaves out the amounts that are not set.
+func set(amounts ...*money.Money) []*money.Money {
+	var isSet []*money.Money
+	for _, amount := range amounts {
+		if amount != nil {
+			isSet = append(isSet, amount)
+		}
+	}
+	return isSet
+}
+
+// inCurrency sets the exponent of an amount loaded from the database, which
+// Scan reads at money.DefaultExponent, to that of the row's currency.
+func inCurrency(amount money.Money, exponent int) money.Money {
+	return money.New(amount.Units, exponent)
+}
+
+// IdempotencyKey remembers the response to a request made with an
+// Idempotency-Key header so that retries can be answered without reprocessing.
+// Keys are scoped to their caller: Subject is the subject of the token the key
+// came with, so callers choosing the same key never meet.
+type IdempotencyKey struct {
+	Subject       string `gorm:"primary_key;size:255"`
+	Key           string `gorm:"column:idempotency_key;primary_key;size:255"`
+	Fingerprint   string `gorm:"size:64"`
+	TransactionID uint
+	StatusCode    int
+	Response      string `gorm:"type:TEXT"`
+	CreatedAt     time.Time
+	ExpiresAt     time.Time `gorm:"index"`
+}
+
+// foreignKeys are added by DBMigrate after AutoMigrate, which creates none.
+var foreignKeys = []struct {
+	model                           interface{}
+	field, dest, onDelete, onUpdate string
+}{
+	{&Wallet{}, "owner_id", "owners(id)", "RESTRICT", "CASCADE"},
+	{&Transaction{}, "wallet_id", "wallets(id)", "CASCADE", "CASCADE"},
+	{&Transaction{}, "transfer_id", "transfers(id)", "RESTRICT", "CASCADE"},
+	{&Hold{}, "wallet_id", "wallets(id)", "CASCADE", "CASCADE"},
+	{&WalletLimit{}, "wallet_id", "wallets(id)", "CASCADE", "CASCADE"},
+	{&FeeSchedule{}, "revenue_wallet_id", "wallets(id)", "RESTRICT", "CASCADE"},
+	{&Schedule{}, "wallet_id", "wallets(id)", "CASCADE", "CASCADE"},
+	{&ScheduleRun{}, "schedule_id", "schedules(id)", "CASCADE", "CASCADE"},
+	{&WebhookDelivery{}, "event_id", "events(id)", "CASCADE", "CASCADE"},
+	{&WebhookDelivery{}, "subscription_id", "webhook_subscriptions(id)", "CASCADE", "CASCADE"},
+	{&Transaction{}, "journal_entry_id", "journal_entries(id)", "RESTRICT", "CASCADE"},
+	{&Posting{}, "journal_entry_id", "journal_entries(id)", "RESTRICT", "CASCADE"},
+	{&Posting{}, "account_id", "accounts(id)", "RESTRICT", "CASCADE"},
 }
 
-func DBMigrate(db *gorm.DB) *gorm.DB {
-	db.LogMode(true)
-	db.AutoMigrate(&Wallet{}, &Transaction{})
-	db.Model(&Transaction{}).AddForeignKey("wallet_id", "wallets(id)", "CASCADE", "CASCADE")
-	return db
+// DBMigrate brings the schema up to date and runs the data migrations not
+// applied yet, stopping at the first failure.
+func DBMigrate(db *gorm.DB) (*gorm.DB, error) {
+	err := db.AutoMigrate(&Owner{}, &Wallet{}, &Transaction{}, &Transfer{}, &Hold{}, &IdempotencyKey{}, &Account{}, &JournalEntry{}, &Posting{}, &LimitProfile{}, &WalletLimit{}, &FeeSchedule{}, &Schedule{}, &ScheduleRun{}, &Event{}, &WebhookSubscription{}, &WebhookDelivery{}, &AuditRecord{}, &SchemaMigration{}).Error
+	if err != nil {
+		return db, err
+	}
+	for _, key := range foreignKeys {
+		if err := db.Model(key.model).AddForeignKey(key.field, key.dest, key.onDelete, key.onUpdate).Error; err != nil {
+			return db, err
+		}
+	}
+	if err := db.Model(&Transaction{}).AddIndex("idx_transactions_wallet_created_at_id", "wallet_id", "created_at", "id").Error; err != nil {
+		return db, err
+	}
+	return db, runMigrations(db)
 }
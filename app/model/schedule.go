@@ -0,0 +1,61 @@
+package model
+
+import (
+	"time"
+	"wallet/app/money"
+
+	"github.com/jinzhu/gorm"
+)
+
+// Schedule posts Amount every time Rule recurs from StartAt: a CREDIT or
+// DEBIT to WalletId, or a TRANSFER from WalletId to ToWalletId. It stops
+// after MaxRuns occurrences, when set, or at EndAt. Runs counts the
+// occurrences dealt with so far and NextRunAt is when the next one, or the
+// next attempt at it, is due. Attempts and LastError track the failed
+// attempts at that occurrence.
+type Schedule struct {
+	gorm.Model
+	Type        string      `gorm:"size:16;not null" json:"type"`
+	WalletId    uint        `gorm:"not null;index" json:"wallet_id"`
+	ToWalletId  *uint       `json:"to_wallet_id,omitempty"`
+	Amount      money.Money `gorm:"type:BIGINT;not null" json:"amount"`
+	Currency    string      `gorm:"type:CHAR(3);not null" json:"currency"`
+	Description string      `json:"description"`
+	Rule        string      `gorm:"size:255;not null" json:"rule"`
+	StartAt     time.Time   `json:"start_at"`
+	EndAt       *time.Time  `json:"end_at,omitempty"`
+	MaxRuns     int         `json:"max_runs,omitempty"`
+	Runs        int         `gorm:"not null" json:"runs"`
+	NextRunAt   time.Time   `gorm:"index" json:"next_run_at"`
+	Attempts    int         `gorm:"not null" json:"attempts"`
+	LastError   string      `gorm:"size:255" json:"last_error,omitempty"`
+	Status      string      `gorm:"size:16;not null;index" json:"status"`
+}
+
+func (s *Schedule) BeforeSave() error {
+	return toCurrency(s.Currency, &s.Amount)
+}
+
+func (s *Schedule) AfterFind() error {
+	exponent, err := money.CurrencyExponent(s.Currency)
+	if err != nil {
+		return err
+	}
+	s.Amount = inCurrency(s.Amount, exponent)
+	return nil
+}
+
+// ScheduleRun records how one occurrence of a schedule went. It is written
+// in the same DB transaction as the posting, and there is at most one per
+// occurrence.
+type ScheduleRun struct {
+	gorm.Model
+	ScheduleId    uint      `gorm:"not null;unique_index:idx_schedule_runs_occurrence" json:"schedule_id"`
+	Occurrence    int       `gorm:"not null;unique_index:idx_schedule_runs_occurrence" json:"occurrence"`
+	ScheduledAt   time.Time `json:"scheduled_at"`
+	Status        string    `gorm:"size:16;not null" json:"status"`
+	Attempts      int       `gorm:"not null" json:"attempts"`
+	Error         string    `gorm:"size:255" json:"error,omitempty"`
+	TransactionId *uint     `json:"transaction_id,omitempty"`
+	TransferId    *uint     `json:"transfer_id,omitempty"`
+}
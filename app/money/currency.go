@@ -0,0 +1,92 @@
+package money
+
+import (
+	"errors"
+	"math/big"
+)
+
+var ErrUnknownCurrency = errors.New("unknown currency")
+
+// currencyExponents maps the supported ISO 4217 codes to their number of
+// minor-unit digits.
+var currencyExponents = map[string]int{
+	"AED": 2,
+	"AUD": 2,
+	"BHD": 3,
+	"BRL": 2,
+	"CAD": 2,
+	"CHF": 2,
+	"CLP": 0,
+	"CNY": 2,
+	"DKK": 2,
+	"EUR": 2,
+	"GBP": 2,
+	"HKD": 2,
+	"IDR": 2,
+	"INR": 2,
+	"ISK": 0,
+	"JOD": 3,
+	"JPY": 0,
+	"KRW": 0,
+	"KWD": 3,
+	"MXN": 2,
+	"MYR": 2,
+	"NOK": 2,
+	"NZD": 2,
+	"OMR": 3,
+	"PHP": 2,
+	"PLN": 2,
+	"SAR": 2,
+	"SEK": 2,
+	"SGD": 2,
+	"THB": 2,
+	"TND": 3,
+	"USD": 2,
+	"VND": 0,
+	"ZAR": 2,
+}
+
+// CurrencyExponent returns the minor-unit exponent of an ISO 4217 currency
+// code, e.g. 2 for USD and 0 for JPY.
+func CurrencyExponent(code string) (int, error) {
+	exponent, ok := currencyExponents[code]
+	if !ok {
+		return 0, ErrUnknownCurrency
+	}
+	return exponent, nil
+}
+
+// Round converts m to the given exponent, rounding half away from zero when
+// digits are dropped.
+func (m Money) Round(exponent int) (Money, error) {
+	return round(big.NewInt(m.Units), m.Exponent, exponent)
+}
+
+// Convert multiplies m by rate, e.g. an FX rate, and rounds the product to
+// the given exponent.
+func (m Money) Convert(rate Money, exponent int) (Money, error) {
+	product := new(big.Int).Mul(big.NewInt(m.Units), big.NewInt(rate.Units))
+	return round(product, m.Exponent+rate.Exponent, exponent)
+}
+
+func round(units *big.Int, from, to int) (Money, error) {
+	result := new(big.Int).Set(units)
+	if to >= from {
+		result.Mul(result, pow10(to-from))
+	} else {
+		divisor := pow10(from - to)
+		remainder := new(big.Int)
+		result.QuoRem(units, divisor, remainder)
+		if remainder.Abs(remainder).Lsh(remainder, 1).Cmp(divisor) >= 0 {
+			result.Add(result, big.NewInt(int64(units.Sign())))
+		}
+	}
+	if result.CmpAbs(big.NewInt(MaxUnits)) > 0 {
+		return Money{}, ErrOutOfRange
+	}
+	return Money{Units: result.Int64(), Exponent: to}, nil
+}
+
+func pow10(n int) *big.Int {
+	return new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(n)), nil)
+}
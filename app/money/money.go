@@ -0,0 +1,208 @@
+package money
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// DefaultExponent is the number of decimal places of the default currency.
+const DefaultExponent = 2
+
+// MaxUnits bounds the absolute value of a parsed amount so that sums of
+// amounts cannot overflow int64.
+const MaxUnits = 1000000000000000
+
+var (
+	ErrInvalidAmount = errors.New("invalid amount")
+	ErrPrecision     = errors.New("amount has more decimal places than allowed")
+	ErrOutOfRange    = errors.New("amount out of range")
+)
+
+// Money is an exact decimal amount: Units minor units at 10^-Exponent.
+type Money struct {
+	Units    int64
+	Exponent int
+}
+
+func New(units int64, exponent int) Money {
+	return Money{Units: units, Exponent: exponent}
+}
+
+// Parse reads an exact decimal string such as "-12.50". The exponent of the
+// result is the number of fraction digits given.
+func Parse(s string) (Money, error) {
+	s = strings.TrimSpace(s)
+	negative := false
+	if strings.HasPrefix(s, "-") {
+		negative = true
+		s = s[1:]
+	}
+	whole, fraction := s, ""
+	if i := strings.IndexByte(s, '.'); i >= 0 {
+		whole, fraction = s[:i], s[i+1:]
+		if fraction == "" {
+			return Money{}, ErrInvalidAmount
+		}
+	}
+	if whole == "" || !isDigits(whole) || !isDigits(fraction) {
+		return Money{}, ErrInvalidAmount
+	}
+	units, err := strconv.ParseInt(whole+fraction, 10, 64)
+	if err != nil || units > MaxUnits {
+		return Money{}, ErrOutOfRange
+	}
+	if negative {
+		units = -units
+	}
+	return Money{Units: units, Exponent: len(fraction)}, nil
+}
+
+func isDigits(s string) bool {
+	for _, c := range s {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// Rescale converts m to the given exponent. Reducing the exponent fails with
+// ErrPrecision if it would drop non-zero digits.
+func (m Money) Rescale(exponent int) (Money, error) {
+	units := m.Units
+	for e := m.Exponent; e < exponent; e++ {
+		if units > math.MaxInt64/10 || units < math.MinInt64/10 {
+			return Money{}, ErrOutOfRange
+		}
+		units *= 10
+	}
+	for e := m.Exponent; e > exponent; e-- {
+		if units%10 != 0 {
+			return Money{}, ErrPrecision
+		}
+		units /= 10
+	}
+	return Money{Units: units, Exponent: exponent}, nil
+}
+
+func align(a, b Money) (int64, int64, int) {
+	for a.Exponent < b.Exponent {
+		a.Units *= 10
+		a.Exponent++
+	}
+	for b.Exponent < a.Exponent {
+		b.Units *= 10
+		b.Exponent++
+	}
+	return a.Units, b.Units, a.Exponent
+}
+
+func (m Money) Add(o Money) Money {
+	a, b, exponent := align(m, o)
+	return Money{Units: a + b, Exponent: exponent}
+}
+
+func (m Money) Sub(o Money) Money {
+	a, b, exponent := align(m, o)
+	return Money{Units: a - b, Exponent: exponent}
+}
+
+// Cmp returns -1, 0 or 1 depending on whether m is less than, equal to or
+// greater than o.
+func (m Money) Cmp(o Money) int {
+	a, b, _ := align(m, o)
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+func (m Money) IsZero() bool {
+	return m.Units == 0
+}
+
+func (m Money) IsNegative() bool {
+	return m.Units < 0
+}
+
+func (m Money) String() string {
+	sign := ""
+	units := m.Units
+	if units < 0 {
+		sign = "-"
+		units = -units
+	}
+	digits := strconv.FormatInt(units, 10)
+	if m.Exponent <= 0 {
+		return sign + digits
+	}
+	if len(digits) <= m.Exponent {
+		digits = strings.Repeat("0", m.Exponent-len(digits)+1) + digits
+	}
+	point := len(digits) - m.Exponent
+	return sign + digits[:point] + "." + digits[point:]
+}
+
+// MarshalJSON encodes the amount as a decimal string to avoid float rounding
+// in clients.
+func (m Money) MarshalJSON() ([]byte, error) {
+	return json.Marshal(m.String())
+}
+
+// UnmarshalJSON accepts a decimal string or a JSON number; both are parsed
+// exactly from their text.
+func (m *Money) UnmarshalJSON(data []byte) error {
+	text := string(data)
+	if text == "null" {
+		*m = Money{}
+		return nil
+	}
+	if strings.HasPrefix(text, `"`) {
+		if err := json.Unmarshal(data, &text); err != nil {
+			return err
+		}
+	}
+	parsed, err := Parse(text)
+	if err != nil {
+		return fmt.Errorf("%s: %q", err.Error(), text)
+	}
+	*m = parsed
+	return nil
+}
+
+// Value stores Units as is. Columns hold minor units of the row's currency:
+// models rescale their amounts to it in BeforeSave.
+func (m Money) Value() (driver.Value, error) {
+	return m.Units, nil
+}
+
+// Scan reads minor units at DefaultExponent; models holding a currency
+// correct the exponent after loading.
+func (m *Money) Scan(src interface{}) error {
+	var units int64
+	var err error
+	switch v := src.(type) {
+	case nil:
+	case int64:
+		units = v
+	case []byte:
+		units, err = strconv.ParseInt(string(v), 10, 64)
+	case string:
+		units, err = strconv.ParseInt(v, 10, 64)
+	default:
+		err = fmt.Errorf("cannot scan %T into Money", src)
+	}
+	if err != nil {
+		return err
+	}
+	*m = Money{Units: units, Exponent: DefaultExponent}
+	return nil
+}
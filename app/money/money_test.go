@@ -0,0 +1,129 @@
+package money
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		input string
+		want  Money
+	}{
+		{"12.34", New(1234, 2)},
+		{"-0.5", New(-5, 1)},
+		{"500", New(500, 0)},
+		{"0.10", New(10, 2)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			got, err := Parse(tt.input)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestParseRejectsInvalidAmounts(t *testing.T) {
+	for _, input := range []string{"", "abc", "1.", ".5", "1e3", "1.2.3", "--1", "99999999999999999999"} {
+		_, err := Parse(input)
+		assert.Error(t, err, input)
+	}
+}
+
+func TestRescale(t *testing.T) {
+	got, err := New(5, 0).Rescale(2)
+	assert.NoError(t, err)
+	assert.Equal(t, New(500, 2), got)
+
+	got, err = New(1200, 3).Rescale(2)
+	assert.NoError(t, err)
+	assert.Equal(t, New(120, 2), got)
+
+	_, err = New(1205, 3).Rescale(2)
+	assert.Equal(t, ErrPrecision, err)
+}
+
+func TestArithmeticIsExact(t *testing.T) {
+	balance := New(0, 2)
+	for i := 0; i < 10000; i++ {
+		balance = balance.Add(New(1, 1))
+	}
+	assert.Equal(t, "1000.00", balance.String())
+	assert.Equal(t, 0, balance.Cmp(New(1000, 0)))
+	assert.True(t, balance.Sub(New(100001, 2)).IsNegative())
+}
+
+func TestString(t *testing.T) {
+	assert.Equal(t, "0.05", New(5, 2).String())
+	assert.Equal(t, "-1.50", New(-150, 2).String())
+	assert.Equal(t, "7", New(7, 0).String())
+}
+
+func TestJSONRoundTrip(t *testing.T) {
+	data, err := json.Marshal(New(1999, 2))
+	assert.NoError(t, err)
+	assert.Equal(t, `"19.99"`, string(data))
+
+	var m Money
+	assert.NoError(t, json.Unmarshal([]byte(`"19.99"`), &m))
+	assert.Equal(t, New(1999, 2), m)
+	assert.NoError(t, json.Unmarshal([]byte(`0.1`), &m))
+	assert.Equal(t, New(1, 1), m)
+	assert.Error(t, json.Unmarshal([]byte(`"1,00"`), &m))
+}
+
+func TestValueAndScan(t *testing.T) {
+	value, err := New(30, 2).Value()
+	assert.NoError(t, err)
+	assert.Equal(t, int64(30), value)
+
+	var m Money
+	assert.NoError(t, m.Scan([]byte("1234")))
+	assert.Equal(t, New(1234, DefaultExponent), m)
+	assert.Error(t, m.Scan(1.5))
+}
+
+func TestRound(t *testing.T) {
+	tests := []struct {
+		input    Money
+		exponent int
+		want     Money
+	}{
+		{New(10005, 3), 2, New(1001, 2)},
+		{New(10004, 3), 2, New(1000, 2)},
+		{New(-10005, 3), 2, New(-1001, 2)},
+		{New(150, 2), 0, New(2, 0)},
+		{New(5, 0), 3, New(5000, 3)},
+	}
+	for _, tt := range tests {
+		got, err := tt.input.Round(tt.exponent)
+		assert.NoError(t, err)
+		assert.Equal(t, tt.want, got, tt.input.String())
+	}
+	_, err := New(MaxUnits, 0).Round(2)
+	assert.Equal(t, ErrOutOfRange, err)
+}
+
+func TestConvert(t *testing.T) {
+	got, err := New(1000, 2).Convert(New(15725, 2), 0)
+	assert.NoError(t, err)
+	assert.Equal(t, New(1573, 0), got)
+
+	got, err = New(1573, 0).Convert(New(635925, 8), 2)
+	assert.NoError(t, err)
+	assert.Equal(t, New(1000, 2), got)
+}
+
+func TestCurrencyExponent(t *testing.T) {
+	exponent, err := CurrencyExponent("JPY")
+	assert.NoError(t, err)
+	assert.Equal(t, 0, exponent)
+	exponent, err = CurrencyExponent("KWD")
+	assert.NoError(t, err)
+	assert.Equal(t, 3, exponent)
+	_, err = CurrencyExponent("usd")
+	assert.Equal(t, ErrUnknownCurrency, err)
+}
@@ -0,0 +1,78 @@
+// Package recurrence reads the subset of iCalendar RRULEs used by schedules:
+// FREQ=DAILY, WEEKLY or MONTHLY with an optional INTERVAL. Occurrences are
+// counted from a start time, whose time of day, weekday and day of month
+// they keep.
+package recurrence
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	Daily   = "DAILY"
+	Weekly  = "WEEKLY"
+	Monthly = "MONTHLY"
+)
+
+var ErrInvalidRule = errors.New("rule must be FREQ=DAILY, WEEKLY or MONTHLY with an optional positive INTERVAL")
+
+// Rule repeats every Interval days, weeks or months.
+type Rule struct {
+	Freq     string
+	Interval int
+}
+
+// Parse reads a rule such as "FREQ=MONTHLY;INTERVAL=3". The "RRULE:" prefix
+// is optional.
+func Parse(s string) (Rule, error) {
+	rule := Rule{Interval: 1}
+	s = strings.TrimPrefix(strings.TrimSpace(s), "RRULE:")
+	for _, part := range strings.Split(s, ";") {
+		i := strings.Index(part, "=")
+		if i < 0 {
+			return rule, ErrInvalidRule
+		}
+		name, value := strings.ToUpper(part[:i]), strings.ToUpper(part[i+1:])
+		switch name {
+		case "FREQ":
+			if value != Daily && value != Weekly && value != Monthly {
+				return rule, ErrInvalidRule
+			}
+			rule.Freq = value
+		case "INTERVAL":
+			interval, err := strconv.Atoi(value)
+			if err != nil || interval <= 0 {
+				return rule, ErrInvalidRule
+			}
+			rule.Interval = interval
+		default:
+			return rule, ErrInvalidRule
+		}
+	}
+	if rule.Freq == "" {
+		return rule, ErrInvalidRule
+	}
+	return rule, nil
+}
+
+// Occurrence is the nth occurrence after start, start itself being the
+// 0th. Monthly occurrences fall on the last day of months shorter than
+// start's day of month.
+func (r Rule) Occurrence(start time.Time, n int) time.Time {
+	switch r.Freq {
+	case Daily:
+		return start.AddDate(0, 0, n*r.Interval)
+	case Weekly:
+		return start.AddDate(0, 0, 7*n*r.Interval)
+	}
+	first := time.Date(start.Year(), start.Month()+time.Month(n*r.Interval), 1,
+		start.Hour(), start.Minute(), start.Second(), start.Nanosecond(), start.Location())
+	day := start.Day()
+	if last := first.AddDate(0, 1, -1).Day(); day > last {
+		day = last
+	}
+	return first.AddDate(0, 0, day-1)
+}
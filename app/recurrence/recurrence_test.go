@@ -0,0 +1,52 @@
+package recurrence
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		input string
+		want  Rule
+	}{
+		{"FREQ=DAILY", Rule{Daily, 1}},
+		{"RRULE:FREQ=WEEKLY;INTERVAL=2", Rule{Weekly, 2}},
+		{"freq=monthly;interval=3", Rule{Monthly, 3}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			got, err := Parse(tt.input)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestParseRejectsInvalidRules(t *testing.T) {
+	for _, input := range []string{"", "FREQ=YEARLY", "INTERVAL=2", "FREQ=DAILY;INTERVAL=0", "FREQ=DAILY;BYDAY=MO", "FREQ"} {
+		_, err := Parse(input)
+		assert.Equal(t, ErrInvalidRule, err, input)
+	}
+}
+
+func TestOccurrence(t *testing.T) {
+	start := time.Date(2020, time.January, 31, 9, 30, 0, 0, time.UTC)
+	tests := []struct {
+		rule Rule
+		n    int
+		want time.Time
+	}{
+		{Rule{Daily, 1}, 0, start},
+		{Rule{Daily, 3}, 2, time.Date(2020, time.February, 6, 9, 30, 0, 0, time.UTC)},
+		{Rule{Weekly, 1}, 1, time.Date(2020, time.February, 7, 9, 30, 0, 0, time.UTC)},
+		{Rule{Monthly, 1}, 1, time.Date(2020, time.February, 29, 9, 30, 0, 0, time.UTC)},
+		{Rule{Monthly, 1}, 2, time.Date(2020, time.March, 31, 9, 30, 0, 0, time.UTC)},
+		{Rule{Monthly, 6}, 2, time.Date(2021, time.January, 31, 9, 30, 0, 0, time.UTC)},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, tt.rule.Occurrence(start, tt.n))
+	}
+}
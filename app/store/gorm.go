@@ -0,0 +1,582 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+	"time"
+	"wallet/app/constant"
+	"wallet/app/model"
+	"wallet/app/money"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/jinzhu/gorm"
+)
+
+// GormStore is the Store backed by MySQL through gorm.
+type GormStore struct {
+	db   *gorm.DB
+	inTx bool
+	ctx  context.Context
+}
+
+func NewGormStore(db *gorm.DB) *GormStore {
+	return &GormStore{db: db}
+}
+
+func (s *GormStore) WithContext(ctx context.Context) Store {
+	return &GormStore{db: s.db, inTx: s.inTx, ctx: ctx}
+}
+
+func (s *GormStore) Atomic(fn func(tx Store) error) error {
+	if s.inTx {
+		return fn(s)
+	}
+	ctx := s.ctx
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	tx := s.db.BeginTx(ctx, nil)
+	if err := tx.Error; err != nil {
+		return err
+	}
+	defer rollbackOnPanic(tx)
+	if err := fn(&GormStore{db: tx, inTx: true, ctx: ctx}); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit().Error
+}
+
+// rollbackOnPanic rolls the transaction back and lets the panic go on, so it
+// reaches the caller rather than Atomic returning as if fn had succeeded.
+func rollbackOnPanic(tx *gorm.DB) {
+	if r := recover(); r != nil {
+		tx.Rollback()
+		panic(r)
+	}
+}
+
+// read runs fn, which only reads, on the DB. Gorm does not pass contexts to
+// queries, so outside a DB transaction a store bound to a context reads in a
+// read-only transaction begun with it: the reads then end with the context.
+func (s *GormStore) read(fn func(db *gorm.DB) error) error {
+	if s.inTx || s.ctx == nil {
+		return fn(s.db)
+	}
+	tx := s.db.BeginTx(s.ctx, &sql.TxOptions{ReadOnly: true})
+	if err := tx.Error; err != nil {
+		return err
+	}
+	defer rollbackOnPanic(tx)
+	if err := fn(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit().Error
+}
+
+func (s *GormStore) forUpdate() *gorm.DB {
+	return s.db.Set("gorm:query_option", "FOR UPDATE")
+}
+
+func notFound(err error) error {
+	if gorm.IsRecordNotFoundError(err) {
+		return ErrNotFound
+	}
+	return err
+}
+
+// duplicateKey maps MySQL's duplicate entry error to ErrDuplicateKey.
+func duplicateKey(err error) error {
+	if mysqlErr, ok := err.(*mysql.MySQLError); ok && mysqlErr.Number == 1062 {
+		return ErrDuplicateKey
+	}
+	return err
+}
+
+func (s *GormStore) CreateOwner(owner *model.Owner) error {
+	return duplicateKey(s.db.Create(owner).Error)
+}
+
+func (s *GormStore) GetOwner(id uint) (model.Owner, error) {
+	owner := model.Owner{}
+	err := s.read(func(db *gorm.DB) error {
+		return db.First(&owner, "id = ?", id).Error
+	})
+	return owner, notFound(err)
+}
+
+func (s *GormStore) CreateWallet(wallet *model.Wallet) error {
+	return s.db.Create(wallet).Error
+}
+
+func (s *GormStore) GetWallet(id uint) (model.Wallet, error) {
+	wallet := model.Wallet{}
+	err := s.read(func(db *gorm.DB) error {
+		return db.First(&wallet, "id = ?", id).Error
+	})
+	return wallet, notFound(err)
+}
+
+func (s *GormStore) ListWallets() ([]model.Wallet, error) {
+	var wallets []model.Wallet
+	err := s.read(func(db *gorm.DB) error {
+		return db.Order("id").Find(&wallets).Error
+	})
+	return wallets, err
+}
+
+func (s *GormStore) LockWallet(id uint) (model.Wallet, error) {
+	wallet := model.Wallet{}
+	err := s.forUpdate().First(&wallet, "id = ?", id).Error
+	return wallet, notFound(err)
+}
+
+// UpdateWalletBalances, like UpdateRefund and UpdateHold, calls BeforeSave
+// itself: gorm reads the map of columns before it runs the hook.
+func (s *GormStore) UpdateWalletBalances(wallet *model.Wallet) error {
+	if err := wallet.BeforeSave(); err != nil {
+		return err
+	}
+	return s.db.Model(wallet).Updates(map[string]interface{}{
+		"balance":      wallet.Balance,
+		"held_balance": wallet.HeldBalance,
+	}).Error
+}
+
+func (s *GormStore) UpdateWalletStatus(wallet *model.Wallet) error {
+	return s.db.Model(wallet).Updates(map[string]interface{}{
+		"status":        wallet.Status,
+		"status_reason": wallet.StatusReason,
+	}).Error
+}
+
+func (s *GormStore) UpdateWalletChainHash(wallet *model.Wallet) error {
+	return s.db.Model(wallet).Update("chain_hash", wallet.ChainHash).Error
+}
+
+// EnsureAccount reads the account back with a locking read so that it sees a
+// row committed by a concurrent insert that INSERT IGNORE skipped.
+func (s *GormStore) EnsureAccount(account *model.Account) error {
+	if err := s.db.Set("gorm:insert_modifier", "IGNORE").Create(account).Error; err != nil {
+		return err
+	}
+	code, currency := account.Code, account.Currency
+	*account = model.Account{}
+	return s.db.Set("gorm:query_option", "LOCK IN SHARE MODE").
+		Where("code = ? AND currency = ?", code, currency).First(account).Error
+}
+
+func (s *GormStore) GetAccount(code, currency string) (model.Account, error) {
+	account := model.Account{}
+	err := s.read(func(db *gorm.DB) error {
+		return db.Where("code = ? AND currency = ?", code, currency).First(&account).Error
+	})
+	return account, notFound(err)
+}
+
+func (s *GormStore) CreateJournalEntry(entry *model.JournalEntry) error {
+	if !entry.Balanced() {
+		return ErrUnbalancedEntry
+	}
+	if err := s.db.Create(entry).Error; err != nil {
+		return err
+	}
+	for i := range entry.Postings {
+		entry.Postings[i].JournalEntryId = entry.ID
+		if err := s.db.Create(&entry.Postings[i]).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *GormStore) AccountBalance(account model.Account) (money.Money, error) {
+	exponent, err := money.CurrencyExponent(account.Currency)
+	if err != nil {
+		return money.Money{}, err
+	}
+	var sum struct{ Balance int64 }
+	err = s.read(func(db *gorm.DB) error {
+		return db.Model(&model.Posting{}).
+			Select("COALESCE(SUM(CASE WHEN direction = ? THEN amount ELSE -amount END), 0) AS balance", constant.CREDIT).
+			Where("account_id = ?", account.ID).Scan(&sum).Error
+	})
+	return money.New(sum.Balance, exponent), err
+}
+
+// CreateTransaction stores CreatedAt in whole seconds, as the DATETIME column
+// keeps it, so that the transaction's hash is computed from the stored value.
+func (s *GormStore) CreateTransaction(transaction *model.Transaction) error {
+	if transaction.CreatedAt.IsZero() {
+		transaction.CreatedAt = time.Now().Truncate(time.Second)
+	}
+	return s.db.Create(transaction).Error
+}
+
+func (s *GormStore) GetTransaction(id uint) (model.Transaction, error) {
+	transaction := model.Transaction{}
+	err := s.read(func(db *gorm.DB) error {
+		return db.First(&transaction, "id = ?", id).Error
+	})
+	return transaction, notFound(err)
+}
+
+func (s *GormStore) LockTransaction(id uint) (model.Transaction, error) {
+	transaction := model.Transaction{}
+	err := s.forUpdate().First(&transaction, "id = ?", id).Error
+	return transaction, notFound(err)
+}
+
+func (s *GormStore) UpdateRefund(transaction *model.Transaction) error {
+	if err := transaction.BeforeSave(); err != nil {
+		return err
+	}
+	return s.db.Model(transaction).Updates(map[string]interface{}{
+		"refunded_amount": transaction.RefundedAmount,
+		"reversal_state":  transaction.ReversalState,
+	}).Error
+}
+
+func (s *GormStore) UpdateTransactionHash(transaction *model.Transaction) error {
+	return s.db.Model(transaction).Updates(map[string]interface{}{
+		"prev_hash": transaction.PrevHash,
+		"hash":      transaction.Hash,
+	}).Error
+}
+
+var likeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
+func (s *GormStore) ListTransactions(walletId uint, filter TransactionFilter) ([]model.Transaction, error) {
+	var transactions []model.Transaction
+	err := s.read(func(db *gorm.DB) error {
+		db = db.Where("wallet_id = ?", walletId)
+		if filter.After != nil {
+			db = db.Where("created_at < ? OR (created_at = ? AND id < ?)", filter.After.CreatedAt, filter.After.CreatedAt, filter.After.ID)
+		}
+		if filter.Type != "" {
+			db = db.Where("type = ?", filter.Type)
+		}
+		if filter.MinAmount != nil {
+			db = db.Where("amount >= ?", *filter.MinAmount)
+		}
+		if filter.MaxAmount != nil {
+			db = db.Where("amount <= ?", *filter.MaxAmount)
+		}
+		if filter.From != nil {
+			db = db.Where("created_at >= ?", *filter.From)
+		}
+		if filter.To != nil {
+			db = db.Where("created_at < ?", *filter.To)
+		}
+		if filter.Description != "" {
+			db = db.Where("description LIKE ?", "%"+likeEscaper.Replace(filter.Description)+"%")
+		}
+		if filter.Limit > 0 {
+			db = db.Limit(filter.Limit)
+		}
+		return db.Order("created_at desc, id desc").Find(&transactions).Error
+	})
+	return transactions, err
+}
+
+func (s *GormStore) CreateTransfer(transfer *model.Transfer) error {
+	return s.db.Create(transfer).Error
+}
+
+func (s *GormStore) GetTransfer(id uint) (model.Transfer, error) {
+	transfer := model.Transfer{}
+	err := s.read(func(db *gorm.DB) error {
+		return db.First(&transfer, "id = ?", id).Error
+	})
+	return transfer, notFound(err)
+}
+
+func (s *GormStore) LockTransferLegs(transferId uint) ([]model.Transaction, error) {
+	var legs []model.Transaction
+	err := s.forUpdate().Where("transfer_id = ?", transferId).Order("id").Find(&legs).Error
+	return legs, err
+}
+
+func (s *GormStore) CreateHold(hold *model.Hold) error {
+	return s.db.Create(hold).Error
+}
+
+func (s *GormStore) GetHold(id uint) (model.Hold, error) {
+	hold := model.Hold{}
+	err := s.read(func(db *gorm.DB) error {
+		return db.First(&hold, "id = ?", id).Error
+	})
+	return hold, notFound(err)
+}
+
+func (s *GormStore) LockHold(id uint) (model.Hold, error) {
+	hold := model.Hold{}
+	err := s.forUpdate().First(&hold, "id = ?", id).Error
+	return hold, notFound(err)
+}
+
+func (s *GormStore) UpdateHold(hold *model.Hold) error {
+	if err := hold.BeforeSave(); err != nil {
+		return err
+	}
+	return s.db.Model(hold).Updates(map[string]interface{}{
+		"captured_amount": hold.CapturedAmount,
+		"status":          hold.Status,
+		"transaction_id":  hold.TransactionId,
+	}).Error
+}
+
+func (s *GormStore) ListExpiredHolds(now time.Time) ([]model.Hold, error) {
+	var holds []model.Hold
+	err := s.read(func(db *gorm.DB) error {
+		return db.Where("status = ? AND expires_at <= ?", constant.HOLD_ACTIVE, now).Find(&holds).Error
+	})
+	return holds, err
+}
+
+func (s *GormStore) GetIdempotencyKey(subject, key string) (model.IdempotencyKey, error) {
+	record := model.IdempotencyKey{}
+	err := s.read(func(db *gorm.DB) error {
+		return db.Where("subject = ? AND idempotency_key = ?", subject, key).First(&record).Error
+	})
+	return record, notFound(err)
+}
+
+func (s *GormStore) CreateIdempotencyKey(record *model.IdempotencyKey) error {
+	return s.db.Create(record).Error
+}
+
+func (s *GormStore) DeleteExpiredIdempotencyKey(subject, key string, now time.Time) error {
+	return s.db.Where("subject = ? AND idempotency_key = ? AND expires_at <= ?", subject, key, now).Delete(&model.IdempotencyKey{}).Error
+}
+
+func (s *GormStore) DeleteExpiredIdempotencyKeys(now time.Time) error {
+	return s.db.Where("expires_at <= ?", now).Delete(&model.IdempotencyKey{}).Error
+}
+
+func (s *GormStore) GetLimitProfile(currency string) (model.LimitProfile, error) {
+	profile := model.LimitProfile{}
+	err := s.read(func(db *gorm.DB) error {
+		return db.Where("currency = ?", currency).First(&profile).Error
+	})
+	return profile, notFound(err)
+}
+
+func (s *GormStore) SaveLimitProfile(profile *model.LimitProfile) error {
+	return duplicateKey(s.db.Save(profile).Error)
+}
+
+func (s *GormStore) GetWalletLimit(walletId uint) (model.WalletLimit, error) {
+	limit := model.WalletLimit{}
+	err := s.read(func(db *gorm.DB) error {
+		return db.Where("wallet_id = ?", walletId).First(&limit).Error
+	})
+	return limit, notFound(err)
+}
+
+func (s *GormStore) SaveWalletLimit(limit *model.WalletLimit) error {
+	return duplicateKey(s.db.Save(limit).Error)
+}
+
+func (s *GormStore) SumTransactions(wallet model.Wallet, transactionType string, since time.Time) (money.Money, error) {
+	exponent, err := money.CurrencyExponent(wallet.Currency)
+	if err != nil {
+		return money.Money{}, err
+	}
+	var sum struct{ Total int64 }
+	err = s.read(func(db *gorm.DB) error {
+		return db.Model(&model.Transaction{}).
+			Select("COALESCE(SUM(amount), 0) AS total").
+			Where("wallet_id = ? AND type = ? AND created_at >= ? AND reversed_transaction_id IS NULL", wallet.ID, transactionType, since).
+			Scan(&sum).Error
+	})
+	return money.New(sum.Total, exponent), err
+}
+
+func (s *GormStore) GetFeeSchedule(transactionType, currency string) (model.FeeSchedule, error) {
+	schedule := model.FeeSchedule{}
+	err := s.read(func(db *gorm.DB) error {
+		return db.Where("transaction_type = ? AND currency = ?", transactionType, currency).First(&schedule).Error
+	})
+	return schedule, notFound(err)
+}
+
+func (s *GormStore) SaveFeeSchedule(schedule *model.FeeSchedule) error {
+	return duplicateKey(s.db.Save(schedule).Error)
+}
+
+func (s *GormStore) LockFeeTransactions(transactionId uint) ([]model.Transaction, error) {
+	var fees []model.Transaction
+	err := s.forUpdate().Where("fee_of_transaction_id = ?", transactionId).Order("id").Find(&fees).Error
+	return fees, err
+}
+
+func (s *GormStore) CreateSchedule(schedule *model.Schedule) error {
+	return s.db.Create(schedule).Error
+}
+
+func (s *GormStore) GetSchedule(id uint) (model.Schedule, error) {
+	schedule := model.Schedule{}
+	err := s.read(func(db *gorm.DB) error {
+		return db.First(&schedule, "id = ?", id).Error
+	})
+	return schedule, notFound(err)
+}
+
+func (s *GormStore) LockSchedule(id uint) (model.Schedule, error) {
+	schedule := model.Schedule{}
+	err := s.forUpdate().First(&schedule, "id = ?", id).Error
+	return schedule, notFound(err)
+}
+
+func (s *GormStore) UpdateSchedule(schedule *model.Schedule) error {
+	return s.db.Model(schedule).Updates(map[string]interface{}{
+		"runs":        schedule.Runs,
+		"next_run_at": schedule.NextRunAt,
+		"attempts":    schedule.Attempts,
+		"last_error":  schedule.LastError,
+		"status":      schedule.Status,
+	}).Error
+}
+
+func (s *GormStore) ListDueSchedules(now time.Time) ([]model.Schedule, error) {
+	var schedules []model.Schedule
+	err := s.read(func(db *gorm.DB) error {
+		return db.Where("status = ? AND next_run_at <= ?", constant.SCHEDULE_ACTIVE, now).Order("next_run_at, id").Find(&schedules).Error
+	})
+	return schedules, err
+}
+
+func (s *GormStore) CreateScheduleRun(run *model.ScheduleRun) error {
+	return duplicateKey(s.db.Create(run).Error)
+}
+
+func (s *GormStore) ListScheduleRuns(scheduleId uint) ([]model.ScheduleRun, error) {
+	var runs []model.ScheduleRun
+	err := s.read(func(db *gorm.DB) error {
+		return db.Where("schedule_id = ?", scheduleId).Order("occurrence").Find(&runs).Error
+	})
+	return runs, err
+}
+
+func (s *GormStore) CreateEvent(event *model.Event) error {
+	return s.db.Create(event).Error
+}
+
+func (s *GormStore) GetEvent(id uint) (model.Event, error) {
+	event := model.Event{}
+	err := s.read(func(db *gorm.DB) error {
+		return db.First(&event, "id = ?", id).Error
+	})
+	return event, notFound(err)
+}
+
+func (s *GormStore) ListUndispatchedEvents(limit int) ([]model.Event, error) {
+	var events []model.Event
+	err := s.read(func(db *gorm.DB) error {
+		return db.Where("dispatched = ?", false).Order("id").Limit(limit).Find(&events).Error
+	})
+	return events, err
+}
+
+func (s *GormStore) MarkEventDispatched(id uint) error {
+	return s.db.Model(&model.Event{}).Where("id = ?", id).Update("dispatched", true).Error
+}
+
+func (s *GormStore) CreateWebhookSubscription(subscription *model.WebhookSubscription) error {
+	return s.db.Create(subscription).Error
+}
+
+func (s *GormStore) GetWebhookSubscription(id uint) (model.WebhookSubscription, error) {
+	subscription := model.WebhookSubscription{}
+	err := s.read(func(db *gorm.DB) error {
+		return db.First(&subscription, "id = ?", id).Error
+	})
+	return subscription, notFound(err)
+}
+
+func (s *GormStore) ListWebhookSubscriptions() ([]model.WebhookSubscription, error) {
+	var subscriptions []model.WebhookSubscription
+	err := s.read(func(db *gorm.DB) error {
+		return db.Order("id").Find(&subscriptions).Error
+	})
+	return subscriptions, err
+}
+
+func (s *GormStore) UpdateWebhookSubscription(subscription *model.WebhookSubscription) error {
+	return s.db.Model(subscription).Update("active", subscription.Active).Error
+}
+
+func (s *GormStore) CreateWebhookDelivery(delivery *model.WebhookDelivery) error {
+	return duplicateKey(s.db.Create(delivery).Error)
+}
+
+func (s *GormStore) GetWebhookDelivery(eventId, subscriptionId uint) (model.WebhookDelivery, error) {
+	delivery := model.WebhookDelivery{}
+	err := s.read(func(db *gorm.DB) error {
+		return db.Where("event_id = ? AND subscription_id = ?", eventId, subscriptionId).First(&delivery).Error
+	})
+	return delivery, notFound(err)
+}
+
+func (s *GormStore) UpdateWebhookDelivery(delivery *model.WebhookDelivery) error {
+	return s.db.Model(delivery).Updates(map[string]interface{}{
+		"status":          delivery.Status,
+		"attempts":        delivery.Attempts,
+		"next_attempt_at": delivery.NextAttemptAt,
+		"response_code":   delivery.ResponseCode,
+		"last_error":      delivery.LastError,
+	}).Error
+}
+
+func (s *GormStore) ListDueWebhookDeliveries(now time.Time, limit int) ([]model.WebhookDelivery, error) {
+	var deliveries []model.WebhookDelivery
+	err := s.read(func(db *gorm.DB) error {
+		return db.Where("status = ? AND next_attempt_at <= ?", constant.DELIVERY_PENDING, now).
+			Order("next_attempt_at, id").Limit(limit).Find(&deliveries).Error
+	})
+	return deliveries, err
+}
+
+func (s *GormStore) ListWebhookDeliveries(status string) ([]model.WebhookDelivery, error) {
+	var deliveries []model.WebhookDelivery
+	err := s.read(func(db *gorm.DB) error {
+		return db.Where("status = ?", status).Order("id").Find(&deliveries).Error
+	})
+	return deliveries, err
+}
+
+func (s *GormStore) CreateAuditRecord(record *model.AuditRecord) error {
+	return s.db.Create(record).Error
+}
+
+func (s *GormStore) ListAuditRecords(filter AuditFilter) ([]model.AuditRecord, error) {
+	var records []model.AuditRecord
+	err := s.read(func(db *gorm.DB) error {
+		if filter.BeforeId != 0 {
+			db = db.Where("id < ?", filter.BeforeId)
+		}
+		if filter.Actor != "" {
+			db = db.Where("actor = ?", filter.Actor)
+		}
+		if filter.Action != "" {
+			db = db.Where("action = ?", filter.Action)
+		}
+		if filter.WalletId != nil {
+			db = db.Where("wallet_id = ?", *filter.WalletId)
+		}
+		if filter.From != nil {
+			db = db.Where("created_at >= ?", *filter.From)
+		}
+		if filter.To != nil {
+			db = db.Where("created_at < ?", *filter.To)
+		}
+		if filter.Limit > 0 {
+			db = db.Limit(filter.Limit)
+		}
+		return db.Order("id desc").Find(&records).Error
+	})
+	return records, err
+}
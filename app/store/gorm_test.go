@@ -0,0 +1,174 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+	"wallet/app/model"
+	"wallet/app/money"
+
+	sqlmock "github.com/DATA-DOG/go-sqlmock"
+	"github.com/jinzhu/gorm"
+	"github.com/stretchr/testify/assert"
+)
+
+func newMockStore(t *testing.T) (*GormStore, sqlmock.Sqlmock) {
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	database, err := gorm.Open("mysql", db)
+	assert.NoError(t, err)
+	return NewGormStore(database), mock
+}
+
+func TestGormStoreAtomicCommitsAndLocksRows(t *testing.T) {
+	s, mock := newMockStore(t)
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT (.+) FROM `wallets` (.+) FOR UPDATE").WithArgs(3).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "balance", "held_balance", "currency"}).AddRow(3, 1000, 200, "USD"))
+	mock.ExpectExec("UPDATE `wallets` SET `balance` = \\?, `held_balance` = \\?").
+		WithArgs(int64(1500), int64(200), sqlmock.AnyArg(), 3).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	err := s.Atomic(func(tx Store) error {
+		wallet, err := tx.LockWallet(3)
+		if err != nil {
+			return err
+		}
+		wallet.Balance = wallet.Balance.Add(money.New(500, 2))
+		return tx.UpdateWalletBalances(&wallet)
+	})
+
+	assert.NoError(t, err)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestGormStoreAtomicRollsBackOnError(t *testing.T) {
+	s, mock := newMockStore(t)
+	failure := errors.New("failed")
+	mock.ExpectBegin()
+	mock.ExpectRollback()
+
+	err := s.Atomic(func(tx Store) error {
+		return tx.Atomic(func(Store) error { return failure })
+	})
+
+	assert.Equal(t, failure, err)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestGormStoreAtomicRollsBackAndRepanics(t *testing.T) {
+	s, mock := newMockStore(t)
+	mock.ExpectBegin()
+	mock.ExpectRollback()
+
+	assert.PanicsWithValue(t, "boom", func() {
+		s.Atomic(func(tx Store) error { panic("boom") })
+	})
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestGormStoreMapsMissingRecordsToErrNotFound(t *testing.T) {
+	s, mock := newMockStore(t)
+	mock.ExpectQuery("SELECT (.+) FROM `transactions`").WillReturnRows(sqlmock.NewRows([]string{"id"}))
+
+	_, err := s.GetTransaction(4)
+
+	assert.Equal(t, ErrNotFound, err)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestGormStoreUpdateRefund(t *testing.T) {
+	s, mock := newMockStore(t)
+	mock.ExpectBegin()
+	mock.ExpectExec("UPDATE `transactions` SET `refunded_amount` = \\?, `reversal_state` = \\?").
+		WithArgs(int64(5000), "PARTIALLY_REVERSED", sqlmock.AnyArg(), 4).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	transaction := model.Transaction{RefundedAmount: money.New(5000, 2), ReversalState: "PARTIALLY_REVERSED"}
+	transaction.ID = 4
+
+	assert.NoError(t, s.UpdateRefund(&transaction))
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestGormStoreListTransactionsAppliesFilter(t *testing.T) {
+	s, mock := newMockStore(t)
+	createdAt := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	minAmount := money.New(1000, 2)
+	mock.ExpectQuery("SELECT (.+) FROM `transactions` WHERE (.+)wallet_id = \\?(.+)created_at < \\? OR \\(created_at = \\? AND id < \\?\\)(.+)type = \\?(.+)amount >= \\?(.+)description LIKE \\?(.+) ORDER BY created_at desc, id desc LIMIT 3").
+		WithArgs(1, createdAt, createdAt, 8, "DEBIT", int64(1000), `%50\%%`).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "amount", "currency"}).AddRow(7, 1000, "JPY"))
+
+	transactions, err := s.ListTransactions(1, TransactionFilter{
+		Limit:       3,
+		After:       &TransactionCursor{CreatedAt: createdAt, ID: 8},
+		Type:        "DEBIT",
+		MinAmount:   &minAmount,
+		Description: "50%",
+	})
+
+	assert.NoError(t, err)
+	assert.Equal(t, money.New(1000, 0), transactions[0].Amount)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestGormStoreReadsWithinTheContext(t *testing.T) {
+	s, mock := newMockStore(t)
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT (.+) FROM `transactions` WHERE (.+)wallet_id = \\?").WithArgs(1).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "amount", "currency"}).AddRow(7, 1000, "USD"))
+	mock.ExpectCommit()
+
+	transactions, err := s.WithContext(context.Background()).ListTransactions(1, TransactionFilter{})
+
+	assert.NoError(t, err)
+	assert.Len(t, transactions, 1)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestGormStoreReadsAbortOnceTheContextIsDone(t *testing.T) {
+	s, mock := newMockStore(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	_, err := s.WithContext(ctx).ListTransactions(1, TransactionFilter{})
+	assert.Equal(t, context.Canceled, err)
+	_, err = s.WithContext(ctx).ListAuditRecords(AuditFilter{})
+	assert.Equal(t, context.Canceled, err)
+
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestGormStoreSavesAmountsInMinorUnitsOfTheRowCurrency(t *testing.T) {
+	s, mock := newMockStore(t)
+	for _, wallet := range []struct {
+		currency string
+		balance  string
+		units    int64
+	}{
+		{"JPY", "1500", 1500},
+		{"USD", "12.5", 1250},
+	} {
+		balance, err := money.Parse(wallet.balance)
+		assert.NoError(t, err)
+		mock.ExpectBegin()
+		mock.ExpectExec("INSERT INTO `wallets`").
+			WithArgs(sqlmock.AnyArg(), sqlmock.AnyArg(), nil, nil, wallet.currency, wallet.units, int64(0), "ACTIVE", "", "").
+			WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectCommit()
+
+		assert.NoError(t, s.CreateWallet(&model.Wallet{Currency: wallet.currency, Balance: balance, Status: "ACTIVE"}))
+	}
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestGormStoreRefusesAmountsFinerThanTheRowCurrency(t *testing.T) {
+	s, mock := newMockStore(t)
+	mock.ExpectBegin()
+	mock.ExpectRollback()
+
+	err := s.CreateWallet(&model.Wallet{Currency: "JPY", Balance: money.New(1255, 2), Status: "ACTIVE"})
+
+	assert.Equal(t, money.ErrPrecision, err)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
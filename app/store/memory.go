@@ -0,0 +1,1001 @@
+package store
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"wallet/app/constant"
+	"wallet/app/model"
+	"wallet/app/money"
+)
+
+// MemoryStore keeps everything in process memory. Atomic calls run one at a
+// time, which stands in for row locks, and write in place, keeping a log of
+// what they overwrote to restore it when they fail.
+type MemoryStore struct {
+	mu    *sync.Mutex
+	state *memoryState
+	inTx  bool
+	ctx   context.Context
+	undo  *undoLog
+}
+
+type memoryState struct {
+	lastId          uint
+	owners          map[uint]model.Owner
+	limitProfiles   map[uint]model.LimitProfile
+	walletLimits    map[uint]model.WalletLimit
+	feeSchedules    map[uint]model.FeeSchedule
+	schedules       map[uint]model.Schedule
+	scheduleRuns    map[uint]model.ScheduleRun
+	events          map[uint]model.Event
+	subscriptions   map[uint]model.WebhookSubscription
+	deliveries      map[uint]model.WebhookDelivery
+	auditRecords    map[uint]model.AuditRecord
+	accounts        map[uint]model.Account
+	journalEntries  map[uint]model.JournalEntry
+	postings        map[uint]model.Posting
+	wallets         map[uint]model.Wallet
+	transactions    map[uint]model.Transaction
+	transfers       map[uint]model.Transfer
+	holds           map[uint]model.Hold
+	idempotencyKeys map[idempotencyKeyId]model.IdempotencyKey
+}
+
+// idempotencyKeyId is the primary key of an idempotency key.
+type idempotencyKeyId struct {
+	subject, key string
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		mu: &sync.Mutex{},
+		state: &memoryState{
+			owners:          make(map[uint]model.Owner),
+			limitProfiles:   make(map[uint]model.LimitProfile),
+			walletLimits:    make(map[uint]model.WalletLimit),
+			feeSchedules:    make(map[uint]model.FeeSchedule),
+			schedules:       make(map[uint]model.Schedule),
+			scheduleRuns:    make(map[uint]model.ScheduleRun),
+			events:          make(map[uint]model.Event),
+			subscriptions:   make(map[uint]model.WebhookSubscription),
+			deliveries:      make(map[uint]model.WebhookDelivery),
+			auditRecords:    make(map[uint]model.AuditRecord),
+			accounts:        make(map[uint]model.Account),
+			journalEntries:  make(map[uint]model.JournalEntry),
+			postings:        make(map[uint]model.Posting),
+			wallets:         make(map[uint]model.Wallet),
+			transactions:    make(map[uint]model.Transaction),
+			transfers:       make(map[uint]model.Transfer),
+			holds:           make(map[uint]model.Hold),
+			idempotencyKeys: make(map[idempotencyKeyId]model.IdempotencyKey),
+		},
+	}
+}
+
+// undoLog records how to take back the writes of a DB transaction, so it can
+// work on the data in place and still roll back.
+type undoLog struct {
+	lastId uint
+	steps  []func()
+}
+
+// remember records the value m holds at key before a DB transaction of s
+// writes it. Outside a DB transaction there is nothing to roll back.
+func remember[K comparable, V any](s *MemoryStore, m map[K]V, key K) {
+	if s.undo == nil {
+		return
+	}
+	previous, ok := m[key]
+	s.undo.steps = append(s.undo.steps, func() {
+		if ok {
+			m[key] = previous
+		} else {
+			delete(m, key)
+		}
+	})
+}
+
+// rollback restores state as it was when the log was started.
+func (u *undoLog) rollback(state *memoryState) {
+	for i := len(u.steps) - 1; i >= 0; i-- {
+		u.steps[i]()
+	}
+	state.lastId = u.lastId
+}
+
+// nextId hands out IDs from one sequence shared by all record types.
+func (s *memoryState) nextId() uint {
+	s.lastId++
+	return s.lastId
+}
+
+// lock serialises access outside Atomic; inside it the lock is already held.
+func (s *MemoryStore) lock() func() {
+	if s.inTx {
+		return func() {}
+	}
+	s.mu.Lock()
+	return s.mu.Unlock
+}
+
+func (s *MemoryStore) WithContext(ctx context.Context) Store {
+	return &MemoryStore{mu: s.mu, state: s.state, inTx: s.inTx, ctx: ctx, undo: s.undo}
+}
+
+func (s *MemoryStore) Atomic(fn func(tx Store) error) error {
+	if s.inTx {
+		return fn(s)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	tx := &MemoryStore{mu: s.mu, state: s.state, inTx: true, ctx: s.ctx, undo: &undoLog{lastId: s.state.lastId}}
+	committed := false
+	defer func() {
+		if !committed {
+			tx.undo.rollback(s.state)
+		}
+	}()
+	if err := fn(tx); err != nil {
+		return err
+	}
+	if s.ctx != nil && s.ctx.Err() != nil {
+		return s.ctx.Err()
+	}
+	committed = true
+	return nil
+}
+
+func (s *MemoryStore) CreateOwner(owner *model.Owner) error {
+	defer s.lock()()
+	for _, existing := range s.state.owners {
+		if owner.Email != "" && existing.Email == owner.Email {
+			return ErrDuplicateKey
+		}
+	}
+	owner.ID = s.state.nextId()
+	owner.CreatedAt = time.Now()
+	owner.UpdatedAt = owner.CreatedAt
+	remember(s, s.state.owners, owner.ID)
+	s.state.owners[owner.ID] = *owner
+	return nil
+}
+
+func (s *MemoryStore) GetOwner(id uint) (model.Owner, error) {
+	defer s.lock()()
+	owner, ok := s.state.owners[id]
+	if !ok {
+		return owner, ErrNotFound
+	}
+	return owner, nil
+}
+
+func (s *MemoryStore) CreateWallet(wallet *model.Wallet) error {
+	defer s.lock()()
+	if wallet.Currency == "" {
+		wallet.Currency = constant.DEFAULT_CURRENCY
+	}
+	if wallet.Status == "" {
+		wallet.Status = constant.WALLET_ACTIVE
+	}
+	wallet.ID = s.state.nextId()
+	wallet.CreatedAt = time.Now()
+	wallet.UpdatedAt = wallet.CreatedAt
+	remember(s, s.state.wallets, wallet.ID)
+	s.state.wallets[wallet.ID] = *wallet
+	return wallet.AfterCreate()
+}
+
+func (s *MemoryStore) GetWallet(id uint) (model.Wallet, error) {
+	defer s.lock()()
+	wallet, ok := s.state.wallets[id]
+	if !ok {
+		return wallet, ErrNotFound
+	}
+	return wallet, wallet.AfterFind()
+}
+
+func (s *MemoryStore) ListWallets() ([]model.Wallet, error) {
+	defer s.lock()()
+	var wallets []model.Wallet
+	for _, wallet := range s.state.wallets {
+		if err := wallet.AfterFind(); err != nil {
+			return nil, err
+		}
+		wallets = append(wallets, wallet)
+	}
+	sort.Slice(wallets, func(i, j int) bool { return wallets[i].ID < wallets[j].ID })
+	return wallets, nil
+}
+
+func (s *MemoryStore) LockWallet(id uint) (model.Wallet, error) {
+	return s.GetWallet(id)
+}
+
+func (s *MemoryStore) UpdateWalletBalances(wallet *model.Wallet) error {
+	defer s.lock()()
+	stored, ok := s.state.wallets[wallet.ID]
+	if !ok {
+		return ErrNotFound
+	}
+	stored.Balance = wallet.Balance
+	stored.HeldBalance = wallet.HeldBalance
+	stored.UpdatedAt = time.Now()
+	remember(s, s.state.wallets, wallet.ID)
+	s.state.wallets[wallet.ID] = stored
+	return nil
+}
+
+func (s *MemoryStore) UpdateWalletChainHash(wallet *model.Wallet) error {
+	defer s.lock()()
+	stored, ok := s.state.wallets[wallet.ID]
+	if !ok {
+		return ErrNotFound
+	}
+	stored.ChainHash = wallet.ChainHash
+	stored.UpdatedAt = time.Now()
+	remember(s, s.state.wallets, wallet.ID)
+	s.state.wallets[wallet.ID] = stored
+	return nil
+}
+
+func (s *MemoryStore) UpdateWalletStatus(wallet *model.Wallet) error {
+	defer s.lock()()
+	stored, ok := s.state.wallets[wallet.ID]
+	if !ok {
+		return ErrNotFound
+	}
+	stored.Status = wallet.Status
+	stored.StatusReason = wallet.StatusReason
+	stored.UpdatedAt = time.Now()
+	remember(s, s.state.wallets, wallet.ID)
+	s.state.wallets[wallet.ID] = stored
+	return nil
+}
+
+func (s *MemoryStore) EnsureAccount(account *model.Account) error {
+	defer s.lock()()
+	if stored, ok := s.state.findAccount(account.Code, account.Currency); ok {
+		*account = stored
+		return nil
+	}
+	account.ID = s.state.nextId()
+	account.CreatedAt = time.Now()
+	account.UpdatedAt = account.CreatedAt
+	remember(s, s.state.accounts, account.ID)
+	s.state.accounts[account.ID] = *account
+	return nil
+}
+
+func (s *memoryState) findAccount(code, currency string) (model.Account, bool) {
+	for _, account := range s.accounts {
+		if account.Code == code && account.Currency == currency {
+			return account, true
+		}
+	}
+	return model.Account{}, false
+}
+
+func (s *MemoryStore) GetAccount(code, currency string) (model.Account, error) {
+	defer s.lock()()
+	account, ok := s.state.findAccount(code, currency)
+	if !ok {
+		return account, ErrNotFound
+	}
+	return account, nil
+}
+
+func (s *MemoryStore) CreateJournalEntry(entry *model.JournalEntry) error {
+	defer s.lock()()
+	if !entry.Balanced() {
+		return ErrUnbalancedEntry
+	}
+	entry.ID = s.state.nextId()
+	entry.CreatedAt = time.Now()
+	entry.UpdatedAt = entry.CreatedAt
+	for i := range entry.Postings {
+		posting := &entry.Postings[i]
+		posting.ID = s.state.nextId()
+		posting.JournalEntryId = entry.ID
+		posting.CreatedAt = entry.CreatedAt
+		posting.UpdatedAt = entry.CreatedAt
+		remember(s, s.state.postings, posting.ID)
+		s.state.postings[posting.ID] = *posting
+	}
+	stored := *entry
+	stored.Postings = nil
+	remember(s, s.state.journalEntries, entry.ID)
+	s.state.journalEntries[entry.ID] = stored
+	return nil
+}
+
+func (s *MemoryStore) AccountBalance(account model.Account) (money.Money, error) {
+	defer s.lock()()
+	exponent, err := money.CurrencyExponent(account.Currency)
+	if err != nil {
+		return money.Money{}, err
+	}
+	balance := money.New(0, exponent)
+	for _, posting := range s.state.postings {
+		if posting.AccountId == account.ID {
+			balance = balance.Add(posting.Signed())
+		}
+	}
+	return balance, nil
+}
+
+func (s *MemoryStore) CreateTransaction(transaction *model.Transaction) error {
+	defer s.lock()()
+	if transaction.Currency == "" {
+		transaction.Currency = constant.DEFAULT_CURRENCY
+	}
+	if transaction.ReversalState == "" {
+		transaction.ReversalState = constant.NOT_REVERSED
+	}
+	transaction.ID = s.state.nextId()
+	transaction.CreatedAt = time.Now()
+	transaction.UpdatedAt = transaction.CreatedAt
+	transaction.RefundableAmount = transaction.Amount.Sub(transaction.RefundedAmount)
+	remember(s, s.state.transactions, transaction.ID)
+	s.state.transactions[transaction.ID] = *transaction
+	return nil
+}
+
+func (s *MemoryStore) GetTransaction(id uint) (model.Transaction, error) {
+	defer s.lock()()
+	transaction, ok := s.state.transactions[id]
+	if !ok {
+		return transaction, ErrNotFound
+	}
+	return transaction, transaction.AfterFind()
+}
+
+func (s *MemoryStore) LockTransaction(id uint) (model.Transaction, error) {
+	return s.GetTransaction(id)
+}
+
+func (s *MemoryStore) UpdateRefund(transaction *model.Transaction) error {
+	defer s.lock()()
+	stored, ok := s.state.transactions[transaction.ID]
+	if !ok {
+		return ErrNotFound
+	}
+	stored.RefundedAmount = transaction.RefundedAmount
+	stored.ReversalState = transaction.ReversalState
+	stored.UpdatedAt = time.Now()
+	remember(s, s.state.transactions, transaction.ID)
+	s.state.transactions[transaction.ID] = stored
+	return nil
+}
+
+func (s *MemoryStore) UpdateTransactionHash(transaction *model.Transaction) error {
+	defer s.lock()()
+	stored, ok := s.state.transactions[transaction.ID]
+	if !ok {
+		return ErrNotFound
+	}
+	stored.PrevHash = transaction.PrevHash
+	stored.Hash = transaction.Hash
+	stored.UpdatedAt = time.Now()
+	remember(s, s.state.transactions, transaction.ID)
+	s.state.transactions[transaction.ID] = stored
+	return nil
+}
+
+func (s *MemoryStore) ListTransactions(walletId uint, filter TransactionFilter) ([]model.Transaction, error) {
+	defer s.lock()()
+	var transactions []model.Transaction
+	for _, transaction := range s.state.transactions {
+		if transaction.WalletId == walletId && filter.matches(transaction) {
+			if err := transaction.AfterFind(); err != nil {
+				return nil, err
+			}
+			transactions = append(transactions, transaction)
+		}
+	}
+	sort.Slice(transactions, func(i, j int) bool {
+		newer := TransactionCursor{CreatedAt: transactions[i].CreatedAt, ID: transactions[i].ID}
+		return newer.isAfter(transactions[j])
+	})
+	if filter.Limit > 0 && len(transactions) > filter.Limit {
+		transactions = transactions[:filter.Limit]
+	}
+	return transactions, nil
+}
+
+// isAfter reports whether transaction comes after the cursor, i.e. is older.
+func (c TransactionCursor) isAfter(transaction model.Transaction) bool {
+	if transaction.CreatedAt.Equal(c.CreatedAt) {
+		return transaction.ID < c.ID
+	}
+	return transaction.CreatedAt.Before(c.CreatedAt)
+}
+
+// matches mirrors the WHERE clause built by GormStore.ListTransactions. Like
+// MySQL's default collation, the description match ignores case.
+func (f TransactionFilter) matches(transaction model.Transaction) bool {
+	switch {
+	case f.After != nil && !f.After.isAfter(transaction):
+		return false
+	case f.Type != "" && transaction.Type != f.Type:
+		return false
+	case f.MinAmount != nil && transaction.Amount.Units < f.MinAmount.Units:
+		return false
+	case f.MaxAmount != nil && transaction.Amount.Units > f.MaxAmount.Units:
+		return false
+	case f.From != nil && transaction.CreatedAt.Before(*f.From):
+		return false
+	case f.To != nil && !transaction.CreatedAt.Before(*f.To):
+		return false
+	case f.Description != "" && !strings.Contains(strings.ToLower(transaction.Description), strings.ToLower(f.Description)):
+		return false
+	}
+	return true
+}
+
+func (s *MemoryStore) CreateTransfer(transfer *model.Transfer) error {
+	defer s.lock()()
+	if transfer.Currency == "" {
+		transfer.Currency = constant.DEFAULT_CURRENCY
+	}
+	if err := transfer.BeforeSave(); err != nil {
+		return err
+	}
+	transfer.ID = s.state.nextId()
+	transfer.CreatedAt = time.Now()
+	transfer.UpdatedAt = transfer.CreatedAt
+	stored := *transfer
+	stored.Transactions = nil
+	remember(s, s.state.transfers, transfer.ID)
+	s.state.transfers[transfer.ID] = stored
+	return nil
+}
+
+func (s *MemoryStore) GetTransfer(id uint) (model.Transfer, error) {
+	defer s.lock()()
+	transfer, ok := s.state.transfers[id]
+	if !ok {
+		return transfer, ErrNotFound
+	}
+	return transfer, transfer.AfterFind()
+}
+
+func (s *MemoryStore) LockTransferLegs(transferId uint) ([]model.Transaction, error) {
+	defer s.lock()()
+	var legs []model.Transaction
+	for _, transaction := range s.state.transactions {
+		if transaction.TransferId != nil && *transaction.TransferId == transferId {
+			if err := transaction.AfterFind(); err != nil {
+				return nil, err
+			}
+			legs = append(legs, transaction)
+		}
+	}
+	sort.Slice(legs, func(i, j int) bool { return legs[i].ID < legs[j].ID })
+	return legs, nil
+}
+
+func (s *MemoryStore) CreateHold(hold *model.Hold) error {
+	defer s.lock()()
+	if hold.Currency == "" {
+		hold.Currency = constant.DEFAULT_CURRENCY
+	}
+	hold.ID = s.state.nextId()
+	hold.CreatedAt = time.Now()
+	hold.UpdatedAt = hold.CreatedAt
+	remember(s, s.state.holds, hold.ID)
+	s.state.holds[hold.ID] = *hold
+	return nil
+}
+
+func (s *MemoryStore) GetHold(id uint) (model.Hold, error) {
+	defer s.lock()()
+	hold, ok := s.state.holds[id]
+	if !ok {
+		return hold, ErrNotFound
+	}
+	return hold, hold.AfterFind()
+}
+
+func (s *MemoryStore) LockHold(id uint) (model.Hold, error) {
+	return s.GetHold(id)
+}
+
+func (s *MemoryStore) UpdateHold(hold *model.Hold) error {
+	defer s.lock()()
+	stored, ok := s.state.holds[hold.ID]
+	if !ok {
+		return ErrNotFound
+	}
+	stored.CapturedAmount = hold.CapturedAmount
+	stored.Status = hold.Status
+	stored.TransactionId = hold.TransactionId
+	stored.UpdatedAt = time.Now()
+	remember(s, s.state.holds, hold.ID)
+	s.state.holds[hold.ID] = stored
+	return nil
+}
+
+func (s *MemoryStore) ListExpiredHolds(now time.Time) ([]model.Hold, error) {
+	defer s.lock()()
+	var holds []model.Hold
+	for _, hold := range s.state.holds {
+		if hold.Status == constant.HOLD_ACTIVE && !hold.ExpiresAt.After(now) {
+			if err := hold.AfterFind(); err != nil {
+				return nil, err
+			}
+			holds = append(holds, hold)
+		}
+	}
+	sort.Slice(holds, func(i, j int) bool { return holds[i].ID < holds[j].ID })
+	return holds, nil
+}
+
+func (s *MemoryStore) GetIdempotencyKey(subject, key string) (model.IdempotencyKey, error) {
+	defer s.lock()()
+	record, ok := s.state.idempotencyKeys[idempotencyKeyId{subject, key}]
+	if !ok {
+		return record, ErrNotFound
+	}
+	return record, nil
+}
+
+func (s *MemoryStore) CreateIdempotencyKey(record *model.IdempotencyKey) error {
+	defer s.lock()()
+	id := idempotencyKeyId{record.Subject, record.Key}
+	if _, ok := s.state.idempotencyKeys[id]; ok {
+		return ErrDuplicateKey
+	}
+	remember(s, s.state.idempotencyKeys, id)
+	s.state.idempotencyKeys[id] = *record
+	return nil
+}
+
+func (s *MemoryStore) DeleteExpiredIdempotencyKey(subject, key string, now time.Time) error {
+	defer s.lock()()
+	id := idempotencyKeyId{subject, key}
+	if record, ok := s.state.idempotencyKeys[id]; ok && !record.ExpiresAt.After(now) {
+		remember(s, s.state.idempotencyKeys, id)
+		delete(s.state.idempotencyKeys, id)
+	}
+	return nil
+}
+
+func (s *MemoryStore) DeleteExpiredIdempotencyKeys(now time.Time) error {
+	defer s.lock()()
+	for key, record := range s.state.idempotencyKeys {
+		if !record.ExpiresAt.After(now) {
+			remember(s, s.state.idempotencyKeys, key)
+			delete(s.state.idempotencyKeys, key)
+		}
+	}
+	return nil
+}
+
+func (s *MemoryStore) GetLimitProfile(currency string) (model.LimitProfile, error) {
+	defer s.lock()()
+	for _, profile := range s.state.limitProfiles {
+		if profile.Currency == currency {
+			return profile, profile.AfterFind()
+		}
+	}
+	return model.LimitProfile{}, ErrNotFound
+}
+
+func (s *MemoryStore) SaveLimitProfile(profile *model.LimitProfile) error {
+	defer s.lock()()
+	for id, existing := range s.state.limitProfiles {
+		if existing.Currency == profile.Currency && id != profile.ID {
+			return ErrDuplicateKey
+		}
+	}
+	if profile.ID == 0 {
+		profile.ID = s.state.nextId()
+		profile.CreatedAt = time.Now()
+	}
+	profile.UpdatedAt = time.Now()
+	remember(s, s.state.limitProfiles, profile.ID)
+	s.state.limitProfiles[profile.ID] = *profile
+	return nil
+}
+
+func (s *MemoryStore) GetWalletLimit(walletId uint) (model.WalletLimit, error) {
+	defer s.lock()()
+	for _, limit := range s.state.walletLimits {
+		if limit.WalletId == walletId {
+			return limit, limit.AfterFind()
+		}
+	}
+	return model.WalletLimit{}, ErrNotFound
+}
+
+func (s *MemoryStore) SaveWalletLimit(limit *model.WalletLimit) error {
+	defer s.lock()()
+	for id, existing := range s.state.walletLimits {
+		if existing.WalletId == limit.WalletId && id != limit.ID {
+			return ErrDuplicateKey
+		}
+	}
+	if limit.ID == 0 {
+		limit.ID = s.state.nextId()
+		limit.CreatedAt = time.Now()
+	}
+	limit.UpdatedAt = time.Now()
+	remember(s, s.state.walletLimits, limit.ID)
+	s.state.walletLimits[limit.ID] = *limit
+	return nil
+}
+
+func (s *MemoryStore) SumTransactions(wallet model.Wallet, transactionType string, since time.Time) (money.Money, error) {
+	defer s.lock()()
+	exponent, err := money.CurrencyExponent(wallet.Currency)
+	if err != nil {
+		return money.Money{}, err
+	}
+	total := money.New(0, exponent)
+	for _, transaction := range s.state.transactions {
+		if transaction.WalletId == wallet.ID && transaction.Type == transactionType &&
+			!transaction.CreatedAt.Before(since) && transaction.ReversedTransactionId == nil {
+			total = total.Add(transaction.Amount)
+		}
+	}
+	return total, nil
+}
+
+func (s *MemoryStore) GetFeeSchedule(transactionType, currency string) (model.FeeSchedule, error) {
+	defer s.lock()()
+	for _, schedule := range s.state.feeSchedules {
+		if schedule.TransactionType == transactionType && schedule.Currency == currency {
+			return schedule, schedule.AfterFind()
+		}
+	}
+	return model.FeeSchedule{}, ErrNotFound
+}
+
+func (s *MemoryStore) SaveFeeSchedule(schedule *model.FeeSchedule) error {
+	defer s.lock()()
+	for id, existing := range s.state.feeSchedules {
+		if existing.TransactionType == schedule.TransactionType && existing.Currency == schedule.Currency && id != schedule.ID {
+			return ErrDuplicateKey
+		}
+	}
+	if err := schedule.BeforeSave(); err != nil {
+		return err
+	}
+	if schedule.ID == 0 {
+		schedule.ID = s.state.nextId()
+		schedule.CreatedAt = time.Now()
+	}
+	schedule.UpdatedAt = time.Now()
+	remember(s, s.state.feeSchedules, schedule.ID)
+	s.state.feeSchedules[schedule.ID] = *schedule
+	return nil
+}
+
+func (s *MemoryStore) LockFeeTransactions(transactionId uint) ([]model.Transaction, error) {
+	defer s.lock()()
+	var fees []model.Transaction
+	for _, transaction := range s.state.transactions {
+		if transaction.FeeOfTransactionId != nil && *transaction.FeeOfTransactionId == transactionId {
+			if err := transaction.AfterFind(); err != nil {
+				return nil, err
+			}
+			fees = append(fees, transaction)
+		}
+	}
+	sort.Slice(fees, func(i, j int) bool { return fees[i].ID < fees[j].ID })
+	return fees, nil
+}
+
+func (s *MemoryStore) CreateSchedule(schedule *model.Schedule) error {
+	defer s.lock()()
+	schedule.ID = s.state.nextId()
+	schedule.CreatedAt = time.Now()
+	schedule.UpdatedAt = schedule.CreatedAt
+	remember(s, s.state.schedules, schedule.ID)
+	s.state.schedules[schedule.ID] = *schedule
+	return nil
+}
+
+func (s *MemoryStore) GetSchedule(id uint) (model.Schedule, error) {
+	defer s.lock()()
+	schedule, ok := s.state.schedules[id]
+	if !ok {
+		return schedule, ErrNotFound
+	}
+	return schedule, schedule.AfterFind()
+}
+
+func (s *MemoryStore) LockSchedule(id uint) (model.Schedule, error) {
+	return s.GetSchedule(id)
+}
+
+func (s *MemoryStore) UpdateSchedule(schedule *model.Schedule) error {
+	defer s.lock()()
+	stored, ok := s.state.schedules[schedule.ID]
+	if !ok {
+		return ErrNotFound
+	}
+	stored.Runs = schedule.Runs
+	stored.NextRunAt = schedule.NextRunAt
+	stored.Attempts = schedule.Attempts
+	stored.LastError = schedule.LastError
+	stored.Status = schedule.Status
+	stored.UpdatedAt = time.Now()
+	remember(s, s.state.schedules, schedule.ID)
+	s.state.schedules[schedule.ID] = stored
+	return nil
+}
+
+func (s *MemoryStore) ListDueSchedules(now time.Time) ([]model.Schedule, error) {
+	defer s.lock()()
+	var schedules []model.Schedule
+	for _, schedule := range s.state.schedules {
+		if schedule.Status == constant.SCHEDULE_ACTIVE && !schedule.NextRunAt.After(now) {
+			if err := schedule.AfterFind(); err != nil {
+				return nil, err
+			}
+			schedules = append(schedules, schedule)
+		}
+	}
+	sort.Slice(schedules, func(i, j int) bool {
+		if schedules[i].NextRunAt.Equal(schedules[j].NextRunAt) {
+			return schedules[i].ID < schedules[j].ID
+		}
+		return schedules[i].NextRunAt.Before(schedules[j].NextRunAt)
+	})
+	return schedules, nil
+}
+
+func (s *MemoryStore) CreateScheduleRun(run *model.ScheduleRun) error {
+	defer s.lock()()
+	for _, existing := range s.state.scheduleRuns {
+		if existing.ScheduleId == run.ScheduleId && existing.Occurrence == run.Occurrence {
+			return ErrDuplicateKey
+		}
+	}
+	run.ID = s.state.nextId()
+	run.CreatedAt = time.Now()
+	run.UpdatedAt = run.CreatedAt
+	remember(s, s.state.scheduleRuns, run.ID)
+	s.state.scheduleRuns[run.ID] = *run
+	return nil
+}
+
+func (s *MemoryStore) ListScheduleRuns(scheduleId uint) ([]model.ScheduleRun, error) {
+	defer s.lock()()
+	var runs []model.ScheduleRun
+	for _, run := range s.state.scheduleRuns {
+		if run.ScheduleId == scheduleId {
+			runs = append(runs, run)
+		}
+	}
+	sort.Slice(runs, func(i, j int) bool { return runs[i].Occurrence < runs[j].Occurrence })
+	return runs, nil
+}
+
+func (s *MemoryStore) CreateEvent(event *model.Event) error {
+	defer s.lock()()
+	if err := event.BeforeSave(); err != nil {
+		return err
+	}
+	event.ID = s.state.nextId()
+	event.CreatedAt = time.Now()
+	event.UpdatedAt = event.CreatedAt
+	remember(s, s.state.events, event.ID)
+	s.state.events[event.ID] = *event
+	return nil
+}
+
+func (s *MemoryStore) GetEvent(id uint) (model.Event, error) {
+	defer s.lock()()
+	event, ok := s.state.events[id]
+	if !ok {
+		return event, ErrNotFound
+	}
+	return event, event.AfterFind()
+}
+
+func (s *MemoryStore) ListUndispatchedEvents(limit int) ([]model.Event, error) {
+	defer s.lock()()
+	var events []model.Event
+	for _, event := range s.state.events {
+		if !event.Dispatched {
+			if err := event.AfterFind(); err != nil {
+				return nil, err
+			}
+			events = append(events, event)
+		}
+	}
+	sort.Slice(events, func(i, j int) bool { return events[i].ID < events[j].ID })
+	if len(events) > limit {
+		events = events[:limit]
+	}
+	return events, nil
+}
+
+func (s *MemoryStore) MarkEventDispatched(id uint) error {
+	defer s.lock()()
+	event, ok := s.state.events[id]
+	if !ok {
+		return ErrNotFound
+	}
+	event.Dispatched = true
+	event.UpdatedAt = time.Now()
+	remember(s, s.state.events, id)
+	s.state.events[id] = event
+	return nil
+}
+
+func (s *MemoryStore) CreateWebhookSubscription(subscription *model.WebhookSubscription) error {
+	defer s.lock()()
+	if err := subscription.BeforeSave(); err != nil {
+		return err
+	}
+	subscription.ID = s.state.nextId()
+	subscription.CreatedAt = time.Now()
+	subscription.UpdatedAt = subscription.CreatedAt
+	remember(s, s.state.subscriptions, subscription.ID)
+	s.state.subscriptions[subscription.ID] = *subscription
+	return nil
+}
+
+func (s *MemoryStore) GetWebhookSubscription(id uint) (model.WebhookSubscription, error) {
+	defer s.lock()()
+	subscription, ok := s.state.subscriptions[id]
+	if !ok {
+		return subscription, ErrNotFound
+	}
+	return subscription, subscription.AfterFind()
+}
+
+func (s *MemoryStore) ListWebhookSubscriptions() ([]model.WebhookSubscription, error) {
+	defer s.lock()()
+	var subscriptions []model.WebhookSubscription
+	for _, subscription := range s.state.subscriptions {
+		if err := subscription.AfterFind(); err != nil {
+			return nil, err
+		}
+		subscriptions = append(subscriptions, subscription)
+	}
+	sort.Slice(subscriptions, func(i, j int) bool { return subscriptions[i].ID < subscriptions[j].ID })
+	return subscriptions, nil
+}
+
+func (s *MemoryStore) UpdateWebhookSubscription(subscription *model.WebhookSubscription) error {
+	defer s.lock()()
+	stored, ok := s.state.subscriptions[subscription.ID]
+	if !ok {
+		return ErrNotFound
+	}
+	stored.Active = subscription.Active
+	stored.UpdatedAt = time.Now()
+	remember(s, s.state.subscriptions, subscription.ID)
+	s.state.subscriptions[subscription.ID] = stored
+	return nil
+}
+
+func (s *MemoryStore) CreateWebhookDelivery(delivery *model.WebhookDelivery) error {
+	defer s.lock()()
+	for _, existing := range s.state.deliveries {
+		if existing.EventId == delivery.EventId && existing.SubscriptionId == delivery.SubscriptionId {
+			return ErrDuplicateKey
+		}
+	}
+	delivery.ID = s.state.nextId()
+	delivery.CreatedAt = time.Now()
+	delivery.UpdatedAt = delivery.CreatedAt
+	remember(s, s.state.deliveries, delivery.ID)
+	s.state.deliveries[delivery.ID] = *delivery
+	return nil
+}
+
+func (s *MemoryStore) GetWebhookDelivery(eventId, subscriptionId uint) (model.WebhookDelivery, error) {
+	defer s.lock()()
+	for _, delivery := range s.state.deliveries {
+		if delivery.EventId == eventId && delivery.SubscriptionId == subscriptionId {
+			return delivery, nil
+		}
+	}
+	return model.WebhookDelivery{}, ErrNotFound
+}
+
+func (s *MemoryStore) UpdateWebhookDelivery(delivery *model.WebhookDelivery) error {
+	defer s.lock()()
+	stored, ok := s.state.deliveries[delivery.ID]
+	if !ok {
+		return ErrNotFound
+	}
+	stored.Status = delivery.Status
+	stored.Attempts = delivery.Attempts
+	stored.NextAttemptAt = delivery.NextAttemptAt
+	stored.ResponseCode = delivery.ResponseCode
+	stored.LastError = delivery.LastError
+	stored.UpdatedAt = time.Now()
+	remember(s, s.state.deliveries, delivery.ID)
+	s.state.deliveries[delivery.ID] = stored
+	return nil
+}
+
+func (s *MemoryStore) ListDueWebhookDeliveries(now time.Time, limit int) ([]model.WebhookDelivery, error) {
+	defer s.lock()()
+	var deliveries []model.WebhookDelivery
+	for _, delivery := range s.state.deliveries {
+		if delivery.Status == constant.DELIVERY_PENDING && !delivery.NextAttemptAt.After(now) {
+			deliveries = append(deliveries, delivery)
+		}
+	}
+	sort.Slice(deliveries, func(i, j int) bool {
+		if deliveries[i].NextAttemptAt.Equal(deliveries[j].NextAttemptAt) {
+			return deliveries[i].ID < deliveries[j].ID
+		}
+		return deliveries[i].NextAttemptAt.Before(deliveries[j].NextAttemptAt)
+	})
+	if len(deliveries) > limit {
+		deliveries = deliveries[:limit]
+	}
+	return deliveries, nil
+}
+
+func (s *MemoryStore) ListWebhookDeliveries(status string) ([]model.WebhookDelivery, error) {
+	defer s.lock()()
+	var deliveries []model.WebhookDelivery
+	for _, delivery := range s.state.deliveries {
+		if delivery.Status == status {
+			deliveries = append(deliveries, delivery)
+		}
+	}
+	sort.Slice(deliveries, func(i, j int) bool { return deliveries[i].ID < deliveries[j].ID })
+	return deliveries, nil
+}
+
+func (s *MemoryStore) CreateAuditRecord(record *model.AuditRecord) error {
+	defer s.lock()()
+	if err := record.BeforeSave(); err != nil {
+		return err
+	}
+	record.ID = s.state.nextId()
+	record.CreatedAt = time.Now()
+	remember(s, s.state.auditRecords, record.ID)
+	s.state.auditRecords[record.ID] = *record
+	return nil
+}
+
+func (s *MemoryStore) ListAuditRecords(filter AuditFilter) ([]model.AuditRecord, error) {
+	defer s.lock()()
+	var records []model.AuditRecord
+	for _, record := range s.state.auditRecords {
+		if filter.matches(record) {
+			if err := record.AfterFind(); err != nil {
+				return nil, err
+			}
+			records = append(records, record)
+		}
+	}
+	sort.Slice(records, func(i, j int) bool { return records[i].ID > records[j].ID })
+	if filter.Limit > 0 && len(records) > filter.Limit {
+		records = records[:filter.Limit]
+	}
+	return records, nil
+}
+
+// matches mirrors the WHERE clause built by GormStore.ListAuditRecords.
+func (f AuditFilter) matches(record model.AuditRecord) bool {
+	switch {
+	case f.BeforeId != 0 && record.ID >= f.BeforeId:
+		return false
+	case f.Actor != "" && record.Actor != f.Actor:
+		return false
+	case f.Action != "" && record.Action != f.Action:
+		return false
+	case f.WalletId != nil && (record.WalletId == nil || *record.WalletId != *f.WalletId):
+		return false
+	case f.From != nil && record.CreatedAt.Before(*f.From):
+		return false
+	case f.To != nil && !record.CreatedAt.Before(*f.To):
+		return false
+	}
+	return true
+}
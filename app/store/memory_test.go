@@ -0,0 +1,116 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"wallet/app/constant"
+	"wallet/app/model"
+	"wallet/app/money"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMemoryStoreAtomicDiscardsChangesOnError(t *testing.T) {
+	s := NewMemoryStore()
+	wallet := model.Wallet{Balance: money.New(1000, 2)}
+	assert.NoError(t, s.CreateWallet(&wallet))
+	failure := errors.New("failed")
+
+	err := s.Atomic(func(tx Store) error {
+		locked, err := tx.LockWallet(wallet.ID)
+		assert.NoError(t, err)
+		locked.Balance = money.New(0, 2)
+		assert.NoError(t, tx.UpdateWalletBalances(&locked))
+		assert.NoError(t, tx.CreateTransaction(&model.Transaction{WalletId: wallet.ID, Amount: money.New(1000, 2)}))
+		return failure
+	})
+
+	assert.Equal(t, failure, err)
+	stored, err := s.GetWallet(wallet.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, money.New(1000, 2), stored.Balance)
+	transactions, err := s.ListTransactions(wallet.ID, TransactionFilter{})
+	assert.NoError(t, err)
+	assert.Empty(t, transactions)
+}
+
+func TestMemoryStoreAtomicDiscardsChangesOnPanic(t *testing.T) {
+	s := NewMemoryStore()
+	wallet := model.Wallet{Balance: money.New(1000, 2)}
+	assert.NoError(t, s.CreateWallet(&wallet))
+
+	assert.Panics(t, func() {
+		s.Atomic(func(tx Store) error {
+			wallet.Balance = money.New(0, 2)
+			assert.NoError(t, tx.UpdateWalletBalances(&wallet))
+			panic("failed")
+		})
+	})
+
+	assert.Equal(t, money.New(1000, 2), getBalance(t, s, wallet.ID))
+	created := model.Wallet{}
+	assert.NoError(t, s.CreateWallet(&created))
+	assert.Equal(t, wallet.ID+1, created.ID)
+}
+
+func TestMemoryStoreAtomicCommitsOnSuccess(t *testing.T) {
+	s := NewMemoryStore()
+	wallet := model.Wallet{Balance: money.New(1000, 2)}
+	assert.NoError(t, s.CreateWallet(&wallet))
+
+	err := s.Atomic(func(tx Store) error {
+		return tx.Atomic(func(tx Store) error {
+			wallet.Balance = money.New(2500, 2)
+			return tx.UpdateWalletBalances(&wallet)
+		})
+	})
+
+	assert.NoError(t, err)
+	stored, err := s.GetWallet(wallet.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, money.New(2500, 2), stored.Balance)
+}
+
+func TestMemoryStoreAtomicFailsOnceContextIsDone(t *testing.T) {
+	s := NewMemoryStore()
+	wallet := model.Wallet{Balance: money.New(1000, 2)}
+	assert.NoError(t, s.CreateWallet(&wallet))
+	ctx, cancel := context.WithCancel(context.Background())
+
+	err := s.WithContext(ctx).Atomic(func(tx Store) error {
+		wallet.Balance = money.New(2500, 2)
+		cancel()
+		return tx.UpdateWalletBalances(&wallet)
+	})
+
+	assert.Equal(t, context.Canceled, err)
+	assert.Equal(t, money.New(1000, 2), getBalance(t, s, wallet.ID))
+
+	assert.NoError(t, s.WithContext(context.Background()).Atomic(func(tx Store) error {
+		return tx.UpdateWalletBalances(&wallet)
+	}))
+	assert.Equal(t, money.New(2500, 2), getBalance(t, s, wallet.ID))
+}
+
+func getBalance(t *testing.T, s Store, walletId uint) money.Money {
+	stored, err := s.GetWallet(walletId)
+	assert.NoError(t, err)
+	return stored.Balance
+}
+
+func TestMemoryStoreRejectsUnbalancedJournalEntry(t *testing.T) {
+	s := NewMemoryStore()
+	account := model.Account{Code: "WALLET:1", Currency: "USD"}
+	assert.NoError(t, s.EnsureAccount(&account))
+	entry := model.JournalEntry{Postings: []model.Posting{
+		{AccountId: account.ID, Direction: constant.CREDIT, Amount: money.New(1000, 2), Currency: "USD"},
+	}}
+
+	err := s.CreateJournalEntry(&entry)
+
+	assert.Equal(t, ErrUnbalancedEntry, err)
+	balance, err := s.AccountBalance(account)
+	assert.NoError(t, err)
+	assert.True(t, balance.IsZero())
+}
@@ -0,0 +1,220 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"time"
+	"wallet/app/model"
+	"wallet/app/money"
+)
+
+var (
+	// ErrNotFound is returned when a requested record does not exist.
+	ErrNotFound = errors.New("record not found")
+	// ErrDuplicateKey is returned when a record with the same key exists.
+	ErrDuplicateKey = errors.New("duplicate key")
+	// ErrUnbalancedEntry is returned for a journal entry whose debits and
+	// credits differ.
+	ErrUnbalancedEntry = errors.New("journal entry debits and credits do not balance")
+)
+
+// WalletStore persists wallets and their owners.
+type WalletStore interface {
+	CreateOwner(owner *model.Owner) error
+	GetOwner(id uint) (model.Owner, error)
+
+	CreateWallet(wallet *model.Wallet) error
+	GetWallet(id uint) (model.Wallet, error)
+	// ListWallets returns every wallet in ID order.
+	ListWallets() ([]model.Wallet, error)
+	// LockWallet loads a wallet and, inside Atomic, keeps it locked against
+	// concurrent updates until the transaction ends.
+	LockWallet(id uint) (model.Wallet, error)
+	// UpdateWalletBalances writes Balance and HeldBalance.
+	UpdateWalletBalances(wallet *model.Wallet) error
+	// UpdateWalletStatus writes Status and StatusReason.
+	UpdateWalletStatus(wallet *model.Wallet) error
+	// UpdateWalletChainHash writes ChainHash.
+	UpdateWalletChainHash(wallet *model.Wallet) error
+}
+
+// LedgerStore persists everything posted against wallets: the double-entry
+// journal, transactions, transfers, holds and the idempotency keys of the
+// requests that made them.
+type LedgerStore interface {
+	// EnsureAccount creates account unless one with the same Code and
+	// Currency exists, and loads the stored account into it either way.
+	EnsureAccount(account *model.Account) error
+	GetAccount(code, currency string) (model.Account, error)
+	// CreateJournalEntry saves entry with its postings. Unbalanced entries are
+	// rejected with ErrUnbalancedEntry.
+	CreateJournalEntry(entry *model.JournalEntry) error
+	// AccountBalance is the sum of the account's credits minus its debits.
+	AccountBalance(account model.Account) (money.Money, error)
+
+	CreateTransaction(transaction *model.Transaction) error
+	GetTransaction(id uint) (model.Transaction, error)
+	LockTransaction(id uint) (model.Transaction, error)
+	// UpdateRefund writes RefundedAmount and ReversalState.
+	UpdateRefund(transaction *model.Transaction) error
+	// UpdateTransactionHash writes PrevHash and Hash.
+	UpdateTransactionHash(transaction *model.Transaction) error
+	// ListTransactions returns a wallet's transactions newest first.
+	ListTransactions(walletId uint, filter TransactionFilter) ([]model.Transaction, error)
+
+	CreateTransfer(transfer *model.Transfer) error
+	GetTransfer(id uint) (model.Transfer, error)
+	// LockTransferLegs loads and locks the transactions of a transfer in ID
+	// order.
+	LockTransferLegs(transferId uint) ([]model.Transaction, error)
+
+	CreateHold(hold *model.Hold) error
+	GetHold(id uint) (model.Hold, error)
+	LockHold(id uint) (model.Hold, error)
+	// UpdateHold writes Status, CapturedAmount and TransactionId.
+	UpdateHold(hold *model.Hold) error
+	// ListExpiredHolds returns active holds whose expiry is not after now.
+	ListExpiredHolds(now time.Time) ([]model.Hold, error)
+
+	// GetIdempotencyKey returns the key the caller with the given subject
+	// sent.
+	GetIdempotencyKey(subject, key string) (model.IdempotencyKey, error)
+	CreateIdempotencyKey(record *model.IdempotencyKey) error
+	// DeleteExpiredIdempotencyKey deletes the caller's key if it expired by
+	// now.
+	DeleteExpiredIdempotencyKey(subject, key string, now time.Time) error
+	DeleteExpiredIdempotencyKeys(now time.Time) error
+}
+
+// LimitStore persists transaction limits and sums the transactions they cap.
+type LimitStore interface {
+	GetLimitProfile(currency string) (model.LimitProfile, error)
+	// SaveLimitProfile creates profile, or updates every field when it has an
+	// ID.
+	SaveLimitProfile(profile *model.LimitProfile) error
+	GetWalletLimit(walletId uint) (model.WalletLimit, error)
+	// SaveWalletLimit creates limit, or updates every field when it has an ID.
+	SaveWalletLimit(limit *model.WalletLimit) error
+	// SumTransactions is the total of the wallet's transactions of
+	// transactionType made since, not counting reversals.
+	SumTransactions(wallet model.Wallet, transactionType string, since time.Time) (money.Money, error)
+}
+
+// FeeStore persists fee schedules and finds the fees charged on a
+// transaction.
+type FeeStore interface {
+	GetFeeSchedule(transactionType, currency string) (model.FeeSchedule, error)
+	// SaveFeeSchedule creates schedule, or updates every field when it has an
+	// ID.
+	SaveFeeSchedule(schedule *model.FeeSchedule) error
+	// LockFeeTransactions loads and locks the legs of the fees charged on
+	// transactionId in ID order.
+	LockFeeTransactions(transactionId uint) ([]model.Transaction, error)
+}
+
+// ScheduleStore persists schedules and the record of their runs.
+type ScheduleStore interface {
+	CreateSchedule(schedule *model.Schedule) error
+	GetSchedule(id uint) (model.Schedule, error)
+	LockSchedule(id uint) (model.Schedule, error)
+	// UpdateSchedule writes Runs, NextRunAt, Attempts, LastError and Status.
+	UpdateSchedule(schedule *model.Schedule) error
+	// ListDueSchedules returns active schedules whose next run is not after
+	// now, the longest overdue first.
+	ListDueSchedules(now time.Time) ([]model.Schedule, error)
+	// CreateScheduleRun returns ErrDuplicateKey when the occurrence already
+	// has a run.
+	CreateScheduleRun(run *model.ScheduleRun) error
+	ListScheduleRuns(scheduleId uint) ([]model.ScheduleRun, error)
+}
+
+// EventStore keeps the outbox of domain events and their webhook
+// deliveries.
+type EventStore interface {
+	CreateEvent(event *model.Event) error
+	GetEvent(id uint) (model.Event, error)
+	// ListUndispatchedEvents returns up to limit events not yet dispatched,
+	// oldest first.
+	ListUndispatchedEvents(limit int) ([]model.Event, error)
+	MarkEventDispatched(id uint) error
+
+	CreateWebhookSubscription(subscription *model.WebhookSubscription) error
+	GetWebhookSubscription(id uint) (model.WebhookSubscription, error)
+	ListWebhookSubscriptions() ([]model.WebhookSubscription, error)
+	// UpdateWebhookSubscription writes Active.
+	UpdateWebhookSubscription(subscription *model.WebhookSubscription) error
+
+	// CreateWebhookDelivery returns ErrDuplicateKey when the event already
+	// has a delivery to the subscription.
+	CreateWebhookDelivery(delivery *model.WebhookDelivery) error
+	GetWebhookDelivery(eventId, subscriptionId uint) (model.WebhookDelivery, error)
+	// UpdateWebhookDelivery writes Status, Attempts, NextAttemptAt,
+	// ResponseCode and LastError.
+	UpdateWebhookDelivery(delivery *model.WebhookDelivery) error
+	// ListDueWebhookDeliveries returns up to limit pending deliveries whose
+	// next attempt is not after now, the longest waiting first.
+	ListDueWebhookDeliveries(now time.Time, limit int) ([]model.WebhookDelivery, error)
+	ListWebhookDeliveries(status string) ([]model.WebhookDelivery, error)
+}
+
+// AuditStore keeps the audit log. It is append-only only in that records
+// cannot be updated or deleted through it: neither the schema nor GormStore
+// stops other code holding the *gorm.DB, such as App.DB, from changing them.
+// Enforce it in the database by granting the application's DB user no UPDATE
+// or DELETE on audit_records.
+type AuditStore interface {
+	CreateAuditRecord(record *model.AuditRecord) error
+	// ListAuditRecords returns audit records newest first.
+	ListAuditRecords(filter AuditFilter) ([]model.AuditRecord, error)
+}
+
+// Store is everything the handlers persist.
+type Store interface {
+	WalletStore
+	LedgerStore
+	LimitStore
+	FeeStore
+	ScheduleStore
+	EventStore
+	AuditStore
+	// Atomic runs fn in a single DB transaction. Changes made through the
+	// Store passed to fn are committed if fn returns nil and rolled back
+	// otherwise. Calling Atomic on that Store again runs in the same
+	// transaction.
+	Atomic(fn func(tx Store) error) error
+	// WithContext is the Store whose DB transactions are bound to ctx: once
+	// ctx is done, Atomic rolls back and fails.
+	WithContext(ctx context.Context) Store
+}
+
+// TransactionCursor is the (CreatedAt, ID) position of the last transaction
+// on a page.
+type TransactionCursor struct {
+	CreatedAt time.Time
+	ID        uint
+}
+
+// TransactionFilter narrows ListTransactions. Zero values do not filter.
+// MinAmount and MaxAmount must be in the minor unit of the wallet currency.
+type TransactionFilter struct {
+	Limit       int
+	After       *TransactionCursor
+	Type        string
+	MinAmount   *money.Money
+	MaxAmount   *money.Money
+	From        *time.Time
+	To          *time.Time
+	Description string
+}
+
+// AuditFilter narrows ListAuditRecords. Zero values do not filter. BeforeId
+// pages through the log by only returning records older than it.
+type AuditFilter struct {
+	Limit    int
+	BeforeId uint
+	Actor    string
+	Action   string
+	WalletId *uint
+	From     *time.Time
+	To       *time.Time
+}
@@ -3,10 +3,19 @@ package config
 import (
 	"os"
 	"strconv"
+	"strings"
+	"time"
 )
 
 type Config struct {
-	DB *DBConfig
+	DB          *DBConfig
+	Idempotency *IdempotencyConfig
+	Hold        *HoldConfig
+	Auth        *AuthConfig
+	Schedule    *ScheduleConfig
+	Webhook     *WebhookConfig
+	Server      *ServerConfig
+	Log         *LogConfig
 }
 
 type DBConfig struct {
@@ -19,6 +28,48 @@ type DBConfig struct {
 	Charset  string
 }
 
+type IdempotencyConfig struct {
+	Retention time.Duration
+}
+
+type HoldConfig struct {
+	TTL time.Duration
+}
+
+// ScheduleConfig sets how often a failing occurrence of a schedule is
+// retried before it is recorded as failed.
+type ScheduleConfig struct {
+	MaxAttempts int
+	RetryDelay  time.Duration
+}
+
+// WebhookConfig sets how often a failing webhook delivery is retried before
+// it is dead-lettered.
+type WebhookConfig struct {
+	MaxAttempts int
+	RetryDelay  time.Duration
+}
+
+// ServerConfig bounds the requests the API serves. RequestTimeout applies to
+// routes that do not set their own.
+type ServerConfig struct {
+	RequestTimeout time.Duration
+	MaxBodyBytes   int64
+}
+
+// LogConfig sets the least severe level logged, the format, json or text,
+// and whether every SQL statement is logged.
+type LogConfig struct {
+	Level  string
+	Format string
+	SQL    bool
+}
+
+// AuthConfig holds the HMAC keys that sign bearer tokens, by key ID.
+type AuthConfig struct {
+	Keys map[string]string
+}
+
 func GetConfig() *Config {
 	port, _ := strconv.ParseInt(os.Getenv("DB_PORT"), 0, 64)
 	return &Config{
@@ -31,5 +82,71 @@ func GetConfig() *Config {
 			Name:     os.Getenv("DB_NAME"),
 			Charset:  "utf8",
 		},
+		Idempotency: &IdempotencyConfig{
+			Retention: getDuration("IDEMPOTENCY_RETENTION", 24*time.Hour),
+		},
+		Hold: &HoldConfig{
+			TTL: getDuration("HOLD_TTL", 7*24*time.Hour),
+		},
+		Auth: &AuthConfig{
+			Keys: getKeys("JWT_KEYS"),
+		},
+		Schedule: &ScheduleConfig{
+			MaxAttempts: getInt("SCHEDULE_MAX_ATTEMPTS", 3),
+			RetryDelay:  getDuration("SCHEDULE_RETRY_DELAY", time.Minute),
+		},
+		Webhook: &WebhookConfig{
+			MaxAttempts: getInt("WEBHOOK_MAX_ATTEMPTS", 8),
+			RetryDelay:  getDuration("WEBHOOK_RETRY_DELAY", 30*time.Second),
+		},
+		Server: &ServerConfig{
+			RequestTimeout: getDuration("REQUEST_TIMEOUT", 10*time.Second),
+			MaxBodyBytes:   int64(getInt("MAX_BODY_BYTES", 1<<20)),
+		},
+		Log: &LogConfig{
+			Level:  getString("LOG_LEVEL", "info"),
+			Format: getString("LOG_FORMAT", "json"),
+			SQL:    os.Getenv("LOG_SQL") == "true",
+		},
+	}
+}
+
+func getString(key string, fallback string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	return fallback
+}
+
+func getDuration(key string, fallback time.Duration) time.Duration {
+	value, err := time.ParseDuration(os.Getenv(key))
+	if err != nil || value <= 0 {
+		return fallback
+	}
+	return value
+}
+
+func getInt(key string, fallback int) int {
+	value, err := strconv.Atoi(os.Getenv(key))
+	if err != nil || value <= 0 {
+		return fallback
+	}
+	return value
+}
+
+// getKeys parses a comma separated list of kid:key pairs. An entry without a
+// kid is the key for tokens that carry none.
+func getKeys(key string) map[string]string {
+	keys := make(map[string]string)
+	for _, entry := range strings.Split(os.Getenv(key), ",") {
+		if entry = strings.TrimSpace(entry); entry == "" {
+			continue
+		}
+		kid, secret := "", entry
+		if i := strings.Index(entry, ":"); i >= 0 {
+			kid, secret = entry[:i], entry[i+1:]
+		}
+		keys[kid] = secret
 	}
+	return keys
 }
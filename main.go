@@ -1,12 +1,52 @@
 package main
 
 import (
+	"flag"
+	"log/slog"
+	"os"
 	"wallet/app"
+	"wallet/app/logging"
 	"wallet/config"
 )
 
 func main() {
 	config := config.GetConfig()
-	app := &app.App{}
+	logger := logging.New(os.Stderr, config.Log.Level, config.Log.Format)
+	slog.SetDefault(logger)
+	app := &app.App{Logger: logger}
+	if len(os.Args) > 1 && os.Args[1] == "reconcile" {
+		flags := flag.NewFlagSet("reconcile", flag.ExitOnError)
+		repair := flags.Bool("repair", false, "write adjustment transactions for balance mismatches")
+		flags.Parse(os.Args[2:])
+		app.Initialize(config)
+		if !app.Reconcile(*repair) {
+			os.Exit(1)
+		}
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "rebuild-balances" {
+		app.Initialize(config)
+		app.RebuildBalances()
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "verify-chain" {
+		flags := flag.NewFlagSet("verify-chain", flag.ExitOnError)
+		walletId := flags.Uint("wallet", 0, "verify only this wallet's chain")
+		flags.Parse(os.Args[2:])
+		app.Initialize(config)
+		var walletIds []uint
+		if *walletId != 0 {
+			walletIds = append(walletIds, *walletId)
+		}
+		if !app.VerifyChains(walletIds) {
+			os.Exit(1)
+		}
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "chain-heads" {
+		app.Initialize(config)
+		app.PrintChainHeads()
+		return
+	}
 	app.InitializeAndRun(config, ":2004")
 }
@@ -2,6 +2,7 @@ package testutils
 
 import (
 	"database/sql"
+	"fmt"
 	"github.com/DATA-DOG/go-sqlmock"
 	"github.com/gorilla/mux"
 	"github.com/jinzhu/gorm"
@@ -9,6 +10,10 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"testing"
+	"wallet/app/auth"
+	"wallet/app/model"
+	"wallet/app/store"
+	"wallet/config"
 )
 
 type Mock struct {
@@ -19,6 +24,7 @@ type Mock struct {
 type TestServer struct {
 	request *mux.Router
 	Server  *httptest.Server
+	claims  auth.Claims
 }
 
 func NewMockDb(t *testing.T) *Mock {
@@ -38,15 +44,51 @@ func (m *Mock) ExpectQueryWithResult(query string, rows *sqlmock.Rows) *Mock {
 	return m
 }
 
+// NewTestServer serves handlers to a caller authenticated with the admin
+// scope; AsOwner changes the caller.
 func NewTestServer() *TestServer {
 	r := mux.NewRouter()
 	ts := httptest.NewServer(r)
-	return &TestServer{r, ts}
+	return &TestServer{r, ts, auth.Claims{Subject: "admin", Scope: auth.AdminScope}}
 }
 
-func (t *TestServer) RegisterHandler(path string, db *gorm.DB, handlerFunc func(db *gorm.DB, w http.ResponseWriter, r *http.Request)) *TestServer {
+// AsOwner makes the requests come from the owner with the given ID, without
+// the admin scope.
+func (t *TestServer) AsOwner(ownerId uint) *TestServer {
+	t.claims = auth.Claims{Subject: fmt.Sprint(ownerId)}
+	return t
+}
+
+func (t *TestServer) RegisterHandler(path string, s store.Store, handlerFunc func(s store.Store, w http.ResponseWriter, r *http.Request)) *TestServer {
 	t.request.HandleFunc(path, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		handlerFunc(db, w, r)
+		handlerFunc(s, w, r.WithContext(auth.NewContext(r.Context(), t.claims)))
 	}))
 	return t
 }
+
+// NewIntegrationDb connects to the MySQL database configured through the DB_*
+// environment variables and migrates it. Tests using it are skipped when
+// DB_HOST is not set.
+func NewIntegrationDb(t *testing.T) *gorm.DB {
+	conf := config.GetConfig()
+	if conf.DB.Host == "" {
+		t.Skip("DB_HOST not set, skipping integration test")
+	}
+	dbURI := fmt.Sprintf("%s:%s@tcp(%s:%d)/%s?charset=%s&parseTime=True",
+		conf.DB.Username,
+		conf.DB.Password,
+		conf.DB.Host,
+		conf.DB.Port,
+		conf.DB.Name,
+		conf.DB.Charset)
+	db, err := gorm.Open(conf.DB.Dialect, dbURI)
+	if err != nil {
+		t.Fatalf("failed to connect to integration database: %s", err.Error())
+	}
+	db, err = model.DBMigrate(db)
+	if err != nil {
+		t.Fatalf("failed to migrate integration database: %s", err.Error())
+	}
+	db.LogMode(false)
+	return db
+}